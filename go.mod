@@ -2,7 +2,10 @@ module tacnet-odenwakun
 
 go 1.24.4
 
-require github.com/bwmarrin/discordgo v0.28.1
+require (
+	github.com/bwmarrin/discordgo v0.28.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cloudwebrtc/go-sip-ua v1.1.5 // indirect
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/config"
+)
+
+// dialCommands は発信に関わるコマンドと、同じ権限で守るボタン・モーダルの接頭辞
+var dialCommands = map[string][]string{
+	"oki":         {"oki"},
+	"oki-hangup":  {"oki-hangup"},
+	"call":        {"call", "call-pick"},
+	"oncall call": {"oncall call"},
+	"incoming":    {"incoming-reject", "incoming-transfer", "incoming-transfer-submit"}, // 着信のボタン
+}
+
+// applyAccess は設定に従ってコマンドを使える人を絞る
+func applyAccess(r *commands.Router, a config.Access) {
+	dial := commands.Permission{Roles: a.DialRoles, Users: a.DialUsers}
+	for _, names := range dialCommands {
+		r.Restrict(dial, names...)
+	}
+	for name, ca := range a.Commands {
+		names, ok := dialCommands[name]
+		if !ok {
+			names = []string{name}
+		}
+		r.Restrict(commands.Permission{Roles: ca.Roles, Users: ca.Users}, names...)
+	}
+}
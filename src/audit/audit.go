@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Action はBotが行った操作の種類
+type Action string
+
+const (
+	ActionCall     Action = "call"     // 発信（終わった時点で結果とともに記録）
+	ActionHangup   Action = "hangup"   // 発信の切断・取り消し
+	ActionReject   Action = "reject"   // 着信の拒否
+	ActionTransfer Action = "transfer" // 着信の転送
+	ActionDenied   Action = "denied"   // 権限や番号ルールで断った操作
+	ActionCommand  Action = "command"  // その他のコマンド（電話帳の編集など）
+)
+
+// Entry は監査ログの1行
+type Entry struct {
+	Time      time.Time  `json:"time"`
+	Action    Action     `json:"action"`
+	UserID    string     `json:"user_id,omitempty"`
+	User      string     `json:"user,omitempty"`
+	GuildID   string     `json:"guild_id,omitempty"`
+	ChannelID string     `json:"channel_id,omitempty"`
+	Command   string     `json:"command,omitempty"` // 実行したコマンド（サブコマンド含む）
+	Target    string     `json:"target,omitempty"`  // 発信先・転送先など
+	Result    string     `json:"result,omitempty"`  // answered / failed / ok / error など
+	SIPCode   int        `json:"sip_code,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Detail    string     `json:"detail,omitempty"`
+}
+
+// Log はJSONLファイルに追記するだけの監査ログ（書き換え・削除はしない）
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open は path に追記するログを返す。path が空なら記録しない（Enabled が false）。
+func Open(path string) (*Log, error) {
+	if path == "" {
+		return &Log{}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// 書けるかを起動時に確かめる
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &Log{path: path}, nil
+}
+
+func (l *Log) Enabled() bool { return l != nil && l.path != "" }
+
+// Append は1行追記する
+func (l *Log) Append(e Entry) error {
+	if !l.Enabled() {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Filter は Query の絞り込み条件（ゼロ値の項目は絞らない）
+type Filter struct {
+	Since  time.Time
+	UserID string
+	Action Action
+	Limit  int // 新しい方から最大件数
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.UserID == "" || e.UserID == f.UserID) &&
+		(f.Action == "" || e.Action == f.Action)
+}
+
+// Query は条件に合う行を新しい順に返す。壊れた行は飛ばす。
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	if !l.Enabled() {
+		return nil, errors.New("audit log is disabled")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out []Entry
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) != nil || !f.match(e) {
+			continue
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) > f.Limit {
+			out = out[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	slices.Reverse(out)
+	return out, nil
+}
+
+// WriteCSV は行をCSV（見出し付き）で書き出す
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"time", "action", "user_id", "user", "guild_id", "channel_id", "command", "target", "result", "sip_code", "started_at", "ended_at", "detail"})
+	for _, e := range entries {
+		code := ""
+		if e.SIPCode != 0 {
+			code = strconv.Itoa(e.SIPCode)
+		}
+		_ = cw.Write([]string{
+			e.Time.Format(time.RFC3339), string(e.Action), e.UserID, e.User, e.GuildID, e.ChannelID,
+			e.Command, e.Target, e.Result, code, timeString(e.StartedAt), timeString(e.EndedAt), e.Detail,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("write csv: %w", err)
+	}
+	return nil
+}
+
+func timeString(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/audit"
+	"tacnet-odenwakun/src/commands"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// auditEntry は実行者・サーバー・チャンネルを埋めた監査ログの行を作る
+func auditEntry(c *commands.Context, action audit.Action, target string) audit.Entry {
+	e := audit.Entry{
+		Time:      time.Now(),
+		Action:    action,
+		GuildID:   c.Interaction.GuildID,
+		ChannelID: c.Interaction.ChannelID,
+		Target:    target,
+	}
+	if u := c.User(); u != nil {
+		e.UserID, e.User = u.ID, u.Username
+	}
+	if c.Interaction.Type == discordgo.InteractionApplicationCommand {
+		e.Command = c.Interaction.ApplicationCommandData().Name
+		if c.Subcommand != "" {
+			e.Command += " " + c.Subcommand
+		}
+	}
+	return e
+}
+
+func appendAudit(al *audit.Log, e audit.Entry) {
+	if err := al.Append(e); err != nil {
+		log.Printf("[audit] append failed: %v", err)
+	}
+}
+
+// auditObserver はコマンドの実行を記録する。発信系は各ハンドラが結果付きで記録するので、権限で断ったときだけ記録する。
+func auditObserver(al *audit.Log) commands.Observer {
+	dial := map[string]bool{}
+	for _, names := range dialCommands {
+		for _, n := range names {
+			dial[n] = true
+		}
+	}
+	return func(c *commands.Context, name string, err error) {
+		if errors.Is(err, commands.ErrDenied) {
+			e := auditEntry(c, audit.ActionDenied, "")
+			e.Command, e.Result = name, "permission"
+			appendAudit(al, e)
+			return
+		}
+		if dial[name] || dial[strings.Fields(name)[0]] {
+			return
+		}
+		e := auditEntry(c, audit.ActionCommand, "")
+		e.Command, e.Result = name, "ok"
+		if err != nil {
+			e.Result, e.Detail = "error", err.Error()
+		}
+		appendAudit(al, e)
+	}
+}
+
+var auditActions = []audit.Action{
+	audit.ActionCall, audit.ActionHangup, audit.ActionReject, audit.ActionTransfer, audit.ActionDenied, audit.ActionCommand,
+}
+
+// auditCommand は監査ログを見る・CSVで書き出す管理者向けコマンド
+func auditCommand(al *audit.Log) *commands.Command {
+	var actionChoices []*discordgo.ApplicationCommandOptionChoice
+	for _, a := range auditActions {
+		actionChoices = append(actionChoices, &discordgo.ApplicationCommandOptionChoice{Name: string(a), Value: string(a)})
+	}
+	minOne := 1.0
+	return &commands.Command{
+		Name:        "audit",
+		Description: "Botの操作の記録（発信・転送・管理コマンド）を見る",
+		AdminOnly:   true,
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "実行した人"},
+			{Type: discordgo.ApplicationCommandOptionString, Name: "action", Description: "操作の種類", Choices: actionChoices},
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "hours", Description: "さかのぼる時間（既定 24）", MinValue: &minOne},
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "limit", Description: "件数（既定 20、CSVは既定 1000）", MinValue: &minOne, MaxValue: 10000},
+			{Type: discordgo.ApplicationCommandOptionBoolean, Name: "csv", Description: "CSVファイルで受け取る"},
+		},
+		Handler: func(c *commands.Context) error {
+			if !al.Enabled() {
+				return commands.Errorf("監査ログが無効です（AUDIT_FILE を設定してください）")
+			}
+			hours := c.Int("hours")
+			if hours == 0 {
+				hours = 24
+			}
+			f := audit.Filter{
+				Since:  time.Now().Add(-time.Duration(hours) * time.Hour),
+				Action: audit.Action(c.String("action")),
+				Limit:  int(c.Int("limit")),
+			}
+			f.UserID = c.UserID("user")
+			asCSV := c.Bool("csv")
+			if f.Limit == 0 {
+				f.Limit = 20
+				if asCSV {
+					f.Limit = 1000
+				}
+			}
+			entries, err := al.Query(f)
+			if err != nil {
+				return err
+			}
+			if asCSV {
+				var buf bytes.Buffer
+				if err := audit.WriteCSV(&buf, entries); err != nil {
+					return err
+				}
+				name := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102-150405"))
+				return c.ReplyFile(fmt.Sprintf("🧾 監査ログ %d 件（直近 %d 時間）", len(entries), hours), name, "text/csv", &buf, true)
+			}
+			return c.ReplyEmbed("", auditEmbed(entries, hours), true)
+		},
+	}
+}
+
+func auditEmbed(entries []audit.Entry, hours int64) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("🧾 監査ログ（直近 %d 時間・%d 件）", hours, len(entries)),
+		Color: 0x95A5A6, // grey
+	}
+	if len(entries) == 0 {
+		embed.Description = "記録はありません"
+		return embed
+	}
+	var sb strings.Builder
+	for i, e := range entries {
+		line := auditLine(e)
+		// Embedの説明は4096文字まで
+		if sb.Len()+len(line) > 3900 {
+			fmt.Fprintf(&sb, "…ほか %d 件（csv:True で全件）", len(entries)-i)
+			break
+		}
+		sb.WriteString(line + "\n")
+	}
+	embed.Description = sb.String()
+	return embed
+}
+
+func auditLine(e audit.Entry) string {
+	parts := []string{fmt.Sprintf("`%s` **%s**", e.Time.Local().Format("01/02 15:04:05"), e.Action)}
+	if e.UserID != "" {
+		parts = append(parts, fmt.Sprintf("<@%s>", e.UserID))
+	}
+	if e.Command != "" {
+		parts = append(parts, "/"+e.Command)
+	}
+	if e.Target != "" {
+		parts = append(parts, "→ "+e.Target)
+	}
+	result := e.Result
+	if e.SIPCode != 0 {
+		result = fmt.Sprintf("%s %d", result, e.SIPCode)
+	}
+	if result != "" {
+		parts = append(parts, "（"+strings.TrimSpace(result)+"）")
+	}
+	if e.Detail != "" {
+		parts = append(parts, truncateRunes(e.Detail, 80))
+	}
+	return strings.Join(parts, " ")
+}
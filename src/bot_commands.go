@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/audit"
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/sipclient"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// botCommands はBotのスラッシュコマンド一覧。新しいコマンドはここに追加する。
+func botCommands(oki *sipclient.OkiSIP, al *audit.Log) []*commands.Command {
+	okiOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "number",
+			Description: "電話番号",
+			Required:    true,
+			MaxLength:   32,
+		},
+	}
+	if names := oki.Announcements(); len(names) > 0 {
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, name := range names {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+		}
+		okiOptions = append(okiOptions, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "message",
+			Description: "応答したら流して切る音声",
+			Choices:     choices,
+		})
+	}
+	return []*commands.Command{
+		{
+			// おまけ: ランダムで返答
+			Name:        "denwachan",
+			Description: "でんわちゃんを呼ぶ",
+			Handler: func(c *commands.Context) error {
+				replies := []string{
+					"わぁっ！",
+					"なんでしょうか？",
+					"どうされましたか",
+				}
+				return c.Reply(replies[rand.Intn(len(replies))])
+			},
+		},
+		{
+			Name:        "oki",
+			Description: "OKI回線から発信する",
+			Options:     okiOptions,
+			Handler: func(c *commands.Context) error {
+				number := c.String("number")
+				if !sipclient.Dialable(number) {
+					return commands.Errorf("電話番号は数字（と * # +）で指定してください: %s", number)
+				}
+				return dial(c, oki, al, number, number, c.String("message"))
+			},
+		},
+		{
+			Name:        "oki-calls",
+			Description: "OKI回線で進行中の発信を一覧する",
+			Handler: func(c *commands.Context) error {
+				calls := oki.ActiveCalls()
+				if len(calls) == 0 {
+					return c.ReplyEphemeral("進行中の発信はありません")
+				}
+				var lines []string
+				for _, call := range calls {
+					lines = append(lines, activeCallLine(call))
+				}
+				return c.ReplyEphemeral(strings.Join(lines, "\n"))
+			},
+		},
+		{
+			Name:        "oki-hangup",
+			Description: "OKI回線の発信を切断する（呼出中はCANCEL、通話中はBYE）",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "call",
+					Description:  "切断する発信",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+			Autocomplete: map[string]commands.AutocompleteHandler{
+				"call": func(c *commands.Context, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+					q := strings.ToLower(strings.TrimSpace(focused.StringValue()))
+					var choices []*discordgo.ApplicationCommandOptionChoice
+					for _, call := range oki.ActiveCalls() {
+						if call.ID() == "" {
+							continue
+						}
+						if q != "" && !strings.Contains(call.Number, q) && !strings.Contains(strings.ToLower(call.ID()), q) {
+							continue
+						}
+						choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+							Name:  fmt.Sprintf("%s（%s）", call.Number, callStateText(call.State())),
+							Value: call.ID(),
+						})
+						if len(choices) == 25 { // Discordの候補の上限
+							break
+						}
+					}
+					return choices
+				},
+			},
+			Handler: func(c *commands.Context) error {
+				id := c.String("call")
+				call, ok := oki.Call(id)
+				if !ok {
+					return commands.Errorf("その発信は見つからないか、すでに終了しています")
+				}
+				user := c.User()
+				reason := "Discordから切断"
+				if user != nil {
+					reason = user.Username + " が切断"
+				}
+				e := auditEntry(c, audit.ActionHangup, call.Number)
+				e.Detail = "call-id " + call.ID()
+				if err := call.Hangup(reason); err != nil {
+					e.Result = "error: " + err.Error()
+					appendAudit(al, e)
+					return commands.Errorf("切断エラー: %v", err)
+				}
+				e.Result = "ok"
+				appendAudit(al, e)
+				return c.Reply(fmt.Sprintf("📴 切断しました: %s", call.Number))
+			},
+		},
+	}
+}
+
+func callStateText(s sipclient.CallState) string {
+	switch s {
+	case sipclient.CallRinging:
+		return "呼び出し中"
+	case sipclient.CallAnswered:
+		return "通話中"
+	case sipclient.CallFailed, sipclient.CallEnded:
+		return "終了"
+	default:
+		return "発信中"
+	}
+}
+
+func activeCallLine(call *sipclient.Call) string {
+	elapsed := time.Since(call.StartedAt)
+	if call.State() == sipclient.CallAnswered {
+		elapsed = call.Duration()
+	}
+	return fmt.Sprintf("- %s（%s・%s）`%s`", call.Number, callStateText(call.State()), formatDuration(elapsed), call.ID())
+}
+
+// dial は number に発信し、応答メッセージを通話の進行に合わせて書き換える。label は表示用（番号か「名前(番号)」）。
+// announcement を指定すると応答後にその音声を流して切る。発信は終わった時点で結果とともに監査ログに残す。
+func dial(c *commands.Context, oki *sipclient.OkiSIP, al *audit.Log, number, label, announcement string) error {
+	entry := auditEntry(c, audit.ActionCall, number)
+	if label != number {
+		entry.Detail = label
+	}
+	var (
+		call *sipclient.Call
+		err  error
+	)
+	if announcement != "" {
+		entry.Detail = strings.TrimSpace(entry.Detail + " announce " + announcement)
+		label = fmt.Sprintf("%s 📢%s", label, announcement)
+		call, err = oki.InvitePlay(number, announcement)
+	} else {
+		call, err = oki.Invite(number)
+	}
+	if errors.Is(err, sipclient.ErrNumberDenied) {
+		if u := c.User(); u != nil {
+			log.Printf("[oki] %s (%s) was refused dialing %s: %v", u.Username, u.ID, number, err)
+		}
+		entry.Action, entry.Result, entry.Detail = audit.ActionDenied, "number rule", err.Error()
+		appendAudit(al, entry)
+		return commands.Errorf("🚫 この番号には発信できません: %s", label)
+	}
+	if err != nil {
+		entry.Result, entry.Detail = "error", err.Error()
+		appendAudit(al, entry)
+		return commands.Errorf("発信エラー: %v", err)
+	}
+	if err := c.Reply(callProgressText(label, call, sipclient.CallEvent{State: sipclient.CallTrying})); err != nil {
+		return err
+	}
+	go func() {
+		for ev := range call.Events() {
+			if err := c.Edit(callProgressText(label, call, ev)); err != nil {
+				log.Printf("[oki] progress edit failed: %v", err)
+			}
+		}
+		// Events は終わると閉じる
+		started, ended := call.StartedAt, time.Now()
+		entry.StartedAt, entry.EndedAt = &started, &ended
+		code, reason := call.Result()
+		entry.Result, entry.SIPCode = call.State().String(), code
+		if d := call.Duration(); d > 0 {
+			entry.Result = "answered"
+			reason = strings.TrimSpace(fmt.Sprintf("%s talk %s", reason, d.Round(time.Second)))
+		}
+		if reason != "" {
+			entry.Detail = strings.TrimSpace(entry.Detail + " " + reason)
+		}
+		appendAudit(al, entry)
+	}()
+	return nil
+}
+
+// callProgressText は発信の進行状況を1行で表す
+func callProgressText(label string, call *sipclient.Call, ev sipclient.CallEvent) string {
+	switch ev.State {
+	case sipclient.CallRinging:
+		return fmt.Sprintf("🔔 OKIコール発信: %s（呼び出し中…）", label)
+	case sipclient.CallAnswered:
+		return fmt.Sprintf("🗣️ OKIコール: %s（通話中）", label)
+	case sipclient.CallFailed:
+		return fmt.Sprintf("❌ OKIコール失敗: %s（%s）", label, sipResultText(ev.Code, ev.Reason))
+	case sipclient.CallEnded:
+		return fmt.Sprintf("☎️ OKIコール終了: %s（通話時間 %s）", label, formatDuration(call.Duration()))
+	default:
+		return fmt.Sprintf("📞 OKIコール発信: %s（発信中…）", label)
+	}
+}
+
+func sipResultText(code int, reason string) string {
+	switch {
+	case code != 0 && reason != "":
+		return fmt.Sprintf("%d %s", code, reason)
+	case code != 0:
+		return fmt.Sprintf("%d", code)
+	case reason != "":
+		return reason
+	default:
+		return "不明なエラー"
+	}
+}
+
+// formatDuration は「1分23秒」形式にする
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	sec := int(d % time.Minute / time.Second)
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%d時間%d分%d秒", h, m, sec)
+	case m > 0:
+		return fmt.Sprintf("%d分%d秒", m, sec)
+	default:
+		return fmt.Sprintf("%d秒", sec)
+	}
+}
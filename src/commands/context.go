@@ -0,0 +1,246 @@
+package commands
+
+import (
+	"io"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Context は1回のコマンド実行の情報と応答用ヘルパ
+type Context struct {
+	Session     *discordgo.Session
+	Interaction *discordgo.InteractionCreate
+	// Subcommand はサブコマンド付きコマンドの場合のサブコマンド名
+	Subcommand string
+	// Payload はボタン・モーダルの custom_id の "prefix:" 以降
+	Payload string
+
+	options   map[string]*discordgo.ApplicationCommandInteractionDataOption
+	raw       []*discordgo.ApplicationCommandInteractionDataOption
+	values    map[string]string // モーダルの入力値
+	responded bool
+}
+
+func newContext(s *discordgo.Session, i *discordgo.InteractionCreate, opts []*discordgo.ApplicationCommandInteractionDataOption) *Context {
+	c := &Context{Session: s, Interaction: i}
+	// サブコマンド（グループ含む）は1段ずつ潜ってオプションを取り出す
+	for len(opts) == 1 && (opts[0].Type == discordgo.ApplicationCommandOptionSubCommand ||
+		opts[0].Type == discordgo.ApplicationCommandOptionSubCommandGroup) {
+		if c.Subcommand != "" {
+			c.Subcommand += " "
+		}
+		c.Subcommand += opts[0].Name
+		opts = opts[0].Options
+	}
+	c.raw = opts
+	c.options = make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(opts))
+	for _, o := range opts {
+		c.options[o.Name] = o
+	}
+	return c
+}
+
+// User は実行者を返す
+func (c *Context) User() *discordgo.User {
+	if c.Interaction.Member != nil && c.Interaction.Member.User != nil {
+		return c.Interaction.Member.User
+	}
+	return c.Interaction.User
+}
+
+// Has はオプションが指定されたかを返す
+func (c *Context) Has(name string) bool {
+	_, ok := c.options[name]
+	return ok
+}
+
+func (c *Context) String(name string) string {
+	if o, ok := c.options[name]; ok {
+		return o.StringValue()
+	}
+	return ""
+}
+
+func (c *Context) Int(name string) int64 {
+	if o, ok := c.options[name]; ok {
+		return o.IntValue()
+	}
+	return 0
+}
+
+func (c *Context) Bool(name string) bool {
+	if o, ok := c.options[name]; ok {
+		return o.BoolValue()
+	}
+	return false
+}
+
+// UserID はユーザー指定オプションのユーザーIDを返す
+func (c *Context) UserID(name string) string {
+	if o, ok := c.options[name]; ok {
+		return o.UserValue(nil).ID
+	}
+	return ""
+}
+
+// ResolvedUser はユーザー指定オプションのユーザーを返す（名前などはDiscordが添えたもの）
+func (c *Context) ResolvedUser(name string) *discordgo.User {
+	id := c.UserID(name)
+	if id == "" {
+		return nil
+	}
+	if r := c.Interaction.ApplicationCommandData().Resolved; r != nil {
+		if u, ok := r.Users[id]; ok {
+			return u
+		}
+	}
+	return &discordgo.User{ID: id}
+}
+
+// Value はモーダルのテキスト入力値を返す
+func (c *Context) Value(id string) string {
+	return c.values[id]
+}
+
+func (c *Context) focused() *discordgo.ApplicationCommandInteractionDataOption {
+	for _, o := range c.raw {
+		if o.Focused {
+			return o
+		}
+	}
+	return nil
+}
+
+// Reply は全員に見える応答を返す
+func (c *Context) Reply(content string) error {
+	return c.respond(&discordgo.InteractionResponseData{Content: content})
+}
+
+// ReplyEphemeral は実行者にだけ見える応答を返す
+func (c *Context) ReplyEphemeral(content string) error {
+	return c.respond(&discordgo.InteractionResponseData{Content: content, Flags: discordgo.MessageFlagsEphemeral})
+}
+
+// ReplyEmbed は Embed 付きの応答を返す
+func (c *Context) ReplyEmbed(content string, embed *discordgo.MessageEmbed, ephemeral bool) error {
+	data := &discordgo.InteractionResponseData{Content: content, Embeds: []*discordgo.MessageEmbed{embed}}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	return c.respond(data)
+}
+
+// ReplyComponents はボタン等の付いた応答を返す
+func (c *Context) ReplyComponents(content string, components []discordgo.MessageComponent, ephemeral bool) error {
+	data := &discordgo.InteractionResponseData{Content: content, Components: components}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	return c.respond(data)
+}
+
+// ReplyFile はファイルを添付した応答を返す
+func (c *Context) ReplyFile(content, name, contentType string, r io.Reader, ephemeral bool) error {
+	data := &discordgo.InteractionResponseData{
+		Content: content,
+		Files:   []*discordgo.File{{Name: name, ContentType: contentType, Reader: r}},
+	}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	return c.respond(data)
+}
+
+// Defer は「考え中…」を返し、後から Edit で本応答に差し替えられるようにする
+func (c *Context) Defer(ephemeral bool) error {
+	data := &discordgo.InteractionResponseData{}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	err := c.Session.InteractionRespond(c.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: data,
+	})
+	if err == nil {
+		c.responded = true
+	}
+	return err
+}
+
+// Edit は最初の応答を書き換える
+func (c *Context) Edit(content string) error {
+	_, err := c.Session.InteractionResponseEdit(c.Interaction.Interaction, &discordgo.WebhookEdit{Content: &content})
+	return err
+}
+
+// EditEmbed は最初の応答を Embed 付きで書き換える
+func (c *Context) EditEmbed(content string, embed *discordgo.MessageEmbed) error {
+	return c.EditEmbeds(content, []*discordgo.MessageEmbed{embed})
+}
+
+// EditEmbeds は最初の応答を複数の Embed（最大10）で書き換える
+func (c *Context) EditEmbeds(content string, embeds []*discordgo.MessageEmbed) error {
+	_, err := c.Session.InteractionResponseEdit(c.Interaction.Interaction, &discordgo.WebhookEdit{Content: &content, Embeds: &embeds})
+	return err
+}
+
+// Update はボタンが付いていたメッセージ自体を書き換える（コンポーネント操作への応答）
+func (c *Context) Update(content string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) error {
+	err := c.Session.InteractionRespond(c.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{Content: content, Embeds: embeds, Components: components},
+	})
+	if err == nil {
+		c.responded = true
+	}
+	return err
+}
+
+// DeferUpdate はコンポーネント操作を受け付けたことだけ返す（メッセージは別途書き換える）
+func (c *Context) DeferUpdate() error {
+	err := c.Session.InteractionRespond(c.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err == nil {
+		c.responded = true
+	}
+	return err
+}
+
+// ShowModal はテキスト入力のモーダルを開く
+func (c *Context) ShowModal(customID, title string, inputs ...discordgo.TextInput) error {
+	var rows []discordgo.MessageComponent
+	for _, in := range inputs {
+		rows = append(rows, discordgo.ActionsRow{Components: []discordgo.MessageComponent{in}})
+	}
+	err := c.Session.InteractionRespond(c.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{CustomID: customID, Title: title, Components: rows},
+	})
+	if err == nil {
+		c.responded = true
+	}
+	return err
+}
+
+// respond は初回なら応答、応答済みならフォローアップとして送る
+func (c *Context) respond(data *discordgo.InteractionResponseData) error {
+	if c.responded {
+		_, err := c.Session.FollowupMessageCreate(c.Interaction.Interaction, true, &discordgo.WebhookParams{
+			Content:    data.Content,
+			Embeds:     data.Embeds,
+			Components: data.Components,
+			Files:      data.Files,
+			Flags:      data.Flags,
+		})
+		return err
+	}
+	err := c.Session.InteractionRespond(c.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+	if err == nil {
+		c.responded = true
+	}
+	return err
+}
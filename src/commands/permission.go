@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"errors"
+	"log"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ErrDenied は権限が無くて実行しなかったことを Observer に伝える
+var ErrDenied = errors.New("permission denied")
+
+// Permission はコマンドを使えるロールとユーザー。どちらも空なら誰でも使える。
+type Permission struct {
+	Roles []string // ロールID
+	Users []string // ユーザーID
+}
+
+func (p Permission) open() bool { return len(p.Roles) == 0 && len(p.Users) == 0 }
+
+// allows は実行者がロールかユーザーのどちらかで許可されているかを返す
+func (p Permission) allows(i *discordgo.InteractionCreate) bool {
+	if p.open() {
+		return true
+	}
+	var userID string
+	var roles []string
+	switch {
+	case i.Member != nil && i.Member.User != nil:
+		userID, roles = i.Member.User.ID, i.Member.Roles
+	case i.User != nil:
+		userID = i.User.ID
+	}
+	if userID != "" && slices.Contains(p.Users, userID) {
+		return true
+	}
+	for _, r := range roles {
+		if slices.Contains(p.Roles, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Restrict は names（コマンド名、"コマンド サブコマンド"、またはボタン・モーダルの custom_id の接頭辞）を p で守る
+func (r *Router) Restrict(p Permission, names ...string) {
+	for _, n := range names {
+		if p.open() {
+			delete(r.perms, n)
+			continue
+		}
+		r.perms[n] = p
+	}
+}
+
+// permitted は name を実行してよいかを返す。拒否したら記録して実行者に伝える。
+func (r *Router) permitted(c *Context, name string) bool {
+	p, ok := r.perms[name]
+	if !ok || p.allows(c.Interaction) {
+		return true
+	}
+	who, id := "unknown", ""
+	if u := c.User(); u != nil {
+		who, id = u.Username, u.ID
+	}
+	log.Printf("[commands] denied %s to %s (%s) in channel %s", name, who, id, c.Interaction.ChannelID)
+	if err := c.ReplyEphemeral("🚫 このコマンドを使う権限がありません"); err != nil {
+		log.Printf("[commands] %s denial reply failed: %v", name, err)
+	}
+	return false
+}
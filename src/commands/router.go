@@ -0,0 +1,218 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Handler はスラッシュコマンドの本体。error を返すと実行者にだけ見えるメッセージで通知する。
+type Handler func(c *Context) error
+
+// AutocompleteHandler は入力中オプションの候補を返す
+type AutocompleteHandler func(c *Context, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice
+
+// Command は1つのスラッシュコマンドの宣言
+type Command struct {
+	Name        string
+	Description string
+	Options     []*discordgo.ApplicationCommandOption
+	Handler     Handler
+	// Autocomplete はオプション名ごとの候補関数（対応するOptionは Autocomplete: true にする）
+	Autocomplete map[string]AutocompleteHandler
+	// AdminOnly ならサーバー管理権限のある人にだけ表示する（Discordの連携サービス設定で変更できる）
+	AdminOnly bool
+}
+
+// UserError は実行者向けの説明（入力ミス等）。ログには残さない。
+type UserError struct{ Msg string }
+
+func (e *UserError) Error() string { return e.Msg }
+
+// Errorf は実行者に見せるエラーを作る
+func Errorf(format string, args ...any) error {
+	return &UserError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// Router はコマンドの登録とInteractionの振り分けを行う
+type Router struct {
+	cmds       map[string]*Command
+	order      []string
+	components map[string]Handler    // custom_id の接頭辞 -> ハンドラ
+	perms      map[string]Permission // コマンド名または custom_id の接頭辞 -> 使える人
+	observer   Observer
+}
+
+// Observer はコマンド・ボタンを実行した（または断った）後に呼ばれる。
+// name はコマンド名（サブコマンド付きなら "phonebook add"）か custom_id の接頭辞、err はハンドラの結果（拒否なら ErrDenied）。
+type Observer func(c *Context, name string, err error)
+
+// Observe は実行の記録先を設定する
+func (r *Router) Observe(fn Observer) {
+	r.observer = fn
+}
+
+func NewRouter() *Router {
+	return &Router{cmds: map[string]*Command{}, components: map[string]Handler{}, perms: map[string]Permission{}}
+}
+
+// Component はボタン・モーダル送信のハンドラを登録する。
+// custom_id は "prefix:payload" 形式で、payload は Context.Payload で受け取れる。
+func (r *Router) Component(prefix string, h Handler) {
+	r.components[prefix] = h
+}
+
+// Add はコマンドを追加する（同名は後勝ち）
+func (r *Router) Add(cmds ...*Command) {
+	for _, c := range cmds {
+		if _, ok := r.cmds[c.Name]; !ok {
+			r.order = append(r.order, c.Name)
+		}
+		r.cmds[c.Name] = c
+	}
+}
+
+// Register はDiscordにコマンド定義を一括登録する。guildID が空ならグローバル登録。
+// セッションはOpen済み（State.User が取れる）である必要がある。
+func (r *Router) Register(s *discordgo.Session, guildID string) error {
+	if s.State == nil || s.State.User == nil {
+		return errors.New("discord session is not ready")
+	}
+	noDM := false
+	admin := int64(discordgo.PermissionManageServer)
+	var defs []*discordgo.ApplicationCommand
+	for _, name := range r.order {
+		c := r.cmds[name]
+		def := &discordgo.ApplicationCommand{
+			Name:         c.Name,
+			Description:  c.Description,
+			Options:      c.Options,
+			DMPermission: &noDM, // ギルド内のみ
+		}
+		if c.AdminOnly {
+			def.DefaultMemberPermissions = &admin
+		}
+		defs = append(defs, def)
+	}
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, defs)
+	return err
+}
+
+// Handle は discordgo の InteractionCreate ハンドラとして使う
+func (r *Router) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		cmd, ok := r.cmds[data.Name]
+		if !ok || cmd.Handler == nil {
+			return
+		}
+		c := newContext(s, i, data.Options)
+		name := data.Name
+		if c.Subcommand != "" {
+			name += " " + c.Subcommand
+		}
+		// コマンド全体とサブコマンド単位（"oncall call" など）の両方で確かめる
+		if !r.permitted(c, data.Name) || (name != data.Name && !r.permitted(c, name)) {
+			r.observe(c, name, ErrDenied)
+			return
+		}
+		err := cmd.Handler(c)
+		r.observe(c, name, err)
+		r.finish(c, data.Name, err)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		data := i.ApplicationCommandData()
+		cmd, ok := r.cmds[data.Name]
+		if !ok {
+			return
+		}
+		c := newContext(s, i, data.Options)
+		focused := c.focused()
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		// 使えない人には候補（電話帳など）も見せない。入力のたびに来るので記録はしない。
+		if p, ok := r.perms[data.Name]; ok && !p.allows(i) {
+			focused = nil
+		}
+		if focused != nil {
+			if h, ok := cmd.Autocomplete[focused.Name]; ok {
+				choices = h(c, focused)
+			}
+		}
+		if len(choices) > 25 {
+			choices = choices[:25] // Discordの上限
+		}
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{Choices: choices},
+		})
+		if err != nil {
+			log.Printf("[commands] autocomplete %s: %v", data.Name, err)
+		}
+	case discordgo.InteractionMessageComponent:
+		r.dispatchComponent(s, i, i.MessageComponentData().CustomID, nil)
+	case discordgo.InteractionModalSubmit:
+		data := i.ModalSubmitData()
+		r.dispatchComponent(s, i, data.CustomID, modalValues(data.Components))
+	}
+}
+
+func (r *Router) dispatchComponent(s *discordgo.Session, i *discordgo.InteractionCreate, customID string, values map[string]string) {
+	prefix, payload, _ := strings.Cut(customID, ":")
+	h, ok := r.components[prefix]
+	if !ok {
+		return
+	}
+	c := newContext(s, i, nil)
+	c.Payload = payload
+	c.values = values
+	if !r.permitted(c, prefix) {
+		r.observe(c, prefix, ErrDenied)
+		return
+	}
+	err := h(c)
+	r.observe(c, prefix, err)
+	r.finish(c, customID, err)
+}
+
+// modalValues はモーダルのテキスト入力を custom_id -> 値 にする
+func modalValues(comps []discordgo.MessageComponent) map[string]string {
+	out := map[string]string{}
+	for _, comp := range comps {
+		row, ok := comp.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, inner := range row.Components {
+			if ti, ok := inner.(*discordgo.TextInput); ok {
+				out[ti.CustomID] = ti.Value
+			}
+		}
+	}
+	return out
+}
+
+func (r *Router) observe(c *Context, name string, err error) {
+	if r.observer != nil {
+		r.observer(c, name, err)
+	}
+}
+
+// finish はハンドラのエラーを実行者にだけ見える形で返す
+func (r *Router) finish(c *Context, name string, err error) {
+	if err == nil {
+		return
+	}
+	var ue *UserError
+	msg := "エラー: " + err.Error()
+	if errors.As(err, &ue) {
+		msg = ue.Msg
+	} else {
+		log.Printf("[commands] %s failed: %v", name, err)
+	}
+	if rerr := c.ReplyEphemeral(msg); rerr != nil {
+		log.Printf("[commands] %s error reply failed: %v", name, rerr)
+	}
+}
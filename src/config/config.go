@@ -0,0 +1,467 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config はBot全体の設定。YAMLファイルを読み、env（タグの env 名）で上書きする。
+// secret:"true" の項目は --print-config で伏せ字になる。
+type Config struct {
+	Discord     Discord     `yaml:"discord"`
+	MikoPBX     MikoPBX     `yaml:"mikopbx"`
+	Sites       []Site      `yaml:"sites,omitempty"` // 複数PBXを監視する場合（指定時は mikopbx セクションの接続先は使わない）
+	SIP         SIP         `yaml:"sip"`
+	Watcher     Watcher     `yaml:"watcher"`
+	CDR         CDR         `yaml:"cdr"`
+	Calls       Calls       `yaml:"calls"`
+	Phonebook   Phonebook   `yaml:"phonebook"`
+	Access      Access      `yaml:"access"`
+	Audit       Audit       `yaml:"audit"`
+	OnCall      OnCall      `yaml:"oncall"`
+	Escalation  Escalation  `yaml:"escalation"`
+	Maintenance Maintenance `yaml:"maintenance"`
+	Routing     Routing     `yaml:"routing"`
+	Notify      Notify      `yaml:"notify"`
+	HTTP        HTTP        `yaml:"http"`
+}
+
+type Discord struct {
+	Token     string `yaml:"token" env:"DISCORD_TOKEN" secret:"true"`
+	ChannelID string `yaml:"channel_id" env:"DISCORD_CHANNEL_ID"`
+	GuildID   string `yaml:"guild_id" env:"DISCORD_GUILD_ID"` // 空ならグローバル登録
+}
+
+type MikoPBX struct {
+	BaseURL  string `yaml:"base_url" env:"MIKOPBX_BASE_URL"`
+	Login    string `yaml:"login" env:"MIKOPBX_LOGIN"`
+	Password string `yaml:"password" env:"MIKOPBX_PASSWORD" secret:"true"`
+	// 1回のAPI呼び出しのリトライ上限（どちらか先に達した方で諦める。0で無制限）
+	RetryMaxAttempts   int   `yaml:"retry_max_attempts" env:"MIKOPBX_RETRY_MAX_ATTEMPTS"`
+	RetryMaxElapsedSec int   `yaml:"retry_max_elapsed_sec" env:"MIKOPBX_RETRY_MAX_ELAPSED_SEC"`
+	RetryStatusCodes   []int `yaml:"retry_status_codes" env:"MIKOPBX_RETRY_STATUS_CODES"` // 例: 500,502,503,504
+}
+
+// Site は監視対象のMikoPBX 1台分
+type Site struct {
+	Name            string `yaml:"name"` // 通知に付けるサイト名
+	MikoPBX         `yaml:",inline"`
+	PollIntervalSec int    `yaml:"poll_interval_sec,omitempty"` // 0なら watcher.poll_interval_sec
+	ChannelID       string `yaml:"channel_id,omitempty"`        // 空なら discord.channel_id
+	StateFile       string `yaml:"state_file,omitempty"`        // 空なら watcher.state_file にサイト名を付けたもの
+}
+
+// Targets は監視するPBXの一覧を、省略された項目を全体設定で埋めて返す。
+// sites が空なら mikopbx セクションの1台（Name は空）を返す。
+func (c *Config) Targets() []Site {
+	if len(c.Sites) == 0 {
+		return []Site{{
+			MikoPBX:         c.MikoPBX,
+			PollIntervalSec: c.Watcher.PollIntervalSec,
+			ChannelID:       c.Discord.ChannelID,
+			StateFile:       c.Watcher.StateFile,
+		}}
+	}
+	out := make([]Site, len(c.Sites))
+	for i, s := range c.Sites {
+		if s.RetryMaxAttempts == 0 && s.RetryMaxElapsedSec == 0 {
+			s.RetryMaxAttempts = c.MikoPBX.RetryMaxAttempts
+			s.RetryMaxElapsedSec = c.MikoPBX.RetryMaxElapsedSec
+		}
+		if s.RetryStatusCodes == nil {
+			s.RetryStatusCodes = c.MikoPBX.RetryStatusCodes
+		}
+		if s.PollIntervalSec == 0 {
+			s.PollIntervalSec = c.Watcher.PollIntervalSec
+		}
+		if s.ChannelID == "" {
+			s.ChannelID = c.Discord.ChannelID
+		}
+		if s.StateFile == "" && c.Watcher.StateFile != "" {
+			s.StateFile = siteStateFile(c.Watcher.StateFile, s.Name)
+		}
+		out[i] = s
+	}
+	return out
+}
+
+// siteStateFile は "/data/state.json" を "/data/state-<name>.json" にする
+func siteStateFile(base, name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-" + safe + ext
+}
+
+type SIP struct {
+	Server     string `yaml:"server" env:"OKI_SIP_SERVER"` // host:port
+	User       string `yaml:"user" env:"OKI_SIP_USER"`
+	Password   string `yaml:"password" env:"OKI_SIP_PASSWORD" secret:"true"`
+	Listen     string `yaml:"listen" env:"OKI_SIP_LISTEN"`
+	Transport  string `yaml:"transport" env:"OKI_SIP_TRANSPORT"` // udp|tcp|tls|ws|wss
+	Domain     string `yaml:"domain" env:"OKI_SIP_DOMAIN"`       // 空ならserverのホスト
+	Expires    int    `yaml:"expires" env:"OKI_SIP_EXPIRES"`
+	MaxRingSec int    `yaml:"max_ring_sec" env:"OKI_SIP_MAX_RING_SEC"` // 0で無制限
+	MaxTalkSec int    `yaml:"max_talk_sec" env:"OKI_SIP_MAX_TALK_SEC"` // 0で無制限
+	// 発信・転送してよい番号（INVITEを組み立てる前に確認する）
+	DialAllowPrefixes []string `yaml:"dial_allow_prefixes" env:"OKI_SIP_DIAL_ALLOW_PREFIXES"` // 空なら全て許可
+	DialBlockPrefixes []string `yaml:"dial_block_prefixes" env:"OKI_SIP_DIAL_BLOCK_PREFIXES"` // 許可より優先
+	DialMaxLength     int      `yaml:"dial_max_length" env:"OKI_SIP_DIAL_MAX_LENGTH"`         // 0で無制限
+	DialDigitsOnly    bool     `yaml:"dial_digits_only" env:"OKI_SIP_DIAL_DIGITS_ONLY"`       // * # + を認めない
+	// 応答したら流す音声（名前 -> WAVファイル）。/oki の message で選ぶ。
+	Announcements map[string]string `yaml:"announcements" env:"OKI_SIP_ANNOUNCEMENTS"`
+	MediaIP       string            `yaml:"media_ip" env:"OKI_SIP_MEDIA_IP"` // SDPに書くRTPのアドレス（空ならSIPサーバーへ向かうアドレス）
+}
+
+type Watcher struct {
+	PollIntervalSec   int               `yaml:"poll_interval_sec" env:"POLL_INTERVAL_SEC"`
+	StateFile         string            `yaml:"state_file" env:"STATE_FILE"` // 空なら永続化しない
+	DebounceDownPolls int               `yaml:"debounce_down_polls" env:"DEBOUNCE_DOWN_POLLS"`
+	DebounceUpPolls   int               `yaml:"debounce_up_polls" env:"DEBOUNCE_UP_POLLS"`
+	FlapThreshold     int               `yaml:"flap_threshold" env:"FLAP_THRESHOLD"` // 0で無効
+	FlapWindowMin     int               `yaml:"flap_window_min" env:"FLAP_WINDOW_MIN"`
+	UnreachableAfter  int               `yaml:"unreachable_after_polls" env:"UNREACHABLE_AFTER_POLLS"` // 連続失敗で「PBX到達不能」
+	IncidentMode      string            `yaml:"incident_mode" env:"INCIDENT_MODE"`                     // off|edit|thread
+	IncidentRemindMin int               `yaml:"incident_remind_min" env:"INCIDENT_REMIND_MIN"`         // 0で続報なし
+	StateHealth       map[string]string `yaml:"state_health" env:"STATE_HEALTH_MAP"`                   // STATE -> healthy|degraded|down
+}
+
+// CDR は終わった通話を通話履歴から流す設定
+type CDR struct {
+	Enabled          bool     `yaml:"enabled" env:"CDR_ENABLED"`
+	ChannelID        string   `yaml:"channel_id" env:"CDR_CHANNEL_ID"` // 空なら各サイトの通知先
+	PollIntervalSec  int      `yaml:"poll_interval_sec" env:"CDR_POLL_INTERVAL_SEC"`
+	ImportantNumbers []string `yaml:"important_numbers" env:"CDR_IMPORTANT_NUMBERS"` // 強調する番号（発信元・着信先・DID）
+}
+
+// Calls は通話中の電話の一覧をピン留めメッセージで出し続ける設定（/calls は常に使える）
+type Calls struct {
+	PinEnabled        bool   `yaml:"pin_enabled" env:"CALLS_PIN_ENABLED"`
+	ChannelID         string `yaml:"channel_id" env:"CALLS_CHANNEL_ID"` // 空なら各サイトの通知先
+	UpdateIntervalSec int    `yaml:"update_interval_sec" env:"CALLS_UPDATE_INTERVAL_SEC"`
+}
+
+// Phonebook は /call の電話帳の設定（内線はMikoPBXから取り込む）
+type Phonebook struct {
+	File            string `yaml:"file" env:"PHONEBOOK_FILE"` // 登録した外線の保存先。空なら再起動で消える
+	SyncIntervalMin int    `yaml:"sync_interval_min" env:"PHONEBOOK_SYNC_MIN"`
+}
+
+// Access はコマンドを使えるDiscordのロール・ユーザー（どちらも空なら誰でも使える）
+type Access struct {
+	// 発信に関わるコマンド（oki, oki-hangup, call, oncall call, incoming=着信のボタン）の既定
+	DialRoles []string `yaml:"dial_roles" env:"DIAL_ALLOWED_ROLES"`
+	DialUsers []string `yaml:"dial_users" env:"DIAL_ALLOWED_USERS"`
+	// コマンド名ごとの指定（発信系の既定より優先）
+	Commands map[string]CommandAccess `yaml:"commands,omitempty"`
+}
+
+type CommandAccess struct {
+	Roles []string `yaml:"roles,omitempty"`
+	Users []string `yaml:"users,omitempty"`
+}
+
+// Audit は発信・転送・管理コマンドの記録（追記のみのJSONL）
+type Audit struct {
+	File string `yaml:"file" env:"AUDIT_FILE"` // 空なら記録しない
+}
+
+// OnCall はオンコール当番表（/oncall で編集する。交代の曜日・時刻は /oncall handoff で変えるまでの既定値）
+type OnCall struct {
+	File        string `yaml:"file" env:"ONCALL_FILE"`                 // 保存先。空なら再起動で消える
+	Timezone    string `yaml:"timezone" env:"ONCALL_TIMEZONE"`         // 交代時刻のタイムゾーン（IANA名）
+	HandoffDay  string `yaml:"handoff_day" env:"ONCALL_HANDOFF_DAY"`   // 交代の曜日（monday など）
+	HandoffTime string `yaml:"handoff_time" env:"ONCALL_HANDOFF_TIME"` // 交代の時刻（HH:MM）
+	Mention     bool   `yaml:"mention" env:"ONCALL_MENTION"`           // ダウンの通知で当番をメンションする
+}
+
+// Escalation はインシデントが開いたらオンコールへ電話し、"1" の押下で確認を取る設定
+type Escalation struct {
+	Enabled      bool     `yaml:"enabled" env:"ESCALATION_ENABLED"`
+	OnCall       []string `yaml:"oncall" env:"ESCALATION_ONCALL"`             // 当番の次に上から順に電話する。"名前=番号" か番号
+	Announcement string   `yaml:"announcement" env:"ESCALATION_ANNOUNCEMENT"` // 応答後に流す音声（sip.announcements の名前）
+	Trigger      string   `yaml:"trigger" env:"ESCALATION_TRIGGER"`           // provider|peer|all
+	DelaySec     int      `yaml:"delay_sec" env:"ESCALATION_DELAY_SEC"`       // ダウンから電話するまでの猶予
+	RingSec      int      `yaml:"ring_sec" env:"ESCALATION_RING_SEC"`         // 1人を呼び出す時間
+	AckWaitSec   int      `yaml:"ack_wait_sec" env:"ESCALATION_ACK_WAIT_SEC"` // 応答してから "1" を待つ時間
+	Rounds       int      `yaml:"rounds" env:"ESCALATION_ROUNDS"`             // リストを何周するか
+}
+
+// Maintenance はメンテナンス中の通知の扱い（期間は /maintenance start か schedules で決める）
+type Maintenance struct {
+	File      string                `yaml:"file" env:"MAINTENANCE_FILE"`         // /maintenance start の期間の保存先。空なら再起動で消える
+	Mode      string                `yaml:"mode" env:"MAINTENANCE_MODE"`         // suppress|quiet
+	Timezone  string                `yaml:"timezone" env:"MAINTENANCE_TIMEZONE"` // schedules の cron の時刻のタイムゾーン
+	Schedules []MaintenanceSchedule `yaml:"schedules,omitempty"`                 // 定期メンテナンス（YAMLのみ）
+}
+
+// MaintenanceSchedule は cron で繰り返すメンテナンス
+type MaintenanceSchedule struct {
+	Cron        string   `yaml:"cron"`           // "0 3 * * sun" など（分 時 日 月 曜日）
+	DurationMin int      `yaml:"duration_min"`   // 1回の長さ
+	Site        string   `yaml:"site,omitempty"` // 空なら全サイト
+	IDs         []string `yaml:"ids,omitempty"`  // 端末・プロバイダのID。空ならPBX全体
+	Reason      string   `yaml:"reason,omitempty"`
+}
+
+// Routing は端末・プロバイダの変化ごとの通知先のルール（上から順に当て、最初に一致したものを使う）
+type Routing struct {
+	Timezone string        `yaml:"timezone" env:"ROUTING_TIMEZONE"` // days / hours のタイムゾーン
+	Rules    []RoutingRule `yaml:"rules,omitempty"`                 // YAMLのみ
+}
+
+// RoutingRule は1つのルール。条件は全て満たしたときに一致し、省略した条件は何にでも一致する。
+type RoutingRule struct {
+	Name         string   `yaml:"name,omitempty"`
+	Sites        []string `yaml:"sites,omitempty"`
+	Kinds        []string `yaml:"kinds,omitempty"` // peer|provider
+	IDs          []string `yaml:"ids,omitempty"`   // IDのパターン（* ? [...]）
+	Names        []string `yaml:"names,omitempty"` // 端末の名前のパターン（プロバイダはID）
+	From         []string `yaml:"from,omitempty"`  // 変化前: healthy|degraded|down か state（パターン可）
+	To           []string `yaml:"to,omitempty"`    // 変化後
+	Days         []string `yaml:"days,omitempty"`  // 曜日（mon など）
+	Hours        string   `yaml:"hours,omitempty"` // 時間帯 "HH:MM-HH:MM"（日を跨いでよい）
+	ChannelID    string   `yaml:"channel_id,omitempty"`
+	MentionRoles []string `yaml:"mention_roles,omitempty"`
+	Drop         bool     `yaml:"drop,omitempty"`
+}
+
+// Notify は Discord と同じ通知を流す他の送り先（設定したもの全てへ送る）
+type Notify struct {
+	MinSeverity     string     `yaml:"min_severity" env:"NOTIFY_MIN_SEVERITY"`                         // info|warning|critical（resolved は warning と同じ）
+	SlackWebhookURL string     `yaml:"slack_webhook_url" env:"NOTIFY_SLACK_WEBHOOK_URL" secret:"true"` // Slack の Incoming Webhook
+	WebhookURL      string     `yaml:"webhook_url" env:"NOTIFY_WEBHOOK_URL"`                           // 通知をJSONで POST する先
+	WebhookSecret   string     `yaml:"webhook_secret" env:"NOTIFY_WEBHOOK_SECRET" secret:"true"`       // HMAC-SHA256 の署名の鍵（空なら署名しない）
+	NtfyURL         string     `yaml:"ntfy_url" env:"NOTIFY_NTFY_URL"`                                 // 例: https://ntfy.sh
+	NtfyTopic       string     `yaml:"ntfy_topic" env:"NOTIFY_NTFY_TOPIC"`
+	NtfyToken       string     `yaml:"ntfy_token" env:"NOTIFY_NTFY_TOKEN" secret:"true"` // 空なら匿名
+	GotifyURL       string     `yaml:"gotify_url" env:"NOTIFY_GOTIFY_URL"`
+	GotifyToken     string     `yaml:"gotify_token" env:"NOTIFY_GOTIFY_TOKEN" secret:"true"` // アプリのトークン
+	SMTP            NotifySMTP `yaml:"smtp"`
+}
+
+// NotifySMTP はメールの送信設定（host が空なら送らない）
+type NotifySMTP struct {
+	Host     string   `yaml:"host" env:"NOTIFY_SMTP_HOST"`
+	Port     int      `yaml:"port" env:"NOTIFY_SMTP_PORT"`
+	Username string   `yaml:"username" env:"NOTIFY_SMTP_USERNAME"` // 空なら認証しない
+	Password string   `yaml:"password" env:"NOTIFY_SMTP_PASSWORD" secret:"true"`
+	From     string   `yaml:"from" env:"NOTIFY_SMTP_FROM"`
+	To       []string `yaml:"to" env:"NOTIFY_SMTP_TO"`
+	TLS      string   `yaml:"tls" env:"NOTIFY_SMTP_TLS"` // starttls|tls|none
+}
+
+type HTTP struct {
+	Listen string `yaml:"listen" env:"HTTP_LISTEN"` // 例: ":9100"。空ならHTTPサーバ（/metrics, /healthz, /readyz）を立てない
+}
+
+// Default は未指定時の既定値
+func Default() *Config {
+	return &Config{
+		MikoPBX: MikoPBX{
+			RetryMaxAttempts:   5,
+			RetryMaxElapsedSec: 120,
+			RetryStatusCodes:   []int{500, 502, 503, 504},
+		},
+		SIP: SIP{
+			Listen:     ":0",
+			Transport:  "udp",
+			Expires:    1800,
+			MaxRingSec: 60,
+		},
+		Watcher: Watcher{
+			PollIntervalSec:   30,
+			DebounceDownPolls: 1,
+			DebounceUpPolls:   1,
+			FlapWindowMin:     10,
+			UnreachableAfter:  2,
+			IncidentMode:      "edit",
+			IncidentRemindMin: 10,
+		},
+		CDR: CDR{
+			PollIntervalSec: 60,
+		},
+		Calls: Calls{
+			UpdateIntervalSec: 30,
+		},
+		Phonebook: Phonebook{
+			SyncIntervalMin: 60,
+		},
+		OnCall: OnCall{
+			Timezone:    "Asia/Tokyo",
+			HandoffDay:  "monday",
+			HandoffTime: "09:00",
+			Mention:     true,
+		},
+		Escalation: Escalation{
+			Trigger:    "provider",
+			RingSec:    30,
+			AckWaitSec: 30,
+			Rounds:     1,
+		},
+		Maintenance: Maintenance{
+			Mode:     "suppress",
+			Timezone: "Asia/Tokyo",
+		},
+		Routing: Routing{
+			Timezone: "Asia/Tokyo",
+		},
+		Notify: Notify{
+			MinSeverity: "info",
+			SMTP: NotifySMTP{
+				Port: 587,
+				TLS:  "starttls",
+			},
+		},
+	}
+}
+
+// Load は path のYAML（空ならファイルなし）を読み、envで上書きして検証する。
+// 検証エラーがあっても読めた設定は返す（--print-config 用）。
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true) // 綴り間違いのキーを黙って無視しない
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	envErr := applyEnv(reflect.ValueOf(cfg).Elem())
+	return cfg, errors.Join(envErr, cfg.Validate())
+}
+
+// applyEnv は env タグのある項目を環境変数で上書きする（設定されているものだけ）
+func applyEnv(v reflect.Value) error {
+	var errs []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnv(fv); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		key := f.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		raw, ok := os.LookupEnv(key)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := setFromString(fv, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setFromString(fv reflect.Value, raw string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(strings.TrimSpace(raw))
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		fv.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		fv.SetBool(b)
+	case reflect.Map:
+		// "KEY=VALUE,KEY2=VALUE2"
+		m := map[string]string{}
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(part, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("%q is not KEY=VALUE", part)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+		fv.Set(reflect.ValueOf(m))
+	case reflect.Slice:
+		// "A,B,C"
+		out := reflect.MakeSlice(fv.Type(), 0, 0)
+		for _, part := range strings.Split(raw, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			elem := reflect.New(fv.Type().Elem()).Elem()
+			if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Map {
+				return fmt.Errorf("unsupported type %s", fv.Type())
+			}
+			if err := setFromString(elem, part); err != nil {
+				return err
+			}
+			out = reflect.Append(out, elem)
+		}
+		fv.Set(out)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// Redacted は secret な項目を伏せ字にしたコピーを返す
+func (c *Config) Redacted() *Config {
+	cp := *c
+	cp.Sites = append([]Site(nil), c.Sites...) // 元の設定を書き換えないよう複製してから伏せる
+	redact(reflect.ValueOf(&cp).Elem())
+	return &cp
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			redact(fv)
+			continue
+		}
+		if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+			for j := 0; j < fv.Len(); j++ {
+				redact(fv.Index(j))
+			}
+			continue
+		}
+		if f.Tag.Get("secret") == "true" && fv.Kind() == reflect.String && fv.String() != "" {
+			fv.SetString("***")
+		}
+	}
+}
+
+// YAML は設定をYAMLで書き出す（キーは安定した順序）
+func (c *Config) YAML() (string, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// valid は検証を通る最小の設定
+func valid() *Config {
+	c := Default()
+	c.Discord.Token = "token"
+	c.Discord.ChannelID = "123456789012345678"
+	c.MikoPBX.BaseURL = "http://pbx.example.com"
+	c.SIP.Server = "sip.example.com:5060"
+	c.SIP.User = "oki"
+	c.SIP.Password = "secret"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string // エラーに含まれる文字列（空なら通る）
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"missing token", func(c *Config) { c.Discord.Token = "" }, "discord.token (DISCORD_TOKEN) is required"},
+		{"channel not snowflake", func(c *Config) { c.Discord.ChannelID = "general" }, `"general" is not a Discord ID`},
+		{"base url scheme", func(c *Config) { c.MikoPBX.BaseURL = "ftp://pbx" }, "is not an http(s) URL"},
+		{"login without password", func(c *Config) { c.MikoPBX.Login = "admin" }, "set both or neither"},
+		{"retry forever", func(c *Config) { c.MikoPBX.RetryMaxAttempts, c.MikoPBX.RetryMaxElapsedSec = 0, 0 }, "at least one must be set"},
+		{"retry 401", func(c *Config) { c.MikoPBX.RetryStatusCodes = []int{401} }, "401 is not a retryable"},
+		{"sip transport", func(c *Config) { c.SIP.Transport = "sctp" }, "sip.transport (OKI_SIP_TRANSPORT) must be one of"},
+		{"sip listen", func(c *Config) { c.SIP.Listen = "5060" }, `"5060" is not host:port`},
+		{"dial prefix", func(c *Config) { c.SIP.DialAllowPrefixes = []string{"0a"} }, "sip.dial_allow_prefixes"},
+		{"media ip", func(c *Config) { c.SIP.MediaIP = "host" }, "is not an IP address"},
+		{"poll interval", func(c *Config) { c.Watcher.PollIntervalSec = 0 }, "watcher.poll_interval_sec (POLL_INTERVAL_SEC) must be >= 1"},
+		{"incident mode", func(c *Config) { c.Watcher.IncidentMode = "loud" }, "watcher.incident_mode"},
+		{"duplicate site", func(c *Config) {
+			s := Site{Name: "本部", MikoPBX: c.MikoPBX, PollIntervalSec: 30}
+			c.Sites = []Site{s, s}
+		}, `"本部" is used more than once`},
+		{"site without url", func(c *Config) {
+			c.Sites = []Site{{Name: "本部", MikoPBX: MikoPBX{RetryMaxAttempts: 1}}}
+		}, "sites[本部].base_url is required"},
+		{"maintenance cron", func(c *Config) {
+			c.Maintenance.Schedules = []MaintenanceSchedule{{Cron: "0 25 * * *", DurationMin: 60}}
+		}, "maintenance.schedules[0]"},
+		{"maintenance unknown site", func(c *Config) {
+			c.Maintenance.Schedules = []MaintenanceSchedule{{Cron: "0 3 * * sun", DurationMin: 60, Site: "支店"}}
+		}, `"支店" is not in sites`},
+		{"routing without action", func(c *Config) {
+			c.Routing.Rules = []RoutingRule{{Name: "r", Kinds: []string{"peer"}}}
+		}, "routing.rules[r]: set channel_id, mention_roles or drop"},
+		{"routing drop and channel", func(c *Config) {
+			c.Routing.Rules = []RoutingRule{{Drop: true, ChannelID: "1"}}
+		}, "drop cannot be combined"},
+		{"routing bad kind", func(c *Config) {
+			c.Routing.Rules = []RoutingRule{{Kinds: []string{"trunk"}, Drop: true}}
+		}, "routing.rules[0].kinds must be one of peer|provider"},
+		{"routing bad pattern", func(c *Config) {
+			c.Routing.Rules = []RoutingRule{{IDs: []string{"[SIP"}, Drop: true}}
+		}, `"[SIP" is not a valid pattern`},
+		{"routing bad hours", func(c *Config) {
+			c.Routing.Rules = []RoutingRule{{Hours: "25:00-07:00", Drop: true}}
+		}, "routing.rules[0].hours"},
+		{"routing bad day", func(c *Config) {
+			c.Routing.Rules = []RoutingRule{{Days: []string{"someday"}, Drop: true}}
+		}, `"someday" is not a weekday`},
+		{"notify severity", func(c *Config) { c.Notify.MinSeverity = "loud" }, "notify.min_severity (NOTIFY_MIN_SEVERITY)"},
+		{"ntfy without topic", func(c *Config) { c.Notify.NtfyURL = "https://ntfy.sh" }, "notify.ntfy_topic (NOTIFY_NTFY_TOPIC) is required"},
+		{"smtp without to", func(c *Config) {
+			c.Notify.SMTP.Host, c.Notify.SMTP.From = "smtp.example.com", "bot@example.com"
+		}, "notify.smtp.to (NOTIFY_SMTP_TO) is required"},
+		{"http listen", func(c *Config) { c.HTTP.Listen = "9100" }, "http.listen (HTTP_LISTEN)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			err := c.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.want != "" && err == nil:
+				t.Fatalf("want error containing %q, got nil", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Fatalf("want error containing %q, got:\n%v", tt.want, err)
+			}
+		})
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const minimalYAML = `
+discord:
+  token: "token"
+  channel_id: "123456789012345678"
+mikopbx:
+  base_url: "http://pbx.example.com"
+sip:
+  server: "sip.example.com"
+  user: "oki"
+  password: "secret"
+`
+
+func TestLoad(t *testing.T) {
+	t.Run("env overrides yaml", func(t *testing.T) {
+		t.Setenv("POLL_INTERVAL_SEC", "5")
+		t.Setenv("STATE_HEALTH_MAP", "LAGGED=down, BUSY = healthy")
+		t.Setenv("OKI_SIP_DIAL_BLOCK_PREFIXES", "0570, 0990")
+		c, err := Load(writeConfig(t, minimalYAML+"watcher:\n  poll_interval_sec: 60\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Watcher.PollIntervalSec != 5 {
+			t.Errorf("poll_interval_sec = %d, want 5", c.Watcher.PollIntervalSec)
+		}
+		if c.Watcher.StateHealth["BUSY"] != "healthy" || c.Watcher.StateHealth["LAGGED"] != "down" {
+			t.Errorf("state_health = %v", c.Watcher.StateHealth)
+		}
+		if got := strings.Join(c.SIP.DialBlockPrefixes, "|"); got != "0570|0990" {
+			t.Errorf("dial_block_prefixes = %s", got)
+		}
+		if c.SIP.Transport != "udp" {
+			t.Errorf("default transport = %q", c.SIP.Transport)
+		}
+	})
+	t.Run("unknown key", func(t *testing.T) {
+		_, err := Load(writeConfig(t, minimalYAML+"watcher:\n  poll_intervl_sec: 60\n"))
+		if err == nil || !strings.Contains(err.Error(), "poll_intervl_sec") {
+			t.Fatalf("want unknown key error, got %v", err)
+		}
+	})
+	t.Run("bad env value", func(t *testing.T) {
+		t.Setenv("POLL_INTERVAL_SEC", "fast")
+		_, err := Load(writeConfig(t, minimalYAML))
+		if err == nil || !strings.Contains(err.Error(), `POLL_INTERVAL_SEC: "fast" is not an integer`) {
+			t.Fatalf("want env error, got %v", err)
+		}
+	})
+	t.Run("bad map entry", func(t *testing.T) {
+		t.Setenv("STATE_HEALTH_MAP", "LAGGED")
+		_, err := Load(writeConfig(t, minimalYAML))
+		if err == nil || !strings.Contains(err.Error(), `"LAGGED" is not KEY=VALUE`) {
+			t.Fatalf("want env error, got %v", err)
+		}
+	})
+}
+
+func TestRedacted(t *testing.T) {
+	c := valid()
+	c.Sites = []Site{{Name: "本部", MikoPBX: MikoPBX{BaseURL: "http://a", Login: "admin", Password: "pw"}}}
+	c.Notify.SMTP.Password = "smtp"
+	r := c.Redacted()
+	if r.Discord.Token != "***" || r.SIP.Password != "***" || r.Sites[0].Password != "***" || r.Notify.SMTP.Password != "***" {
+		t.Errorf("not redacted: %+v", r)
+	}
+	if r.Sites[0].Login != "admin" {
+		t.Errorf("login should be kept, got %q", r.Sites[0].Login)
+	}
+	if c.Discord.Token != "token" || c.Sites[0].Password != "pw" {
+		t.Error("Redacted modified the original config")
+	}
+}
@@ -0,0 +1,371 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/maintenance"
+	"tacnet-odenwakun/src/oncall"
+	"tacnet-odenwakun/src/routing"
+)
+
+// Validate は全項目を検証し、問題をまとめて返す
+func (c *Config) Validate() error {
+	var v validator
+
+	// Discord
+	v.required("discord.token (DISCORD_TOKEN)", c.Discord.Token)
+	v.snowflake("discord.channel_id (DISCORD_CHANNEL_ID)", c.Discord.ChannelID, true)
+	v.snowflake("discord.guild_id (DISCORD_GUILD_ID)", c.Discord.GuildID, false)
+
+	// MikoPBX（sites 指定時はサイトごと）
+	if len(c.Sites) == 0 {
+		v.mikopbx(func(key, env string) string { return fmt.Sprintf("mikopbx.%s (%s)", key, env) }, c.MikoPBX)
+	} else {
+		names := map[string]bool{}
+		for i, s := range c.Targets() {
+			prefix := fmt.Sprintf("sites[%d]", i)
+			if v.required(prefix+".name", s.Name) {
+				if names[s.Name] {
+					v.add("%s.name: %q is used more than once", prefix, s.Name)
+				}
+				names[s.Name] = true
+				prefix = fmt.Sprintf("sites[%s]", s.Name)
+			}
+			v.mikopbx(func(key, _ string) string { return prefix + "." + key }, s.MikoPBX)
+			v.min(prefix+".poll_interval_sec", s.PollIntervalSec, 1)
+			v.snowflake(prefix+".channel_id", s.ChannelID, true)
+		}
+	}
+
+	// SIP
+	if v.required("sip.server (OKI_SIP_SERVER)", c.SIP.Server) {
+		if _, _, err := net.SplitHostPort(c.SIP.Server); err != nil && strings.Contains(c.SIP.Server, ":") {
+			v.add("sip.server (OKI_SIP_SERVER): %q is not host or host:port", c.SIP.Server)
+		}
+	}
+	v.required("sip.user (OKI_SIP_USER)", c.SIP.User)
+	v.required("sip.password (OKI_SIP_PASSWORD)", c.SIP.Password)
+	if c.SIP.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.SIP.Listen); err != nil {
+			v.add("sip.listen (OKI_SIP_LISTEN): %q is not host:port (e.g. \":5060\")", c.SIP.Listen)
+		}
+	}
+	v.oneOf("sip.transport (OKI_SIP_TRANSPORT)", strings.ToLower(c.SIP.Transport), "udp", "tcp", "tls", "ws", "wss")
+	v.min("sip.expires (OKI_SIP_EXPIRES)", c.SIP.Expires, 1)
+	v.min("sip.max_ring_sec (OKI_SIP_MAX_RING_SEC)", c.SIP.MaxRingSec, 0)
+	v.min("sip.max_talk_sec (OKI_SIP_MAX_TALK_SEC)", c.SIP.MaxTalkSec, 0)
+	v.prefixes("sip.dial_allow_prefixes (OKI_SIP_DIAL_ALLOW_PREFIXES)", c.SIP.DialAllowPrefixes)
+	v.prefixes("sip.dial_block_prefixes (OKI_SIP_DIAL_BLOCK_PREFIXES)", c.SIP.DialBlockPrefixes)
+	v.min("sip.dial_max_length (OKI_SIP_DIAL_MAX_LENGTH)", c.SIP.DialMaxLength, 0)
+	for name, path := range c.SIP.Announcements {
+		if strings.TrimSpace(path) == "" {
+			v.add("sip.announcements (OKI_SIP_ANNOUNCEMENTS): %q has no file", name)
+		}
+	}
+	if c.SIP.MediaIP != "" && net.ParseIP(c.SIP.MediaIP) == nil {
+		v.add("sip.media_ip (OKI_SIP_MEDIA_IP): %q is not an IP address", c.SIP.MediaIP)
+	}
+
+	// Watcher
+	v.min("watcher.poll_interval_sec (POLL_INTERVAL_SEC)", c.Watcher.PollIntervalSec, 1)
+	v.min("watcher.debounce_down_polls (DEBOUNCE_DOWN_POLLS)", c.Watcher.DebounceDownPolls, 1)
+	v.min("watcher.debounce_up_polls (DEBOUNCE_UP_POLLS)", c.Watcher.DebounceUpPolls, 1)
+	v.min("watcher.flap_threshold (FLAP_THRESHOLD)", c.Watcher.FlapThreshold, 0)
+	v.min("watcher.flap_window_min (FLAP_WINDOW_MIN)", c.Watcher.FlapWindowMin, 1)
+	v.min("watcher.unreachable_after_polls (UNREACHABLE_AFTER_POLLS)", c.Watcher.UnreachableAfter, 1)
+	v.oneOf("watcher.incident_mode (INCIDENT_MODE)", strings.ToLower(c.Watcher.IncidentMode), "off", "edit", "thread")
+	v.min("watcher.incident_remind_min (INCIDENT_REMIND_MIN)", c.Watcher.IncidentRemindMin, 0)
+	for state, health := range c.Watcher.StateHealth {
+		v.oneOf(fmt.Sprintf("watcher.state_health[%s] (STATE_HEALTH_MAP)", state), strings.ToLower(health), "healthy", "degraded", "down")
+	}
+
+	// CDR
+	if c.CDR.Enabled {
+		v.snowflake("cdr.channel_id (CDR_CHANNEL_ID)", c.CDR.ChannelID, false)
+		v.min("cdr.poll_interval_sec (CDR_POLL_INTERVAL_SEC)", c.CDR.PollIntervalSec, 1)
+	}
+
+	// Calls
+	if c.Calls.PinEnabled {
+		v.snowflake("calls.channel_id (CALLS_CHANNEL_ID)", c.Calls.ChannelID, false)
+		// 編集のレート制限に当たらないよう短くしすぎない
+		v.min("calls.update_interval_sec (CALLS_UPDATE_INTERVAL_SEC)", c.Calls.UpdateIntervalSec, 5)
+	}
+
+	// Phonebook
+	v.min("phonebook.sync_interval_min (PHONEBOOK_SYNC_MIN)", c.Phonebook.SyncIntervalMin, 1)
+
+	// Access
+	for _, id := range c.Access.DialRoles {
+		v.snowflake("access.dial_roles (DIAL_ALLOWED_ROLES)", id, true)
+	}
+	for _, id := range c.Access.DialUsers {
+		v.snowflake("access.dial_users (DIAL_ALLOWED_USERS)", id, true)
+	}
+	for name, a := range c.Access.Commands {
+		for _, id := range a.Roles {
+			v.snowflake(fmt.Sprintf("access.commands[%s].roles", name), id, true)
+		}
+		for _, id := range a.Users {
+			v.snowflake(fmt.Sprintf("access.commands[%s].users", name), id, true)
+		}
+	}
+
+	// OnCall
+	if _, err := time.LoadLocation(c.OnCall.Timezone); err != nil || c.OnCall.Timezone == "" {
+		v.add("oncall.timezone (ONCALL_TIMEZONE): %q is not a known time zone (e.g. Asia/Tokyo)", c.OnCall.Timezone)
+	}
+	v.oneOf("oncall.handoff_day (ONCALL_HANDOFF_DAY)", strings.ToLower(c.OnCall.HandoffDay),
+		"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday")
+	if _, err := time.Parse("15:04", c.OnCall.HandoffTime); err != nil {
+		v.add("oncall.handoff_time (ONCALL_HANDOFF_TIME): %q is not HH:MM", c.OnCall.HandoffTime)
+	}
+
+	// Escalation
+	if c.Escalation.Enabled {
+		for _, entry := range c.Escalation.OnCall {
+			number := entry
+			if _, n, ok := strings.Cut(entry, "="); ok {
+				number = n
+			}
+			if strings.TrimSpace(number) == "" || strings.Trim(strings.TrimSpace(number), "0123456789*#+") != "" {
+				v.add("escalation.oncall (ESCALATION_ONCALL): %q is not NAME=NUMBER or NUMBER", entry)
+			}
+		}
+		if v.required("escalation.announcement (ESCALATION_ANNOUNCEMENT)", c.Escalation.Announcement) {
+			if _, ok := c.SIP.Announcements[c.Escalation.Announcement]; !ok {
+				v.add("escalation.announcement (ESCALATION_ANNOUNCEMENT): %q is not in sip.announcements", c.Escalation.Announcement)
+			}
+		}
+		if strings.ToLower(c.Watcher.IncidentMode) == "off" {
+			v.add("escalation: watcher.incident_mode (INCIDENT_MODE) must be edit or thread to escalate incidents")
+		}
+		v.oneOf("escalation.trigger (ESCALATION_TRIGGER)", strings.ToLower(c.Escalation.Trigger), "provider", "peer", "all")
+		v.min("escalation.delay_sec (ESCALATION_DELAY_SEC)", c.Escalation.DelaySec, 0)
+		v.min("escalation.ring_sec (ESCALATION_RING_SEC)", c.Escalation.RingSec, 5)
+		v.min("escalation.ack_wait_sec (ESCALATION_ACK_WAIT_SEC)", c.Escalation.AckWaitSec, 5)
+		v.min("escalation.rounds (ESCALATION_ROUNDS)", c.Escalation.Rounds, 1)
+	}
+
+	// Maintenance
+	v.oneOf("maintenance.mode (MAINTENANCE_MODE)", strings.ToLower(c.Maintenance.Mode), "suppress", "quiet")
+	if _, err := time.LoadLocation(c.Maintenance.Timezone); err != nil || c.Maintenance.Timezone == "" {
+		v.add("maintenance.timezone (MAINTENANCE_TIMEZONE): %q is not a known time zone (e.g. Asia/Tokyo)", c.Maintenance.Timezone)
+	}
+	for i, s := range c.Maintenance.Schedules {
+		prefix := fmt.Sprintf("maintenance.schedules[%d]", i)
+		if v.required(prefix+".cron", s.Cron) {
+			if _, err := maintenance.ParseCron(s.Cron); err != nil {
+				v.add("%s.cron: %v", prefix, err)
+			}
+		}
+		v.min(prefix+".duration_min", s.DurationMin, 1)
+		if s.Site != "" && !c.hasSite(s.Site) {
+			v.add("%s.site: %q is not in sites", prefix, s.Site)
+		}
+	}
+
+	// Routing
+	if _, err := time.LoadLocation(c.Routing.Timezone); err != nil || c.Routing.Timezone == "" {
+		v.add("routing.timezone (ROUTING_TIMEZONE): %q is not a known time zone (e.g. Asia/Tokyo)", c.Routing.Timezone)
+	}
+	for i, r := range c.Routing.Rules {
+		prefix := fmt.Sprintf("routing.rules[%d]", i)
+		if r.Name != "" {
+			prefix = fmt.Sprintf("routing.rules[%s]", r.Name)
+		}
+		for _, k := range r.Kinds {
+			v.oneOf(prefix+".kinds", strings.ToLower(k), "peer", "provider")
+		}
+		for _, s := range r.Sites {
+			if !c.hasSite(s) {
+				v.add("%s.sites: %q is not in sites", prefix, s)
+			}
+		}
+		for _, p := range slices.Concat(r.IDs, r.Names, r.From, r.To) {
+			if _, err := path.Match(p, ""); err != nil {
+				v.add("%s: %q is not a valid pattern", prefix, p)
+			}
+		}
+		for _, d := range r.Days {
+			if _, err := oncall.ParseWeekday(d); err != nil {
+				v.add("%s.days: %q is not a weekday (mon, tue, ...)", prefix, d)
+			}
+		}
+		if r.Hours != "" {
+			if _, _, err := routing.ParseHours(r.Hours); err != nil {
+				v.add("%s.hours: %v", prefix, err)
+			}
+		}
+		v.snowflake(prefix+".channel_id", r.ChannelID, false)
+		for _, id := range r.MentionRoles {
+			v.snowflake(prefix+".mention_roles", id, true)
+		}
+		switch {
+		case r.Drop && (r.ChannelID != "" || len(r.MentionRoles) > 0):
+			v.add("%s: drop cannot be combined with channel_id or mention_roles", prefix)
+		case !r.Drop && r.ChannelID == "" && len(r.MentionRoles) == 0:
+			v.add("%s: set channel_id, mention_roles or drop", prefix)
+		}
+	}
+
+	// Notify
+	v.oneOf("notify.min_severity (NOTIFY_MIN_SEVERITY)", strings.ToLower(c.Notify.MinSeverity), "info", "resolved", "warning", "critical")
+	v.httpURL("notify.slack_webhook_url (NOTIFY_SLACK_WEBHOOK_URL)", c.Notify.SlackWebhookURL)
+	v.httpURL("notify.webhook_url (NOTIFY_WEBHOOK_URL)", c.Notify.WebhookURL)
+	if v.httpURL("notify.ntfy_url (NOTIFY_NTFY_URL)", c.Notify.NtfyURL) {
+		v.required("notify.ntfy_topic (NOTIFY_NTFY_TOPIC)", c.Notify.NtfyTopic)
+	}
+	if v.httpURL("notify.gotify_url (NOTIFY_GOTIFY_URL)", c.Notify.GotifyURL) {
+		v.required("notify.gotify_token (NOTIFY_GOTIFY_TOKEN)", c.Notify.GotifyToken)
+	}
+	if smtp := c.Notify.SMTP; smtp.Host != "" {
+		if smtp.Port < 1 || smtp.Port > 65535 {
+			v.add("notify.smtp.port (NOTIFY_SMTP_PORT): %d is not a port", smtp.Port)
+		}
+		v.required("notify.smtp.from (NOTIFY_SMTP_FROM)", smtp.From)
+		if len(smtp.To) == 0 {
+			v.add("notify.smtp.to (NOTIFY_SMTP_TO) is required")
+		}
+		for _, a := range append([]string{smtp.From}, smtp.To...) {
+			if _, err := mail.ParseAddress(a); a != "" && err != nil {
+				v.add("notify.smtp: %q is not a mail address", a)
+			}
+		}
+		v.oneOf("notify.smtp.tls (NOTIFY_SMTP_TLS)", strings.ToLower(smtp.TLS), "starttls", "tls", "none")
+	}
+
+	// HTTP
+	if c.HTTP.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP.Listen); err != nil {
+			v.add("http.listen (HTTP_LISTEN): %q is not host:port (e.g. \":9100\")", c.HTTP.Listen)
+		}
+	}
+
+	return v.err()
+}
+
+// hasSite は監視対象に name のサイトがあるか
+func (c *Config) hasSite(name string) bool {
+	for _, s := range c.Targets() {
+		if strings.EqualFold(s.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validator は検証エラーを溜める
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(v.errs))
+	for i, e := range v.errs {
+		msgs[i] = "  - " + e.Error()
+	}
+	return errors.New("invalid config:\n" + strings.Join(msgs, "\n"))
+}
+
+// prefixes は番号の前方一致ルールが番号として書かれているかを確認する
+func (v *validator) prefixes(name string, ps []string) {
+	for _, p := range ps {
+		for _, r := range p {
+			if (r < '0' || r > '9') && r != '*' && r != '#' && r != '+' {
+				v.add("%s: %q is not a number prefix", name, p)
+				break
+			}
+		}
+	}
+}
+
+// mikopbx は接続先1台分の設定を確認する。name は (yamlキー, env名) から表示名を作る。
+func (v *validator) mikopbx(name func(key, env string) string, m MikoPBX) {
+	if v.required(name("base_url", "MIKOPBX_BASE_URL"), m.BaseURL) {
+		u, err := url.Parse(m.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("%s: %q is not an http(s) URL", name("base_url", "MIKOPBX_BASE_URL"), m.BaseURL)
+		}
+	}
+	if (m.Login == "") != (m.Password == "") {
+		v.add("%s / %s: set both or neither", name("login", "MIKOPBX_LOGIN"), name("password", "MIKOPBX_PASSWORD"))
+	}
+	v.min(name("retry_max_attempts", "MIKOPBX_RETRY_MAX_ATTEMPTS"), m.RetryMaxAttempts, 0)
+	v.min(name("retry_max_elapsed_sec", "MIKOPBX_RETRY_MAX_ELAPSED_SEC"), m.RetryMaxElapsedSec, 0)
+	if m.RetryMaxAttempts == 0 && m.RetryMaxElapsedSec == 0 {
+		v.add("%s / %s: at least one must be set (both 0 retries forever)",
+			name("retry_max_attempts", "MIKOPBX_RETRY_MAX_ATTEMPTS"), name("retry_max_elapsed_sec", "MIKOPBX_RETRY_MAX_ELAPSED_SEC"))
+	}
+	for _, code := range m.RetryStatusCodes {
+		if code < 400 || code > 599 || code == 401 || code == 403 {
+			v.add("%s: %d is not a retryable 4xx/5xx (401/403 trigger re-login instead)", name("retry_status_codes", "MIKOPBX_RETRY_STATUS_CODES"), code)
+		}
+	}
+}
+
+// httpURL は値があれば http(s) のURLかを確認し、値があるかを返す
+func (v *validator) httpURL(name, val string) bool {
+	if val == "" {
+		return false
+	}
+	if u, err := url.Parse(val); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add("%s: %q is not an http(s) URL", name, val)
+	}
+	return true
+}
+
+// required は値が空ならエラーを追加し、値があるかを返す
+func (v *validator) required(name, val string) bool {
+	if strings.TrimSpace(val) == "" {
+		v.add("%s is required", name)
+		return false
+	}
+	return true
+}
+
+func (v *validator) min(name string, val, min int) {
+	if val < min {
+		v.add("%s must be >= %d (got %d)", name, min, val)
+	}
+}
+
+func (v *validator) oneOf(name, val string, allowed ...string) {
+	for _, a := range allowed {
+		if val == a {
+			return
+		}
+	}
+	v.add("%s must be one of %s (got %q)", name, strings.Join(allowed, "|"), val)
+}
+
+// snowflake はDiscordのID（数字のみ）かを確認する
+func (v *validator) snowflake(name, val string, required bool) {
+	if val == "" {
+		if required {
+			v.add("%s is required", name)
+		}
+		return
+	}
+	for _, r := range val {
+		if r < '0' || r > '9' {
+			v.add("%s: %q is not a Discord ID (digits only)", name, val)
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package escalation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tacnet-odenwakun/src/sipclient"
+)
+
+// Target はオンコールで電話する1人
+type Target struct {
+	Name   string
+	Number string
+}
+
+// Label は「名前(番号)」（名前が無ければ番号）
+func (t Target) Label() string {
+	if t.Name == "" {
+		return t.Number
+	}
+	return fmt.Sprintf("%s(%s)", t.Name, t.Number)
+}
+
+// ParseTargets は "名前=番号" か "番号" の並びを上から順の Target にする
+func ParseTargets(list []string) []Target {
+	var out []Target
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if name, number, ok := strings.Cut(s, "="); ok {
+			out = append(out, Target{Name: strings.TrimSpace(name), Number: strings.TrimSpace(number)})
+		} else {
+			out = append(out, Target{Number: s})
+		}
+	}
+	return out
+}
+
+// Escalator はインシデントが開いたらオンコールへ順に電話し、"1" が押されたら確認済みとしてインシデントに書き込む。
+// 応答が無い・キーが押されないときは次の人へ回す。
+type Escalator struct {
+	OKI          *sipclient.OkiSIP
+	Targets      func() []Target // 電話する順（呼ぶたびに引き直す）
+	Announcement string          // 応答後に繰り返し流す音声（sip.announcements の名前）
+	Kinds        []string        // 電話するインシデントの種類（"peer" / "provider"）。空なら全て
+	Delay        time.Duration   // ダウンから電話するまでの猶予（その間に復旧したら電話しない）
+	Ring         time.Duration   // 1人を呼び出す時間
+	AckWait      time.Duration   // 応答してからキーを待つ時間
+	Rounds       int             // リストを何周するか
+
+	callMu sync.Mutex // 電話は同時に1本だけ
+	mu     sync.Mutex
+	active map[string]*job // インシデントのキー -> 進行中のエスカレーション
+}
+
+type job struct{ cancel context.CancelFunc }
+
+func New(oki *sipclient.OkiSIP, targets []Target, announcement string) *Escalator {
+	return &Escalator{
+		OKI:          oki,
+		Targets:      func() []Target { return targets },
+		Announcement: announcement,
+		Ring:         30 * time.Second,
+		AckWait:      30 * time.Second,
+		Rounds:       1,
+		active:       map[string]*job{},
+	}
+}
+
+// Escalate はインシデント key の電話を始める。post は経過をインシデントへ書き込む。
+func (e *Escalator) Escalate(key, kind, summary string, post func(text string)) {
+	if len(e.Kinds) > 0 && !slices.Contains(e.Kinds, kind) {
+		return
+	}
+	e.mu.Lock()
+	if _, ok := e.active[key]; ok {
+		e.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{cancel: cancel}
+	e.active[key] = j
+	e.mu.Unlock()
+
+	go func() {
+		e.run(ctx, summary, post)
+		cancel()
+		e.mu.Lock()
+		if e.active[key] == j {
+			delete(e.active, key)
+		}
+		e.mu.Unlock()
+	}()
+}
+
+// Resolve はインシデント key の電話を止める（復旧したとき）
+func (e *Escalator) Resolve(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if j, ok := e.active[key]; ok {
+		j.cancel()
+		delete(e.active, key)
+	}
+}
+
+func (e *Escalator) run(ctx context.Context, summary string, post func(string)) {
+	if e.Delay > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.Delay):
+		}
+	}
+	targets := e.Targets()
+	if len(targets) == 0 {
+		post("⚠️ オンコール担当が設定されていないため電話できません")
+		return
+	}
+	rounds := max(e.Rounds, 1)
+	post(fmt.Sprintf("📞 オンコールへ電話します（%d 人）", len(targets)))
+	for round := 1; round <= rounds; round++ {
+		for i, t := range targets {
+			res := e.try(ctx, t)
+			if ctx.Err() != nil {
+				return // 復旧した
+			}
+			if res == "" {
+				log.Printf("[escalation] %s acknowledged %s", t.Label(), summary)
+				post(fmt.Sprintf("✅ %s が電話で確認しました（1 を押下）", t.Label()))
+				return
+			}
+			log.Printf("[escalation] %s: %s", t.Label(), res)
+			post(fmt.Sprintf("📵 %d/%d %s: %s", i+1, len(targets), t.Label(), res))
+		}
+	}
+	post(fmt.Sprintf("⚠️ オンコール全員（%d 人・%d 周）に電話しましたが確認が取れませんでした", len(targets), rounds))
+}
+
+// try は t に電話して "1" を待つ。確認が取れたら空文字、取れなければ理由を返す。
+func (e *Escalator) try(ctx context.Context, t Target) string {
+	e.callMu.Lock()
+	defer e.callMu.Unlock()
+	if ctx.Err() != nil {
+		return "中止"
+	}
+	call, err := e.OKI.InviteListen(t.Number, e.Announcement)
+	if err != nil {
+		return "発信エラー: " + err.Error()
+	}
+	ring := time.NewTimer(e.Ring)
+	defer ring.Stop()
+	var keyWait <-chan time.Time
+	events, digits := call.Events(), call.Digits()
+	answered := false
+	for {
+		select {
+		case <-ctx.Done():
+			_ = call.Hangup("復旧したため終了")
+			return "中止"
+		case <-ring.C:
+			if !answered {
+				_ = call.Hangup("応答なし")
+				return "応答なし"
+			}
+		case <-keyWait:
+			_ = call.Hangup("キー入力なし")
+			return "キー入力なし"
+		case d, ok := <-digits:
+			if !ok {
+				digits = nil
+				continue
+			}
+			if d == '1' {
+				_ = call.Hangup("確認済み")
+				return ""
+			}
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			switch {
+			case ev.State == sipclient.CallAnswered:
+				answered = true
+				keyWait = time.After(e.AckWait)
+			case ev.State.Final() && answered:
+				return "キー入力なし（切断されました）"
+			case ev.State.Final():
+				if ev.Code != 0 {
+					return fmt.Sprintf("応答なし（%d %s）", ev.Code, ev.Reason)
+				}
+				return strings.TrimSpace("応答なし " + ev.Reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status はコンポーネントの状態
+type Status string
+
+const (
+	OK   Status = "ok"
+	Warn Status = "warn" // 動いてはいるが注意（再接続中・起動直後など）
+	Fail Status = "fail"
+)
+
+// Result は1コンポーネントの確認結果
+type Result struct {
+	Status  Status         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// CheckFunc は現在の状態を返す（軽い処理にすること。HTTPリクエストのたびに呼ばれる）
+type CheckFunc func(now time.Time) Result
+
+type check struct {
+	name     string
+	liveness bool
+	fn       CheckFunc
+}
+
+// Checker はコンポーネントごとの確認をまとめる。
+// /healthz は liveness の確認だけ、/readyz は全ての確認で判定する（Fail があれば 503）。
+type Checker struct {
+	mu     sync.Mutex
+	checks []check
+}
+
+func NewChecker() *Checker { return &Checker{} }
+
+// Add は確認を登録する。liveness=true ならプロセスの再起動で直る類のもの（/healthz にも効く）。
+func (c *Checker) Add(name string, liveness bool, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check{name: name, liveness: liveness, fn: fn})
+}
+
+// Healthz は生存確認のハンドラ
+func (c *Checker) Healthz() http.Handler { return c.handler(true) }
+
+// Readyz は準備完了確認のハンドラ
+func (c *Checker) Readyz() http.Handler { return c.handler(false) }
+
+type report struct {
+	Status     Status            `json:"status"`
+	Time       time.Time         `json:"time"`
+	Components map[string]Result `json:"components"`
+}
+
+func (c *Checker) handler(livenessOnly bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		c.mu.Lock()
+		checks := append([]check(nil), c.checks...)
+		c.mu.Unlock()
+		sort.SliceStable(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+		now := time.Now()
+		rep := report{Status: OK, Time: now, Components: map[string]Result{}}
+		for _, ch := range checks {
+			res := ch.fn(now)
+			rep.Components[ch.name] = res
+			if livenessOnly && !ch.liveness {
+				continue
+			}
+			switch {
+			case res.Status == Fail:
+				rep.Status = Fail
+			case res.Status == Warn && rep.Status == OK:
+				rep.Status = Warn
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if rep.Status == Fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+	})
+}
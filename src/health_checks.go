@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"tacnet-odenwakun/src/health"
+	"tacnet-odenwakun/src/mikopbx"
+	"tacnet-odenwakun/src/sipclient"
+	"tacnet-odenwakun/src/watcher"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 起動直後はまだ結果がなくても失敗扱いにしない
+const healthStartupGrace = 60 * time.Second
+
+// healthChecks は /healthz・/readyz で見るコンポーネントを登録する。
+// Discord・SIP登録・ポーリングループの停止は再起動で直りうるので liveness、PBXへの到達は readiness のみ。
+func healthChecks(ds *discordgo.Session, oki *sipclient.OkiSIP, watchers []*watcher.Watcher) *health.Checker {
+	started := time.Now()
+	c := health.NewChecker()
+	c.Add("discord", true, discordCheck(ds, started))
+	c.Add("sip", true, sipCheck(oki, started))
+	for _, w := range watchers {
+		suffix := ""
+		if w.Site != "" {
+			suffix = ":" + w.Site
+		}
+		c.Add("watcher"+suffix, true, watcherLoopCheck(w, started))
+		c.Add("mikopbx"+suffix, false, mikopbxCheck(w, started))
+	}
+	return c
+}
+
+// discordCheck はGatewayの接続とハートビートACKを見る
+func discordCheck(ds *discordgo.Session, started time.Time) health.CheckFunc {
+	return func(now time.Time) health.Result {
+		ds.RLock()
+		ready, ack := ds.DataReady, ds.LastHeartbeatAck
+		ds.RUnlock()
+		details := map[string]any{"ready": ready}
+		if !ack.IsZero() {
+			details["last_heartbeat_ack"] = ack
+		}
+		switch {
+		case ack.IsZero() && now.Sub(started) < healthStartupGrace:
+			return health.Result{Status: health.Warn, Message: "connecting", Details: details}
+		case ack.IsZero() || now.Sub(ack) > 5*time.Minute:
+			// discordgo は数回ACKが無ければ自分で再接続するので、それでも戻らない場合だけ失敗とする
+			return health.Result{Status: health.Fail, Message: "no heartbeat ACK from gateway", Details: details}
+		case !ready:
+			return health.Result{Status: health.Warn, Message: "reconnecting", Details: details}
+		}
+		return health.Result{Status: health.OK, Details: details}
+	}
+}
+
+// sipCheck はREGISTERの結果と期限を見る
+func sipCheck(oki *sipclient.OkiSIP, started time.Time) health.CheckFunc {
+	return func(now time.Time) health.Result {
+		reg := oki.Registration()
+		details := map[string]any{"code": reg.Code}
+		if reg.Reason != "" {
+			details["reason"] = reg.Reason
+		}
+		if !reg.ExpiresAt.IsZero() {
+			details["expires_at"] = reg.ExpiresAt
+			details["expires_in_sec"] = int(reg.ExpiresAt.Sub(now).Seconds())
+		}
+		switch {
+		case reg.UpdatedAt.IsZero() && now.Sub(started) < healthStartupGrace:
+			return health.Result{Status: health.Warn, Message: "registering", Details: details}
+		case reg.UpdatedAt.IsZero():
+			return health.Result{Status: health.Fail, Message: "no response to REGISTER", Details: details}
+		case !reg.Registered:
+			return health.Result{Status: health.Fail, Message: "registration rejected", Details: details}
+		case !reg.ExpiresAt.After(now):
+			return health.Result{Status: health.Fail, Message: "registration expired (refresh failed)", Details: details}
+		}
+		return health.Result{Status: health.OK, Details: details}
+	}
+}
+
+// watcherLoopCheck はポーリングが止まっていないか（成否は問わず終わっているか）を見る
+func watcherLoopCheck(w *watcher.Watcher, started time.Time) health.CheckFunc {
+	limit := 2*w.Interval + 3*callBudget(w.Client.RetryPolicy())
+	return func(now time.Time) health.Result {
+		st := w.PollStatus()
+		last := st.LastAttempt
+		if last.IsZero() {
+			last = started
+		}
+		details := map[string]any{"stuck_after_sec": int(limit.Seconds())}
+		if !st.LastAttempt.IsZero() {
+			details["last_poll"] = st.LastAttempt
+		}
+		if now.Sub(last) > limit {
+			return health.Result{Status: health.Fail, Message: fmt.Sprintf("no poll finished for %s", now.Sub(last).Round(time.Second)), Details: details}
+		}
+		return health.Result{Status: health.OK, Details: details}
+	}
+}
+
+// mikopbxCheck は最後にポーリングが成功してからの時間を見る
+func mikopbxCheck(w *watcher.Watcher, started time.Time) health.CheckFunc {
+	limit := 3*w.Interval + 2*callBudget(w.Client.RetryPolicy())
+	return func(now time.Time) health.Result {
+		st := w.PollStatus()
+		details := map[string]any{"stale_after_sec": int(limit.Seconds())}
+		if !st.LastSuccess.IsZero() {
+			details["last_success"] = st.LastSuccess
+		}
+		if st.LastError != "" {
+			details["last_error"] = st.LastError
+		}
+		last := st.LastSuccess
+		if last.IsZero() {
+			if now.Sub(started) < limit {
+				return health.Result{Status: health.Warn, Message: "waiting for first poll", Details: details}
+			}
+			return health.Result{Status: health.Fail, Message: "no successful poll since start", Details: details}
+		}
+		if now.Sub(last) > limit {
+			return health.Result{Status: health.Fail, Message: fmt.Sprintf("last successful poll %s ago", now.Sub(last).Round(time.Second)), Details: details}
+		}
+		if st.LastError != "" {
+			return health.Result{Status: health.Warn, Message: "last poll failed", Details: details}
+		}
+		return health.Result{Status: health.OK, Details: details}
+	}
+}
+
+// callBudget はAPI 1回の呼び出し（リトライ込み）にかかりうる時間の目安
+func callBudget(p mikopbx.RetryPolicy) time.Duration {
+	const requestTimeout = 15 * time.Second // mikopbx.Client の http.Client と同じ
+	if p.MaxElapsed > 0 {
+		return p.MaxElapsed + requestTimeout
+	}
+	return time.Duration(p.MaxAttempts) * (requestTimeout + p.MaxBackoff)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"tacnet-odenwakun/src/config"
+	"tacnet-odenwakun/src/health"
+	"tacnet-odenwakun/src/metrics"
+)
+
+// startHTTP は /metrics と /healthz・/readyz を出すHTTPサーバをバックグラウンドで立てる
+func startHTTP(addr string, checker *health.Checker) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", checker.Healthz())
+	mux.Handle("/readyz", checker.Readyz())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("HTTP server listening on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[ERROR] HTTP server: %v", err)
+		}
+	}()
+	return srv
+}
+
+func stopHTTP(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+}
+
+// runHealthcheck は同じ設定で立てた自分の /healthz を叩き、終了コードを返す（Docker の HEALTHCHECK 用）。
+// HTTPサーバを立てない設定では確かめようがないので失敗にする。
+func runHealthcheck(cfg *config.Config, cfgErr error) int {
+	if cfg == nil {
+		fmt.Fprintln(os.Stderr, cfgErr)
+		return 1
+	}
+	if cfg.HTTP.Listen == "" {
+		fmt.Fprintln(os.Stderr, "health check disabled: set http.listen (HTTP_LISTEN)")
+		return 1
+	}
+	u, err := healthURL(cfg.HTTP.Listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", u, resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// healthURL は待ち受けアドレス（":9100", "0.0.0.0:9100", "[::1]:9100" など）から自分の /healthz のURLを作る
+func healthURL(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("http.listen (HTTP_LISTEN): %q is not host:port", listen)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1" // 全アドレスで待ち受けているならループバックで届く
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/healthz", nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"tacnet-odenwakun/src/audit"
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/sipclient"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// incomingNotifier はOKIアカウントへの着信をDiscordに投稿し、結末に合わせて書き換える
+type incomingNotifier struct {
+	session   *discordgo.Session
+	channelID string
+
+	mu       sync.Mutex
+	messages map[string]*incomingPost // Call-ID -> 投稿
+	actors   map[string]string        // Call-ID -> 拒否/転送を操作した人
+}
+
+// incomingPost は着信1件の投稿。投稿が終わる前に結末が決まったら ended を立て、投稿後に書き換える。
+type incomingPost struct {
+	messageID string // 投稿中は空
+	ended     bool
+}
+
+func newIncomingNotifier(s *discordgo.Session, channelID string) *incomingNotifier {
+	return &incomingNotifier{
+		session:   s,
+		channelID: channelID,
+		messages:  map[string]*incomingPost{},
+		actors:    map[string]string{},
+	}
+}
+
+// handle は sipclient.OkiSIP.OnIncoming に渡すコールバック。
+// SIPの処理を待たせないよう、Discordへの送信は別goroutineで行う。
+func (n *incomingNotifier) handle(call *sipclient.IncomingCall) {
+	outcome, _ := call.Outcome()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if outcome == sipclient.IncomingRinging {
+		n.messages[call.ID] = &incomingPost{}
+		go n.post(call)
+		return
+	}
+	p, ok := n.messages[call.ID]
+	if !ok {
+		return
+	}
+	if p.messageID == "" {
+		p.ended = true // 投稿が終わったら post が書き換える
+		return
+	}
+	delete(n.messages, call.ID)
+	go n.finish(call, p.messageID)
+}
+
+// post は着信中のメッセージを投稿し、その間に結末が決まっていればすぐ書き換える
+func (n *incomingNotifier) post(call *sipclient.IncomingCall) {
+	msg, err := n.session.ChannelMessageSendComplex(n.channelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{n.embed(call)},
+		Components: incomingButtons(call.ID),
+	})
+	n.mu.Lock()
+	p := n.messages[call.ID]
+	if err != nil || p.ended {
+		delete(n.messages, call.ID)
+	} else {
+		p.messageID = msg.ID
+	}
+	n.mu.Unlock()
+	if err != nil {
+		log.Printf("[incoming] post failed: %v", err)
+		n.mu.Lock()
+		delete(n.actors, call.ID)
+		n.mu.Unlock()
+		return
+	}
+	if p.ended {
+		n.finish(call, msg.ID)
+	}
+}
+
+// finish は結末に合わせてメッセージを書き換え、ボタンを消す
+func (n *incomingNotifier) finish(call *sipclient.IncomingCall, msgID string) {
+	embeds := []*discordgo.MessageEmbed{n.embed(call)}
+	components := []discordgo.MessageComponent{} // ボタンを消す
+	if _, err := n.session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Channel:    n.channelID,
+		ID:         msgID,
+		Embeds:     &embeds,
+		Components: &components,
+	}); err != nil {
+		log.Printf("[incoming] edit failed: %v", err)
+	}
+	n.mu.Lock()
+	delete(n.actors, call.ID)
+	n.mu.Unlock()
+}
+
+func (n *incomingNotifier) setActor(id string, user *discordgo.User) {
+	if user == nil {
+		return
+	}
+	n.mu.Lock()
+	n.actors[id] = user.Username
+	n.mu.Unlock()
+}
+
+func (n *incomingNotifier) embed(call *sipclient.IncomingCall) *discordgo.MessageEmbed {
+	outcome, target := call.Outcome()
+	n.mu.Lock()
+	actor := n.actors[call.ID]
+	n.mu.Unlock()
+
+	from := call.From
+	if from == "" {
+		from = "非通知"
+	}
+	name := call.DisplayName
+	if name == "" {
+		name = "-"
+	}
+	e := &discordgo.MessageEmbed{
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "発信者番号", Value: from, Inline: true},
+			{Name: "表示名", Value: name, Inline: true},
+			{Name: "着信時刻", Value: call.ReceivedAt.Local().Format("2006-01-02 15:04:05"), Inline: true},
+		},
+		Timestamp: call.ReceivedAt.Format(time.RFC3339),
+	}
+	by := ""
+	if actor != "" {
+		by = "（" + actor + "）"
+	}
+	switch outcome {
+	case sipclient.IncomingMissed:
+		e.Title = "📵 不在着信"
+		e.Color = 0xE74C3C // red
+	case sipclient.IncomingRejected:
+		e.Title = "🚫 着信を拒否しました" + by
+		e.Color = 0x95A5A6 // gray
+	case sipclient.IncomingTransferred:
+		e.Title = fmt.Sprintf("↪️ 内線 %s へ転送しました%s", target, by)
+		e.Color = 0x2ECC71 // green
+	default:
+		e.Title = "📲 着信中"
+		e.Color = 0x3498DB // blue
+	}
+	return e
+}
+
+func incomingButtons(id string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "拒否", Style: discordgo.DangerButton, CustomID: "incoming-reject:" + id},
+			discordgo.Button{Label: "転送", Style: discordgo.PrimaryButton, CustomID: "incoming-transfer:" + id},
+		}},
+	}
+}
+
+// registerIncomingComponents は着信メッセージのボタンとモーダルのハンドラを登録する
+func registerIncomingComponents(r *commands.Router, oki *sipclient.OkiSIP, n *incomingNotifier, al *audit.Log) {
+	r.Component("incoming-reject", func(c *commands.Context) error {
+		n.setActor(c.Payload, c.User())
+		call, err := oki.RejectIncoming(c.Payload)
+		e := auditEntry(c, audit.ActionReject, "")
+		e.Result, e.SIPCode = "ok", 486
+		if call != nil {
+			e.Target = call.From
+		}
+		if err != nil {
+			e.Result, e.SIPCode, e.Detail = "error", 0, err.Error()
+		}
+		appendAudit(al, e)
+		if err != nil {
+			return commands.Errorf("拒否できませんでした（すでに終わった着信かも）: %v", err)
+		}
+		return c.DeferUpdate()
+	})
+	r.Component("incoming-transfer", func(c *commands.Context) error {
+		return c.ShowModal("incoming-transfer-submit:"+c.Payload, "着信を転送",
+			discordgo.TextInput{
+				CustomID:  "ext",
+				Label:     "転送先の内線番号",
+				Style:     discordgo.TextInputShort,
+				Required:  true,
+				MaxLength: 32,
+			})
+	})
+	r.Component("incoming-transfer-submit", func(c *commands.Context) error {
+		ext := strings.TrimSpace(c.Value("ext"))
+		if !sipclient.Dialable(ext) {
+			return commands.Errorf("内線番号は数字で指定してください: %s", ext)
+		}
+		n.setActor(c.Payload, c.User())
+		call, err := oki.TransferIncoming(c.Payload, ext)
+		e := auditEntry(c, audit.ActionTransfer, ext)
+		e.Result, e.SIPCode = "ok", 302
+		if call != nil {
+			e.Detail = "from " + call.From
+		}
+		switch {
+		case errors.Is(err, sipclient.ErrNumberDenied):
+			e.Action, e.Result, e.SIPCode, e.Detail = audit.ActionDenied, "number rule", 0, err.Error()
+		case err != nil:
+			e.Result, e.SIPCode, e.Detail = "error", 0, err.Error()
+		}
+		appendAudit(al, e)
+		if err != nil {
+			if errors.Is(err, sipclient.ErrNumberDenied) {
+				return commands.Errorf("🚫 この番号には転送できません: %s", ext)
+			}
+			return commands.Errorf("転送できませんでした（すでに終わった着信かも）: %v", err)
+		}
+		return c.DeferUpdate()
+	})
+}
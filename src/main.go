@@ -3,138 +3,257 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
-	"tacnet-odenwakun/src/mikopbx"
+	"tacnet-odenwakun/src/audit"
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/config"
+	"tacnet-odenwakun/src/escalation"
+	"tacnet-odenwakun/src/phonebook"
 	"tacnet-odenwakun/src/sipclient"
 	"tacnet-odenwakun/src/watcher"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-// Env vars:
+// Config: YAML file (--config or CONFIG_FILE) with env overrides; see config.example.yaml.
+// Env vars (override the file):
 // - DISCORD_TOKEN: Bot token
 // - DISCORD_CHANNEL_ID: Channel to post notifications
-// - MIKOPBX_BASE_URL: e.g. http://172.16.156.223
+// - DISCORD_GUILD_ID: optional, register slash commands to this guild only (instant update); global if empty
+// - MIKOPBX_BASE_URL: e.g. http://172.16.156.223 (single PBX; list several under "sites" in the YAML file instead)
 // - MIKOPBX_LOGIN, MIKOPBX_PASSWORD: optional for auth (omit if localhost and not required)
+// - MIKOPBX_RETRY_MAX_ATTEMPTS / MIKOPBX_RETRY_MAX_ELAPSED_SEC: optional, per-call retry limits, default 5 / 120 (0 = unlimited)
+// - MIKOPBX_RETRY_STATUS_CODES: optional, HTTP statuses to retry, default "500,502,503,504"
+// - OKI_SIP_*: SIP account for /oki (SERVER, USER, PASSWORD, LISTEN, TRANSPORT, DOMAIN, EXPIRES, MAX_RING_SEC, MAX_TALK_SEC)
 // - POLL_INTERVAL_SEC: optional, default 30
+// - STATE_FILE: optional, path of JSON file to persist watcher state across restarts
+// - DEBOUNCE_DOWN_POLLS / DEBOUNCE_UP_POLLS: optional, consecutive polls before a change is reported, default 1
+// - FLAP_THRESHOLD: optional, changes within FLAP_WINDOW_MIN that mark an ID as flapping, default 0 (disabled)
+// - FLAP_WINDOW_MIN: optional, default 10
+// - UNREACHABLE_AFTER_POLLS: optional, consecutive failed polls before "PBX unreachable" is posted, default 2
+// - INCIDENT_MODE: optional, how follow-ups to a down peer/provider/PBX are posted: off (new message each time), edit (edit the first message, default), thread (reply in a thread; needs Create Public Threads permission)
+// - INCIDENT_REMIND_MIN: optional, "still down" follow-up interval, default 10 (0 = none)
+// - STATE_HEALTH_MAP: optional, overrides state classification e.g. "LAGGED=down,UNKNOWN=degraded"
+// - CDR_ENABLED: optional, post each finished call (caller, callee, talk time, result) from the MikoPBX call history, default false
+// - CDR_CHANNEL_ID: optional, channel for the call feed (default: the site's notification channel)
+// - CDR_POLL_INTERVAL_SEC: optional, default 60
+// - CDR_IMPORTANT_NUMBERS: optional, comma separated numbers whose calls are highlighted, e.g. "0312345678,200"
+// - CALLS_PIN_ENABLED: optional, keep a pinned message listing calls in progress on the PBX up to date (needs Manage Messages permission), default false; /calls works regardless
+// - CALLS_CHANNEL_ID: optional, channel for the pinned list (default: the site's notification channel)
+// - CALLS_UPDATE_INTERVAL_SEC: optional, default 30
+// - PHONEBOOK_FILE: optional, JSON file keeping external contacts added with /phonebook add (memory only if empty); extensions are imported from MikoPBX for /call <name>
+// - PHONEBOOK_SYNC_MIN: optional, how often extension names are re-imported, default 60
+// - OKI_SIP_DIAL_ALLOW_PREFIXES / OKI_SIP_DIAL_BLOCK_PREFIXES: optional, comma separated number prefixes allowed / refused for dialing and transfer (block wins; empty allow = any)
+// - OKI_SIP_DIAL_MAX_LENGTH: optional, longest number that may be dialed, default 0 (unlimited)
+// - OKI_SIP_DIAL_DIGITS_ONLY: optional, refuse numbers containing * # +, default false
+// - OKI_SIP_ANNOUNCEMENTS: optional, NAME=/path/to.wav,... played over G.711 RTP when the callee answers /oki message:NAME, then hung up (8 kHz mono 16 bit PCM, µ-law or A-law WAV, or raw .pcm)
+// - OKI_SIP_MEDIA_IP: optional, RTP address written in the SDP offer (default: the local address used to reach OKI_SIP_SERVER)
+// - DIAL_ALLOWED_ROLES / DIAL_ALLOWED_USERS: optional, comma separated Discord role / user IDs allowed to use /oki, /oki-hangup, /call, /oncall call and the incoming call buttons (anyone if both empty); per-command lists go under access.commands in the YAML file
+// - AUDIT_FILE: optional, append-only JSONL log of calls placed/hung up/transferred, incoming rejects, denials and other commands; read with /audit (admins, CSV export) (disabled if empty)
+// - ONCALL_FILE: optional, JSON file keeping the on-call rotation and overrides managed with /oncall (memory only if empty)
+// - ONCALL_TIMEZONE / ONCALL_HANDOFF_DAY / ONCALL_HANDOFF_TIME: optional, weekly handoff until changed with /oncall handoff, default Asia/Tokyo / monday / 09:00
+// - ONCALL_MENTION: optional, mention the current on-call person in down/unreachable notifications, default true
+// - ESCALATION_ENABLED: optional, phone the on-call list when an incident opens and wait for "1" (RFC 4733 DTMF) to acknowledge it in the incident thread, default false
+// - ESCALATION_ONCALL: optional, comma separated NAME=NUMBER (or NUMBER) called in order after the current on-call person; no answer / no key moves on to the next
+// - ESCALATION_ANNOUNCEMENT: name in OKI_SIP_ANNOUNCEMENTS repeated to the callee while waiting for the key
+// - ESCALATION_TRIGGER: optional, provider|peer|all, default provider
+// - ESCALATION_DELAY_SEC / ESCALATION_RING_SEC / ESCALATION_ACK_WAIT_SEC / ESCALATION_ROUNDS: optional, defaults 0 / 30 / 30 / 1
+// - MAINTENANCE_FILE: optional, JSON file keeping maintenance windows declared with /maintenance start (memory only if empty); recurring windows (cron) go under maintenance.schedules in the YAML file
+// - MAINTENANCE_MODE: optional, what happens to changes inside a window: suppress (not posted) or quiet (posted without mention, incident or phone call); either way a summary is posted when the window closes, default suppress
+// - MAINTENANCE_TIMEZONE: optional, time zone of the cron schedules, default Asia/Tokyo
+// - ROUTING_TIMEZONE: optional, time zone of the days/hours conditions of routing.rules (YAML only: per peer/provider channel, role mentions or drop; check with /routing test), default Asia/Tokyo
+// - NOTIFY_MIN_SEVERITY: optional, info|warning|critical, lightest alert also sent to the backends below (recoveries count as warning), default info
+// - NOTIFY_SLACK_WEBHOOK_URL: optional, Slack incoming webhook receiving the same alerts as Discord
+// - NOTIFY_WEBHOOK_URL / NOTIFY_WEBHOOK_SECRET: optional, POST each alert as JSON; with a secret, X-Odenwakun-Signature is "sha256=" + HMAC-SHA256(secret, X-Odenwakun-Timestamp + "." + body)
+// - NOTIFY_NTFY_URL / NOTIFY_NTFY_TOPIC / NOTIFY_NTFY_TOKEN: optional, ntfy server, topic and access token (anonymous if empty)
+// - NOTIFY_GOTIFY_URL / NOTIFY_GOTIFY_TOKEN: optional, Gotify server and application token
+// - NOTIFY_SMTP_HOST / NOTIFY_SMTP_PORT / NOTIFY_SMTP_USERNAME / NOTIFY_SMTP_PASSWORD / NOTIFY_SMTP_FROM / NOTIFY_SMTP_TO / NOTIFY_SMTP_TLS: optional, mail alerts (TO comma separated, TLS starttls|tls|none), default port 587, starttls; check every backend with /notify-test
+// - HTTP_LISTEN: optional, address for the HTTP server exposing Prometheus /metrics and /healthz, /readyz (JSON per component), e.g. ":9100" (disabled if empty)
 // Flags:
+// - --config: path of YAML config file
+// - --print-config: print the effective config (secrets redacted) and exit
+// - --healthcheck: query /healthz on the http.listen address of the same config and exit 0 if healthy (fails when HTTP is disabled); used by the Docker HEALTHCHECK
 // - --debug: enable verbose HTTP logging for MikoPBX client
 func main() {
-	// Parse flags (debug only)
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path of YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective config (secrets redacted) and exit")
+	healthcheck := flag.Bool("healthcheck", false, "query /healthz of the running bot and exit 0 if healthy")
 	debug := flag.Bool("debug", false, "enable verbose HTTP logging for MikoPBX client")
 	flag.Parse()
 	rand.Seed(time.Now().UnixNano())
 
-	// Discord (env)
-	token := os.Getenv("DISCORD_TOKEN")
-	channelID := os.Getenv("DISCORD_CHANNEL_ID")
-	if token == "" || channelID == "" {
-		log.Fatal("DISCORD_TOKEN and DISCORD_CHANNEL_ID must be set")
+	cfg, cfgErr := config.Load(*configPath)
+	if *printConfig {
+		os.Exit(runPrintConfig(cfg, cfgErr))
 	}
-	ds, err := discordgo.New("Bot " + token)
+	if *healthcheck {
+		os.Exit(runHealthcheck(cfg, cfgErr))
+	}
+	if cfgErr != nil {
+		log.Fatal(cfgErr)
+	}
+
+	// Discord
+	ds, err := discordgo.New("Bot " + cfg.Discord.Token)
 	if err != nil {
 		log.Fatalf("failed to create Discord session: %v", err)
 	}
-	// メッセージコマンド対応（!denwachan）用に必要なIntentを付与
-	ds.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+	// スラッシュコマンドのみなので特権Intent（MessageContent）は不要
+	ds.Identify.Intents = discordgo.IntentsGuilds
 	if err := ds.Open(); err != nil {
 		log.Fatalf("failed to open Discord: %v", err)
 	}
 	defer ds.Close()
 
-	// おまけ: !denwachan コマンドにランダムで返答
-	ds.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		if m.Author == nil || m.Author.Bot {
-			return
-		}
-		// ギルド内メッセージのみ対象
-		if m.GuildID == "" {
-			return
-		}
-		if strings.HasPrefix(m.Content, "!denwachan") {
-			replies := []string{
-				"わぁっ！",
-				"なんでしょうか？",
-				"どうされましたか",
-			}
-			s.ChannelMessageSend(m.ChannelID, replies[rand.Intn(len(replies))])
-		}
-	})
-
-	// SIP: 起動時Register、!oki <number> でINVITE発信
-	oki, err := sipclient.NewFromEnv()
+	// SIP: 起動時Register、/oki <number> でINVITE発信、着信はチャンネルに通知
+	oki, err := sipclient.New(cfg.SIP)
 	if err != nil {
 		log.Fatalf("SIP init error: %v", err)
 	}
+	incoming := newIncomingNotifier(ds, cfg.Discord.ChannelID)
+	oki.OnIncoming(incoming.handle)
 	if err := oki.Start(); err != nil {
 		log.Fatalf("SIP start error: %v", err)
 	}
-	ds.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		if m.Author == nil || m.Author.Bot {
-			return
+
+	// MikoPBX: サイトごとにクライアントとWatcherを立て、互いに待たせないよう別goroutineで回す
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// オンコール当番表: 通知のメンションとエスカレーションの最初の電話先に使う
+	sched, err := newOnCallSchedule(cfg)
+	if err != nil {
+		log.Fatalf("oncall: %v", err)
+	}
+	var esc *escalation.Escalator
+	if cfg.Escalation.Enabled {
+		esc = newEscalator(cfg, oki, sched)
+	}
+	// メンテナンス: 期間中の通知を止め、終わったらまとめて出す
+	maint, err := newMaintenance(cfg)
+	if err != nil {
+		log.Fatalf("maintenance: %v", err)
+	}
+	// 通知の振り分け: 端末・プロバイダの変化ごとに送り先・メンションを決める
+	rules, err := newRouting(cfg)
+	if err != nil {
+		log.Fatalf("routing: %v", err)
+	}
+	// Discord 以外の送り先: 同じ通知を Slack・Webhook・ntfy・Gotify・メールにも流す
+	backends := newNotifyBackends(cfg)
+	var watchers []*watcher.Watcher
+	for _, site := range cfg.Targets() {
+		w, err := newSiteWatcher(ds, cfg, site, *debug)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if m.GuildID == "" {
-			return
+		if esc != nil {
+			w.Escalation = esc
 		}
-		if strings.HasPrefix(m.Content, "!oki ") {
-			parts := strings.Fields(m.Content)
-			if len(parts) < 2 {
-				s.ChannelMessageSend(m.ChannelID, "使い方: !oki <電話番号>")
-				return
-			}
-			number := parts[1]
-			if err := oki.Invite(number); err != nil {
-				s.ChannelMessageSend(m.ChannelID, "発信エラー: "+err.Error())
-			} else {
-				s.ChannelMessageSend(m.ChannelID, "OKIコール発信: "+number)
+		w.Maintenance = maint
+		w.Routing = rules
+		if backends != nil {
+			w.Mirror(backends)
+		}
+		if cfg.OnCall.Mention {
+			w.OnCall = func() string {
+				if sh, ok := sched.Current(time.Now()); ok {
+					return sh.Member.UserID
+				}
+				return ""
 			}
 		}
-	})
+		watchers = append(watchers, w)
+		go func() {
+			authenticate(ctx, w)
+			w.Run(ctx)
+		}()
+		if cfg.CDR.Enabled {
+			go newCDRFeed(ds, cfg, site, w).Run(ctx)
+		}
+		if cfg.Calls.PinEnabled {
+			go newCallsBoard(ds, cfg, site, w).Run(ctx)
+		}
+	}
 
-	// MikoPBX (env)
-	base := os.Getenv("MIKOPBX_BASE_URL")
-	login := os.Getenv("MIKOPBX_LOGIN")
-	pass := os.Getenv("MIKOPBX_PASSWORD")
-	if base == "" {
-		log.Fatal("MIKOPBX_BASE_URL must be set")
-	}
-	cli, err := mikopbx.NewClient(base, login, pass)
+	// 電話帳: 内線は各サイトから定期的に取り込み、外線はファイルに保存
+	book, err := phonebook.New(cfg.Phonebook.File)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("phonebook: %v", err)
 	}
-	if debug != nil && *debug {
-		cli.SetDebug(true)
+	var pbxs []phonebook.PBX
+	for _, w := range watchers {
+		pbxs = append(pbxs, phonebook.PBX{Site: w.Site, Client: w.Client})
 	}
-	if err := cli.Authenticate(); err != nil {
-		// PBXが落ちている/未起動でもプロセスは落とさない
-		log.Printf("[WARN] MikoPBX authenticate failed (will retry on demand): %v", err)
+	go book.Run(ctx, pbxs, time.Duration(cfg.Phonebook.SyncIntervalMin)*time.Minute)
+
+	// 監査ログ: 発信・転送・管理コマンドを追記で残す
+	auditLog, err := audit.Open(cfg.Audit.File)
+	if err != nil {
+		log.Fatalf("audit log: %v", err)
 	}
 
-	// Interval (env)
-	interval := 30 * time.Second
-	if v := os.Getenv("POLL_INTERVAL_SEC"); v != "" {
-		if d, err := time.ParseDuration(v + "s"); err == nil {
-			interval = d
-		}
+	// Slash commands
+	router := commands.NewRouter()
+	router.Add(botCommands(oki, auditLog)...)
+	router.Add(pbxCommands(watchers)...)
+	router.Add(phonebookCommands(book, pbxs, oki, auditLog)...)
+	router.Add(auditCommand(auditLog))
+	router.Add(oncallCommands(sched, oki, auditLog)...)
+	router.Add(maintenanceCommands(maint, watchers)...)
+	router.Add(routingCommand(rules, watchers))
+	router.Add(notifyTestCommand(backends))
+	registerIncomingComponents(router, oki, incoming, auditLog)
+	registerPhonebookComponents(router, book, oki, auditLog)
+	applyAccess(router, cfg.Access)
+	if auditLog.Enabled() {
+		router.Observe(auditObserver(auditLog))
+	}
+	ds.AddHandler(router.Handle)
+	if err := router.Register(ds, cfg.Discord.GuildID); err != nil {
+		log.Fatalf("failed to register slash commands: %v", err)
 	}
 
-	// Watcher
-	w := watcher.New(cli, &watcher.DiscordNotifier{Session: ds, ChannelID: channelID}, interval)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go w.Run(ctx)
+	// HTTP: /metrics, /healthz, /readyz
+	var httpSrv *http.Server
+	if cfg.HTTP.Listen != "" {
+		httpSrv = startHTTP(cfg.HTTP.Listen, healthChecks(ds, oki, watchers))
+	}
 
 	log.Println("Watcher running. Press Ctrl+C to exit.")
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 	log.Println("Shutting down...")
+	cancel()
+	if httpSrv != nil {
+		stopHTTP(httpSrv)
+	}
 	oki.Shutdown()
 }
+
+// runPrintConfig は実効設定（秘密は伏せ字）と検証結果を表示し、終了コードを返す
+func runPrintConfig(cfg *config.Config, cfgErr error) int {
+	if cfg == nil {
+		fmt.Fprintln(os.Stderr, cfgErr)
+		return 1
+	}
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(out)
+	if cfgErr != nil {
+		fmt.Fprintln(os.Stderr, cfgErr)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron は5フィールド（分 時 日 月 曜日）のcron式。
+// 各フィールドは * / 数字 / 範囲 a-b / 刻み */n a-b/n とそのカンマ区切り。月と曜日は jan / mon などの名前も使える。
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // 一致する値のビット
+	anyDOM, anyDOW                bool   // 日・曜日が * （両方指定ならどちらかが一致すればよい）
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dowNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron は "0 3 * * sun" のようなcron式を読む
+func ParseCron(spec string) (*Cron, error) {
+	s := strings.ToLower(strings.TrimSpace(spec))
+	if m, ok := cronMacros[s]; ok {
+		s = m
+	}
+	f := strings.Fields(s)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields (minute hour day month weekday)", spec)
+	}
+	var c Cron
+	var err error
+	if c.minute, err = parseField(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %q minute: %w", spec, err)
+	}
+	if c.hour, err = parseField(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron %q hour: %w", spec, err)
+	}
+	if c.dom, err = parseField(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron %q day: %w", spec, err)
+	}
+	if c.month, err = parseField(f[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("cron %q month: %w", spec, err)
+	}
+	if c.dow, err = parseField(f[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("cron %q weekday: %w", spec, err)
+	}
+	if c.dow&(1<<7) != 0 { // 7 も日曜
+		c.dow |= 1
+	}
+	c.anyDOM, c.anyDOW = f[2] == "*", f[4] == "*"
+	return &c, nil
+}
+
+// parseField は1フィールドを一致する値のビットにする。names は lo から順の別名。
+func parseField(s string, lo, hi int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if r, st, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			rng, step = r, n
+		}
+		from, to := lo, hi
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if from, err = fieldValue(a, lo, names); err != nil {
+				return 0, err
+			}
+			if to, err = fieldValue(b, lo, names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := fieldValue(rng, lo, names)
+			if err != nil {
+				return 0, err
+			}
+			from = v
+			if step == 1 {
+				to = v
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func fieldValue(s string, lo int, names []string) (int, error) {
+	for i, n := range names {
+		if s == n {
+			return lo + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return v, nil
+}
+
+// Match は t（の現地時刻の分）が式に一致するか
+func (c *Cron) Match(t time.Time) bool {
+	return c.minute&(1<<t.Minute()) != 0 && c.hour&(1<<t.Hour()) != 0 && c.dayMatch(t)
+}
+
+func (c *Cron) dayMatch(t time.Time) bool {
+	if c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next は after より後で最初に一致する時刻（loc の現地時刻で判定）を返す。before までに無ければ false。
+func (c *Cron) Next(after, before time.Time, loc *time.Location) (time.Time, bool) {
+	t := after.In(loc).Truncate(time.Minute).Add(time.Minute)
+	for t.Before(before) {
+		switch {
+		case !c.dayMatch(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,298 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("maintenance window not found")
+
+// Mode はメンテナンス中の変化の扱い
+type Mode string
+
+const (
+	Suppress Mode = "suppress" // 通知せず、終わったときにまとめて出す
+	Quiet    Mode = "quiet"    // メンション・インシデント・電話なしで通知し、終わったときにもまとめて出す
+)
+
+// Window はメンテナンスの期間と対象
+type Window struct {
+	ID     string    `json:"id"`             // /maintenance start なら "1" など、定期なら "cron1" など
+	Site   string    `json:"site,omitempty"` // 空なら全サイト
+	IDs    []string  `json:"ids,omitempty"`  // 端末・プロバイダのID。空ならPBX全体
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by,omitempty"` // 始めたDiscordユーザーのID
+}
+
+// Key は1回分の期間を表すキー（定期メンテナンスは回ごとに変わる）
+func (w Window) Key() string {
+	return fmt.Sprintf("%s@%d", w.ID, w.Start.Unix())
+}
+
+// Label は "#1" / "定期1"
+func (w Window) Label() string {
+	if n, ok := strings.CutPrefix(w.ID, "cron"); ok {
+		return "定期" + n
+	}
+	return "#" + w.ID
+}
+
+// Recurring は定期メンテナンスの回か
+func (w Window) Recurring() bool { return strings.HasPrefix(w.ID, "cron") }
+
+// ActiveAt は t が期間内か
+func (w Window) ActiveAt(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// Covers は site の id（空ならPBX自体）が対象か
+func (w Window) Covers(site, id string) bool {
+	if w.Site != "" && !strings.EqualFold(w.Site, site) {
+		return false
+	}
+	return len(w.IDs) == 0 || (id != "" && slices.Contains(w.IDs, id))
+}
+
+// Scope は「本社: PBX全体」のような対象の説明
+func (w Window) Scope() string {
+	site := w.Site
+	if site == "" {
+		site = "全サイト"
+	}
+	if len(w.IDs) == 0 {
+		return site + ": PBX全体"
+	}
+	return site + ": " + strings.Join(w.IDs, ", ")
+}
+
+// Schedule は cron で繰り返すメンテナンス
+type Schedule struct {
+	Cron     string
+	Duration time.Duration
+	Site     string
+	IDs      []string
+	Reason   string
+
+	cron *Cron
+}
+
+// Manager は /maintenance で入れた期間（path のJSONファイルに保存。空ならメモリのみ）と定期メンテナンスを管理する
+type Manager struct {
+	Mode Mode
+
+	path      string
+	loc       *time.Location // cron の時刻のタイムゾーン
+	schedules []Schedule
+
+	mu sync.Mutex
+	d  file
+}
+
+type file struct {
+	Windows []Window `json:"windows"`
+	Skipped []Window `json:"skipped,omitempty"` // 途中で止めた定期メンテナンスの回
+	NextID  int      `json:"next_id"`
+}
+
+// New は path から期間を読み、定期メンテナンスの cron 式を確かめる
+func New(path string, loc *time.Location, schedules []Schedule) (*Manager, error) {
+	m := &Manager{Mode: Suppress, path: path, loc: loc, d: file{NextID: 1}}
+	for i, s := range schedules {
+		c, err := ParseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+		if s.Duration < time.Minute {
+			return nil, fmt.Errorf("schedule %d: duration must be at least 1 minute", i+1)
+		}
+		s.cron = c
+		m.schedules = append(m.schedules, s)
+	}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(raw, &m.d); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", path, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Location は cron の時刻のタイムゾーン
+func (m *Manager) Location() *time.Location { return m.loc }
+
+// Start は期間を加える（ID は振り直す）
+func (m *Manager) Start(w Window) (Window, error) {
+	if !w.End.After(w.Start) {
+		return Window{}, errors.New("maintenance ends before it starts")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.ID = strconv.Itoa(m.d.NextID)
+	m.d.NextID++
+	m.d.Windows = append(m.d.Windows, w)
+	return w, m.save()
+}
+
+// Stop は id の期間を now で終わらせる（始まる前なら取り消す）。定期メンテナンスは今の回だけ終わらせる。
+func (m *Manager) Stop(id string, now time.Time) (Window, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := slices.IndexFunc(m.d.Windows, func(w Window) bool { return w.ID == id }); i >= 0 {
+		w := m.d.Windows[i]
+		if !w.End.After(now) {
+			return Window{}, ErrNotFound
+		}
+		if now.Before(w.Start) {
+			m.d.Windows = slices.Delete(m.d.Windows, i, i+1)
+		} else {
+			m.d.Windows[i].End = now
+		}
+		return w, m.save()
+	}
+	for _, w := range m.occurrences(now) {
+		if w.ID == id {
+			m.d.Skipped = append(m.d.Skipped, w)
+			return w, m.save()
+		}
+	}
+	return Window{}, ErrNotFound
+}
+
+// Active は site の id（空ならPBX自体）を対象にしている今の期間を返す
+func (m *Manager) Active(site, id string, now time.Time) (Window, bool) {
+	for _, w := range m.active(now) {
+		if w.Covers(site, id) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// ActiveFor は site が（一部でも）対象になっている今の期間を返す
+func (m *Manager) ActiveFor(site string, now time.Time) []Window {
+	var out []Window
+	for _, w := range m.active(now) {
+		if w.Site == "" || strings.EqualFold(w.Site, site) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// IsActive は Key が key の期間が今も続いているか
+func (m *Manager) IsActive(key string, now time.Time) bool {
+	return slices.ContainsFunc(m.active(now), func(w Window) bool { return w.Key() == key })
+}
+
+// List は今の期間とこれからの期間を開始順に返す。定期メンテナンスは次の回を含める（終わった期間はここで片付ける）。
+func (m *Manager) List(now time.Time) []Window {
+	m.mu.Lock()
+	m.prune(now)
+	out := slices.Clone(m.d.Windows)
+	out = append(out, m.occurrences(now)...)
+	m.mu.Unlock()
+	for i, s := range m.schedules {
+		if next, ok := s.cron.Next(now, now.AddDate(1, 0, 0), m.loc); ok {
+			out = append(out, s.window(i, next))
+		}
+	}
+	slices.SortFunc(out, func(a, b Window) int { return a.Start.Compare(b.Start) })
+	return out
+}
+
+func (m *Manager) active(now time.Time) []Window {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Window
+	for _, w := range m.d.Windows {
+		if w.ActiveAt(now) {
+			out = append(out, w)
+		}
+	}
+	return append(out, m.occurrences(now)...)
+}
+
+// occurrences は now に続いている定期メンテナンスの回（止めた回は除く）
+func (m *Manager) occurrences(now time.Time) []Window {
+	var out []Window
+	for i, s := range m.schedules {
+		t := now.In(m.loc).Truncate(time.Minute)
+		for back := time.Duration(0); back < s.Duration; back += time.Minute {
+			if start := t.Add(-back); s.cron.Match(start.In(m.loc)) {
+				w := s.window(i, start)
+				if w.ActiveAt(now) && !slices.ContainsFunc(m.d.Skipped, func(sk Window) bool { return sk.Key() == w.Key() }) {
+					out = append(out, w)
+				}
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (s Schedule) window(i int, start time.Time) Window {
+	return Window{
+		ID:     fmt.Sprintf("cron%d", i+1),
+		Site:   s.Site,
+		IDs:    s.IDs,
+		Start:  start,
+		End:    start.Add(s.Duration),
+		Reason: s.Reason,
+	}
+}
+
+// prune は終わった期間を消す
+func (m *Manager) prune(now time.Time) {
+	n, k := len(m.d.Windows), len(m.d.Skipped)
+	ended := func(w Window) bool { return !w.End.After(now) }
+	m.d.Windows = slices.DeleteFunc(m.d.Windows, ended)
+	m.d.Skipped = slices.DeleteFunc(m.d.Skipped, ended)
+	if len(m.d.Windows) != n || len(m.d.Skipped) != k {
+		_ = m.save()
+	}
+}
+
+func (m *Manager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(m.d, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 書き込み途中で落ちても壊れないよう一時ファイル経由で置き換える
+	dir := filepath.Dir(m.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(m.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
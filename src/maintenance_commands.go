@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/maintenance"
+	"tacnet-odenwakun/src/watcher"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maintenanceCommands はメンテナンス期間（その間は通知を止める）の開始・終了・一覧のコマンド
+func maintenanceCommands(m *maintenance.Manager, watchers []*watcher.Watcher) []*commands.Command {
+	startOpts := []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "duration", Description: "長さ（例: 2h, 90m, 1h30m）", Required: true, MaxLength: 16},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "reason", Description: "理由", MaxLength: 100},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "ids", Description: "端末・プロバイダのID（カンマ区切り。省略時はPBX全体）", MaxLength: 200},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "開始（YYYY-MM-DD HH:MM。既定は今）", MaxLength: 16},
+	}
+	if len(watchers) > 1 {
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, w := range watchers {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: w.Site, Value: w.Site})
+		}
+		if len(choices) > 25 {
+			choices = choices[:25]
+		}
+		startOpts = append(startOpts, &discordgo.ApplicationCommandOption{
+			Type: discordgo.ApplicationCommandOptionString, Name: "site", Description: "サイト（省略時は全て）", Choices: choices,
+		})
+	}
+	completeWindow := func(c *commands.Context, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+		now := time.Now()
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, w := range m.List(now) {
+			if w.Recurring() && !w.ActiveAt(now) {
+				continue // 定期メンテナンスは今の回だけ止められる
+			}
+			name := fmt.Sprintf("%s %s〜%s %s %s", w.Label(), w.Start.In(m.Location()).Format("01/02 15:04"),
+				w.End.In(m.Location()).Format("01/02 15:04"), w.Scope(), w.Reason)
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncateRunes(strings.TrimSpace(name), 100), Value: w.ID})
+			if len(choices) == 25 {
+				break
+			}
+		}
+		return choices
+	}
+
+	return []*commands.Command{
+		{
+			Name:        "maintenance",
+			Description: "メンテナンス期間（その間は通知を止め、終わったらまとめて出す）",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "start",
+					Description: "メンテナンスを始める",
+					Options:     startOpts,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "stop",
+					Description: "メンテナンスを終える（始まる前なら取り消す）",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "メンテナンス", Required: true, Autocomplete: true},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "今とこれからのメンテナンスを見る",
+				},
+			},
+			Autocomplete: map[string]commands.AutocompleteHandler{"id": completeWindow},
+			Handler: func(c *commands.Context) error {
+				now := time.Now()
+				loc := m.Location()
+				switch c.Subcommand {
+				case "start":
+					d, err := time.ParseDuration(strings.TrimSpace(c.String("duration")))
+					if err != nil || d < time.Minute {
+						return commands.Errorf("長さは 2h, 90m, 1h30m のように1分以上で指定してください: %s", c.String("duration"))
+					}
+					start := now
+					if from := strings.TrimSpace(c.String("from")); from != "" {
+						t, err := time.ParseInLocation("2006-01-02 15:04", from, loc)
+						if err != nil {
+							return commands.Errorf("開始は YYYY-MM-DD HH:MM で指定してください: %s", from)
+						}
+						start = t
+					}
+					if !start.Add(d).After(now) {
+						return commands.Errorf("その期間はもう終わっています")
+					}
+					var ids []string
+					for _, id := range strings.Split(c.String("ids"), ",") {
+						if id = strings.TrimSpace(id); id != "" {
+							ids = append(ids, id)
+						}
+					}
+					w, err := m.Start(maintenance.Window{
+						Site:   c.String("site"),
+						IDs:    ids,
+						Start:  start,
+						End:    start.Add(d),
+						Reason: strings.TrimSpace(c.String("reason")),
+						By:     c.User().ID,
+					})
+					if err != nil {
+						return err
+					}
+					return c.Reply(fmt.Sprintf("🛠 メンテナンス %s: %s〜%s（%s）%s\n終わるまで通知を止め、終わったら期間中の変化をまとめて出します",
+						w.Label(), w.Start.In(loc).Format("01/02 15:04"), w.End.In(loc).Format("01/02 15:04 MST"), w.Scope(), w.Reason))
+				case "stop":
+					w, err := m.Stop(strings.TrimPrefix(strings.TrimSpace(c.String("id")), "#"), now)
+					if errors.Is(err, maintenance.ErrNotFound) {
+						return commands.Errorf("そのメンテナンスは見つかりません（終わっているか、定期メンテナンスの期間外です）")
+					}
+					if err != nil {
+						return err
+					}
+					if now.Before(w.Start) {
+						return c.Reply(fmt.Sprintf("↩️ メンテナンス %s を取り消しました", w.Label()))
+					}
+					return c.Reply(fmt.Sprintf("✅ メンテナンス %s を終えました（まとめは次のポーリングで出します）", w.Label()))
+				case "list":
+					return c.ReplyEmbed("", maintenanceEmbed(m, now), false)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// maintenanceEmbed は今とこれからのメンテナンスを表す
+func maintenanceEmbed(m *maintenance.Manager, now time.Time) *discordgo.MessageEmbed {
+	loc := m.Location()
+	embed := &discordgo.MessageEmbed{
+		Title: "🛠 メンテナンス",
+		Color: 0x3498DB, // blue
+	}
+	var active, upcoming []string
+	for _, w := range m.List(now) {
+		line := fmt.Sprintf("%s %s〜%s %s", w.Label(), w.Start.In(loc).Format("01/02(Mon) 15:04"), w.End.In(loc).Format("01/02 15:04"), w.Scope())
+		if w.Reason != "" {
+			line += " " + w.Reason
+		}
+		if w.By != "" {
+			line += fmt.Sprintf("（<@%s>）", w.By)
+		}
+		if w.ActiveAt(now) {
+			active = append(active, line)
+		} else {
+			upcoming = append(upcoming, line)
+		}
+	}
+	if len(active) == 0 && len(upcoming) == 0 {
+		embed.Description = "予定されたメンテナンスはありません（/maintenance start で始められます）"
+		return embed
+	}
+	if len(active) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "実施中", Value: truncateRunes(strings.Join(active, "\n"), 1024)})
+	}
+	if len(upcoming) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "予定", Value: truncateRunes(strings.Join(upcoming, "\n"), 1024)})
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("時刻は %s", loc)}
+	return embed
+}
@@ -0,0 +1,28 @@
+package metrics
+
+// Bot が出すメトリクス（/metrics）。site はサイト名（単一PBXなら空）。
+var (
+	// 監視対象（Watcher の生の取得結果。デバウンス前の値）
+	PeerHealth     = NewGaugeVec("odenwakun_peer_health", "Peer health from getPeersStatuses (0=down, 1=degraded, 2=healthy).", "site", "peer")
+	PeerState      = NewGaugeVec("odenwakun_peer_state", "Current state of each peer as a label (always 1).", "site", "peer", "state")
+	ProviderHealth = NewGaugeVec("odenwakun_provider_health", "Provider health from getRegistry (0=down, 1=degraded, 2=healthy).", "site", "provider")
+	ProviderState  = NewGaugeVec("odenwakun_provider_state", "Current state of each provider as a label (always 1).", "site", "provider", "state")
+
+	// ポーリング
+	PollDuration = NewHistogramVec("odenwakun_poll_duration_seconds", "Time taken by one MikoPBX poll including retries.", DefBuckets, "site", "endpoint")
+	PollFailures = NewCounterVec("odenwakun_poll_failures_total", "Polls that failed after all retries.", "site", "endpoint")
+	PBXUp        = NewGaugeVec("odenwakun_mikopbx_up", "1 if the last poll of MikoPBX succeeded.", "site")
+
+	// MikoPBX API クライアント
+	MikoPBXRequests = NewCounterVec("odenwakun_mikopbx_requests_total", "HTTP requests sent to MikoPBX, including retries.", "site", "op")
+	MikoPBXRetries  = NewCounterVec("odenwakun_mikopbx_retries_total", "Retries made by getWithRetry/postJSONWithRetry.", "site", "op")
+	MikoPBXErrors   = NewCounterVec("odenwakun_mikopbx_errors_total", "MikoPBX API calls that failed, by kind (unreachable, auth_rejected, bad_response).", "site", "op", "kind")
+
+	// SIP（OKIアカウント）
+	SIPRegistered       = NewGaugeVec("odenwakun_sip_registered", "1 if the last REGISTER succeeded.")
+	SIPRegisterStatus   = NewGaugeVec("odenwakun_sip_register_status_code", "SIP status code of the last REGISTER response.")
+	SIPRegisterExpiry   = NewGaugeVec("odenwakun_sip_registration_expires_timestamp_seconds", "Unix time when the current registration expires.")
+	SIPOutgoingCalls    = NewCounterVec("odenwakun_sip_outgoing_calls_total", "Finished outgoing calls by result (answered, unanswered).", "result")
+	SIPIncomingCalls    = NewCounterVec("odenwakun_sip_incoming_calls_total", "Finished incoming calls by outcome (missed, rejected, transferred).", "outcome")
+	SIPCallTalkDuration = NewHistogramVec("odenwakun_sip_call_talk_seconds", "Talk time of answered outgoing calls.", []float64{5, 15, 30, 60, 120, 300, 600, 1800})
+)
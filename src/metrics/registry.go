@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prometheus のテキスト形式だけを出す最小限の実装（client_golang は使わない）
+
+type family interface {
+	name() string
+	write(w io.Writer)
+}
+
+// registry はメトリクスの一覧。同名で登録し直すと置き換える。
+type registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+var defaultRegistry = &registry{}
+
+func (r *registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, old := range r.families {
+		if old.name() == f.name() {
+			r.families[i] = f
+			return
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+// Handler は /metrics 用のハンドラを返す
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		defaultRegistry.mu.Lock()
+		fams := append([]family(nil), defaultRegistry.families...)
+		defaultRegistry.mu.Unlock()
+		for _, f := range fams {
+			f.write(w)
+		}
+	})
+}
+
+// vec はラベル値ごとの系列を持つ共通部分
+type vec struct {
+	fname, help, typ string
+	labels           []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values  []string
+	value   float64
+	buckets []uint64 // ヒストグラムのみ（各バケットの個数、累積ではない）
+	count   uint64
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{fname: name, help: help, typ: typ, labels: labels, series: map[string]*series{}}
+}
+
+func (v *vec) name() string { return v.fname }
+
+// get はラベル値の系列を返す（mu を持った状態で呼ぶ）
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.fname, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) sorted() []*series {
+	out := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].values, "\xff") < strings.Join(out[j].values, "\xff")
+	})
+	return out
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.fname, escapeHelp(v.help), v.fname, v.typ)
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.fname, labelString(v.labels, s.values, "", ""), formatFloat(s.value))
+	}
+}
+
+// CounterVec は単調増加するカウンタ
+type CounterVec struct{ *vec }
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels)}
+	defaultRegistry.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) { c.Add(1, values...) }
+
+func (c *CounterVec) Add(d float64, values ...string) {
+	if d < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value += d
+}
+
+// GaugeVec は上下する値
+type GaugeVec struct{ *vec }
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels)}
+	defaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(values).value = v
+}
+
+// DeleteMatching は label=value の系列を全て消す（消えた端末などを残さないため）
+func (g *GaugeVec) DeleteMatching(label, value string) {
+	idx := -1
+	for i, l := range g.labels {
+		if l == label {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k, s := range g.series {
+		if s.values[idx] == value {
+			delete(g.series, k)
+		}
+	}
+}
+
+// gaugeFunc は取得時に値を計算するゲージ
+type gaugeFunc struct {
+	*vec
+	fn func() float64
+}
+
+// NewGaugeFunc は取得のたびに fn を呼ぶラベルなしゲージを登録する
+func NewGaugeFunc(name, help string, fn func() float64) {
+	defaultRegistry.register(&gaugeFunc{newVec(name, help, "gauge", nil), fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fname, formatFloat(g.fn()))
+}
+
+// HistogramVec は値の分布（バケットは上限値の昇順）
+type HistogramVec struct {
+	*vec
+	bounds []float64
+}
+
+// DefBuckets は秒単位のレイテンシ向けの既定バケット
+var DefBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, "histogram", labels), append([]float64(nil), buckets...)}
+	sort.Float64s(h.bounds)
+	defaultRegistry.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, b := range h.bounds {
+		if v <= b {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, s := range h.sorted() {
+		var cum uint64
+		for i, b := range h.bounds {
+			if s.buckets != nil {
+				cum += s.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, labelString(h.labels, s.values, "le", formatFloat(b)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, labelString(h.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fname, labelString(h.labels, s.values, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fname, labelString(h.labels, s.values, "", ""), s.count)
+	}
+}
+
+// labelString は {a="x",b="y"} を作る（extra は le など追加の1ラベル）
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
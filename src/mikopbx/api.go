@@ -2,6 +2,7 @@ package mikopbx
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"tacnet-odenwakun/src/metrics"
 )
 
 type Client struct {
@@ -21,6 +24,36 @@ type Client struct {
 	password string
 	http     *http.Client
 	debug    bool
+	retry    RetryPolicy
+	name     string // メトリクスの site ラベル
+}
+
+// RetryPolicy はAPI呼び出しのリトライ上限。どちらかに達したら諦めてエラーを返す。
+type RetryPolicy struct {
+	MaxAttempts     int           // 1回の呼び出しでの最大試行回数（0で無制限）
+	MaxElapsed      time.Duration // 1回の呼び出しにかける最大時間（0で無制限）
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	RetryableStatus []int // リトライするHTTPステータス（401/403は常に再ログインして1回だけ再試行）
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     5,
+		MaxElapsed:      2 * time.Minute,
+		InitialBackoff:  time.Second,
+		MaxBackoff:      60 * time.Second,
+		RetryableStatus: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusInternalServerError},
+	}
+}
+
+func (p RetryPolicy) retryable(status int) bool {
+	for _, s := range p.RetryableStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
 }
 
 func NewClient(baseURL, login, password string) (*Client, error) {
@@ -34,14 +67,33 @@ func NewClient(baseURL, login, password string) (*Client, error) {
 		password: password,
 		http:     &http.Client{Timeout: 15 * time.Second, Jar: jar},
 		debug:    false,
+		retry:    DefaultRetryPolicy(),
 	}, nil
 }
 
 // SetDebug allows toggling debug logging at runtime (overrides env default).
 func (c *Client) SetDebug(v bool) { c.debug = v }
 
+// RetryPolicy returns the current retry limits.
+func (c *Client) RetryPolicy() RetryPolicy { return c.retry }
+
+// SetName sets the site name used as a metrics label.
+func (c *Client) SetName(name string) { c.name = name }
+
+// SetRetryPolicy replaces the retry limits (call before use).
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = time.Second
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	c.retry = p
+}
+
 // Authenticate obtains a PHPSESSID cookie if credentials are provided.
-func (c *Client) Authenticate() error {
+// Returns ErrAuthRejected if the PBX refuses the login, ErrUnreachable if it cannot be reached.
+func (c *Client) Authenticate(ctx context.Context) error {
 	if c.login == "" || c.password == "" {
 		// Assume no auth needed (e.g., localhost) per docs.
 		return nil
@@ -49,7 +101,7 @@ func (c *Client) Authenticate() error {
 	form := url.Values{}
 	form.Set("login", c.login)
 	form.Set("password", c.password)
-	req, err := http.NewRequest("POST", c.baseURL+"/admin-cabinet/session/start", strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/admin-cabinet/session/start", strings.NewReader(form.Encode()))
 	if err != nil {
 		return err
 	}
@@ -62,7 +114,10 @@ func (c *Client) Authenticate() error {
 	}
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &Error{Op: "authenticate", Kind: ErrUnreachable, Attempts: 1, Err: err}
 	}
 	defer resp.Body.Close()
 	if c.debug {
@@ -70,7 +125,11 @@ func (c *Client) Authenticate() error {
 	}
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		return fmt.Errorf("auth failed: %s (%s)", resp.Status, string(b))
+		kind := ErrAuthRejected
+		if resp.StatusCode >= 500 {
+			kind = ErrUnreachable
+		}
+		return &Error{Op: "authenticate", Kind: kind, StatusCode: resp.StatusCode, Attempts: 1, Err: errors.New(previewJSON(b, 512))}
 	}
 	// Body contains JSON {success:true,...} but cookie is what we need; cookie jar captures it.
 	return nil
@@ -104,51 +163,39 @@ type RegistryResponse struct {
 	} `json:"data"`
 }
 
-func (c *Client) GetPeersStatuses() (PeersStatusesResponse, error) {
+func (c *Client) GetPeersStatuses(ctx context.Context) (PeersStatusesResponse, error) {
 	var out PeersStatusesResponse
-	url := c.baseURL + "/pbxcore/api/sip/getPeersStatuses"
-	status, b, err := c.getWithRetry(url)
-	if err != nil {
-		return out, err
-	}
-	if status != http.StatusOK {
-		return out, fmt.Errorf("getPeersStatuses %d: %s", status, string(b))
-	}
-	if err := json.Unmarshal(b, &out); err != nil {
-		return out, err
-	}
-	return out, nil
+	err := c.getJSON(ctx, "getPeersStatuses", "/pbxcore/api/sip/getPeersStatuses", &out)
+	return out, c.countError("getPeersStatuses", err)
 }
 
-func (c *Client) GetRegistry() (RegistryResponse, error) {
+func (c *Client) GetRegistry(ctx context.Context) (RegistryResponse, error) {
 	var out RegistryResponse
-	url := c.baseURL + "/pbxcore/api/sip/getRegistry"
-	status, b, err := c.getWithRetry(url)
-	if err != nil {
-		return out, err
-	}
-	if status != http.StatusOK {
-		return out, fmt.Errorf("getRegistry %d: %s", status, string(b))
-	}
-	if err := json.Unmarshal(b, &out); err != nil {
-		return out, err
-	}
-	return out, nil
+	err := c.getJSON(ctx, "getRegistry", "/pbxcore/api/sip/getRegistry", &out)
+	return out, c.countError("getRegistry", err)
 }
 
 // 指定したPeer IDの詳細を取得して表示名を返す（見つからなければ空文字）
-func (c *Client) GetPeerName(id string) (string, error) {
+func (c *Client) GetPeerName(ctx context.Context, id string) (string, error) {
+	name, err := c.getPeerName(ctx, id)
+	return name, c.countError("getSipPeer", err)
+}
+
+func (c *Client) getPeerName(ctx context.Context, id string) (string, error) {
 	if id == "" {
 		return "", nil
 	}
 	payload := map[string]string{"peer": id}
-	status, b := c.postJSONWithRetry("/pbxcore/api/sip/getSipPeer", payload)
+	status, b, err := c.postJSONWithRetry(ctx, "getSipPeer", "/pbxcore/api/sip/getSipPeer", payload)
+	if err != nil {
+		return "", err
+	}
 	if status != http.StatusOK {
-		return "", fmt.Errorf("getSipPeer %d: %s", status, string(b))
+		return "", badResponse("getSipPeer", status, b)
 	}
 	var out SipPeerResponse
 	if err := json.Unmarshal(b, &out); err != nil {
-		return "", err
+		return "", &Error{Op: "getSipPeer", Kind: ErrBadResponse, StatusCode: status, Err: err}
 	}
 	if !out.Result {
 		return "", nil
@@ -156,9 +203,13 @@ func (c *Client) GetPeerName(id string) (string, error) {
 	return out.Data.EndpointName, nil
 }
 
-// Optional helper retained for compatibility: returns a synthetic http.Response using unlimited retry logic.
-func (c *Client) PostJSON(path string, payload any) (*http.Response, error) {
-	status, body := c.postJSONWithRetry(path, payload)
+// Optional helper retained for compatibility: returns a synthetic http.Response using the client's retry policy.
+// op は再試行のログ・メトリクスのラベル（"getSipPeer" のような固定の操作名。パスをそのまま渡さない）
+func (c *Client) PostJSON(ctx context.Context, op, path string, payload any) (*http.Response, error) {
+	status, body, err := c.postJSONWithRetry(ctx, op, path, payload)
+	if err = c.countError(op, err); err != nil {
+		return nil, err
+	}
 	resp := &http.Response{
 		StatusCode: status,
 		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
@@ -168,96 +219,145 @@ func (c *Client) PostJSON(path string, payload any) (*http.Response, error) {
 	return resp, nil
 }
 
+// getJSON は path をGETし、200ならJSONを out に読み込む
+func (c *Client) getJSON(ctx context.Context, op, path string, out any) error {
+	status, b, err := c.getWithRetry(ctx, op, c.baseURL+path)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return badResponse(op, status, b)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return &Error{Op: op, Kind: ErrBadResponse, StatusCode: status, Err: err}
+	}
+	return nil
+}
+
+func badResponse(op string, status int, body []byte) error {
+	return &Error{Op: op, Kind: ErrBadResponse, StatusCode: status, Err: errors.New(previewJSON(body, 512))}
+}
+
 // --- Internal retry helpers ---
-func (c *Client) getWithRetry(u string) (int, []byte, error) {
-	backoff := time.Second
-	attempt := 1
-	for {
-		req, _ := http.NewRequest("GET", u, nil)
+func (c *Client) getWithRetry(ctx context.Context, op, u string) (int, []byte, error) {
+	return c.doWithRetry(ctx, op, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", u, nil)
+	})
+}
+
+func (c *Client) postJSONWithRetry(ctx context.Context, op, path string, payload any) (int, []byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	u := c.baseURL + path
+	return c.doWithRetry(ctx, op, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	})
+}
+
+// doWithRetry はリトライポリシーに従ってリクエストを繰り返す。
+// 接続失敗とリトライ対象ステータスはバックオフして再試行し、上限に達したら ErrUnreachable。
+// 401/403 は再ログインして1回だけ再試行し、それでも拒否されたら ErrAuthRejected。
+// それ以外のステータスはそのまま返す（判断は呼び出し側）。
+func (c *Client) doWithRetry(ctx context.Context, op string, newReq func() (*http.Request, error)) (int, []byte, error) {
+	p := c.retry
+	started := time.Now()
+	backoff := p.InitialBackoff
+	reauthed := false
+	var lastErr error
+	lastStatus := 0
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return 0, nil, err
+		}
 		if c.debug {
-			log.Printf("[MikoPBX][REQ] GET %s (attempt=%d)", req.URL.String(), attempt)
+			log.Printf("[MikoPBX][REQ] %s %s (attempt=%d)", req.Method, req.URL.String(), attempt)
 		}
+		metrics.MikoPBXRequests.Inc(c.name, op)
 		resp, err := c.http.Do(req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return 0, nil, ctx.Err()
+			}
+			lastErr, lastStatus = err, 0
 			if c.debug {
-				log.Printf("[MikoPBX][ERR] GET %s error: %v (retry in %s)", u, err, backoff)
+				log.Printf("[MikoPBX][ERR] %s %s error: %v", req.Method, req.URL.String(), err)
 			}
-			time.Sleep(backoff + jitter())
-			backoff = nextBackoff(backoff)
-			attempt++
-			continue
-		}
-		b, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if c.debug {
-			log.Printf("[MikoPBX][RES] %s %s Body: %s", resp.Status, u, previewJSON(b, 2000))
-		}
-		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-			_ = c.Authenticate()
-			time.Sleep(200*time.Millisecond + jitter()/2)
-			attempt++
-			continue
-		}
-		if resp.StatusCode >= 500 {
+		} else {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
 			if c.debug {
-				log.Printf("[MikoPBX][RETRY] %s returned %d, retry in %s", u, resp.StatusCode, backoff)
+				log.Printf("[MikoPBX][RES] %s %s Body: %s", resp.Status, req.URL.String(), previewJSON(b, 2000))
+			}
+			switch {
+			case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+				if reauthed {
+					return resp.StatusCode, b, &Error{Op: op, Kind: ErrAuthRejected, StatusCode: resp.StatusCode, Attempts: attempt}
+				}
+				reauthed = true
+				if err := c.Authenticate(ctx); err != nil {
+					return resp.StatusCode, b, err
+				}
+				// 再ログイン直後の再試行は待たない（試行回数にも数えない）
+				attempt--
+				continue
+			case p.retryable(resp.StatusCode):
+				lastErr, lastStatus = nil, resp.StatusCode
+			default:
+				return resp.StatusCode, b, nil
 			}
-			time.Sleep(backoff + jitter())
-			backoff = nextBackoff(backoff)
-			attempt++
-			continue
 		}
-		return resp.StatusCode, b, nil
-	}
-}
 
-func (c *Client) postJSONWithRetry(path string, payload any) (int, []byte) {
-	body, _ := json.Marshal(payload)
-	url := c.baseURL + path
-	backoff := time.Second
-	attempt := 1
-	for {
-		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		if c.debug {
-			log.Printf("[MikoPBX][REQ] POST %s Headers: {Content-Type: %s} Body: %s (attempt=%d)", url, req.Header.Get("Content-Type"), previewJSON(body, 2000), attempt)
-		}
-		resp, err := c.http.Do(req)
-		if err != nil {
-			if c.debug {
-				log.Printf("[MikoPBX][ERR] POST %s error: %v (retry in %s)", url, err, backoff)
-			}
-			time.Sleep(backoff + jitter())
-			backoff = nextBackoff(backoff)
-			attempt++
-			continue
+		if (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) ||
+			(p.MaxElapsed > 0 && time.Since(started)+backoff > p.MaxElapsed) {
+			return lastStatus, nil, &Error{Op: op, Kind: ErrUnreachable, StatusCode: lastStatus, Attempts: attempt, Err: lastErr}
 		}
-		rb, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
 		if c.debug {
-			log.Printf("[MikoPBX][RES] %s %s Body: %s", resp.Status, url, previewJSON(rb, 2000))
+			log.Printf("[MikoPBX][RETRY] %s retry in %s", op, backoff)
 		}
-		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-			_ = c.Authenticate()
-			time.Sleep(200*time.Millisecond + jitter()/2)
-			attempt++
-			continue
+		metrics.MikoPBXRetries.Inc(c.name, op)
+		if err := sleepCtx(ctx, backoff+jitter()); err != nil {
+			return 0, nil, err
 		}
-		if resp.StatusCode >= 500 {
-			if c.debug {
-				log.Printf("[MikoPBX][RETRY] %s returned %d, retry in %s", url, resp.StatusCode, backoff)
-			}
-			time.Sleep(backoff + jitter())
-			backoff = nextBackoff(backoff)
-			attempt++
-			continue
-		}
-		return resp.StatusCode, rb
+		backoff = nextBackoff(backoff, p.MaxBackoff)
+	}
+}
+
+// countError は失敗したAPI呼び出しを種別ごとに数え、err をそのまま返す
+func (c *Client) countError(op string, err error) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	kind := "bad_response"
+	switch {
+	case errors.Is(e, ErrUnreachable):
+		kind = "unreachable"
+	case errors.Is(e, ErrAuthRejected):
+		kind = "auth_rejected"
+	}
+	metrics.MikoPBXErrors.Inc(c.name, op, kind)
+	return err
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
 	}
 }
 
-func nextBackoff(cur time.Duration) time.Duration {
-	max := 60 * time.Second
+func nextBackoff(cur, max time.Duration) time.Duration {
 	n := cur * 2
 	if n > max {
 		n = max
@@ -0,0 +1,108 @@
+package mikopbx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CDRRecord は通話履歴（CDR）の1行。1通話が複数行（転送・グループ着信の各レッグ）になることがあり、linkedid でまとまる。
+type CDRRecord struct {
+	ID          FlexInt `json:"id"`
+	Start       string  `json:"start"`   // "2006-01-02 15:04:05.000"
+	Answer      string  `json:"answer"`  // 応答していなければ空
+	EndTime     string  `json:"endtime"` // 通話中なら空
+	SrcNum      string  `json:"src_num"`
+	DstNum      string  `json:"dst_num"`
+	DID         string  `json:"did"`
+	LinkedID    string  `json:"linkedid"`
+	Disposition string  `json:"disposition"` // ANSWERED / NO ANSWER / BUSY / FAILED
+	Duration    FlexInt `json:"duration"`    // 呼出開始から切断まで（秒）
+	BillSec     FlexInt `json:"billsec"`     // 通話時間（秒）
+}
+
+// CDR のDispositionの値
+const (
+	DispositionAnswered = "ANSWERED"
+	DispositionNoAnswer = "NO ANSWER"
+	DispositionBusy     = "BUSY"
+	DispositionFailed   = "FAILED"
+)
+
+// cdrTimeLayouts はCDRの日時の書式（ミリ秒の有無が版によって違う）
+var cdrTimeLayouts = []string{"2006-01-02 15:04:05.000", "2006-01-02 15:04:05", time.RFC3339}
+
+// StartTime は開始時刻を返す（PBXのローカル時刻として解釈する）
+func (r CDRRecord) StartTime() time.Time { return parseCDRTime(r.Start) }
+
+// EndedAt は終了時刻を返す（通話中ならゼロ値）
+func (r CDRRecord) EndedAt() time.Time { return parseCDRTime(r.EndTime) }
+
+func parseCDRTime(s string) time.Time {
+	for _, l := range cdrTimeLayouts {
+		if t, err := time.ParseInLocation(l, strings.TrimSpace(s), time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// GetCDR は since 以降に開始した通話履歴を新しい順に最大 limit 件返す
+func (c *Client) GetCDR(ctx context.Context, since time.Time, limit int) ([]CDRRecord, error) {
+	recs, err := c.getCDR(ctx, since, limit)
+	return recs, c.countError("getCDR", err)
+}
+
+func (c *Client) getCDR(ctx context.Context, since time.Time, limit int) ([]CDRRecord, error) {
+	q := url.Values{}
+	q.Set("dateFrom", since.In(time.Local).Format("2006-01-02 15:04:05"))
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("order", "DESC")
+	var out struct {
+		Result bool            `json:"result"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := c.getJSON(ctx, "getCDR", "/pbxcore/api/v3/cdr?"+q.Encode(), &out); err != nil {
+		return nil, err
+	}
+	// data は配列そのもの、または {"records": [...]}（ページング付き）のどちらか
+	raw := bytes.TrimSpace(out.Data)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	var recs []CDRRecord
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &recs); err != nil {
+			return nil, &Error{Op: "getCDR", Kind: ErrBadResponse, Err: err}
+		}
+		return recs, nil
+	}
+	var paged struct {
+		Records []CDRRecord `json:"records"`
+	}
+	if err := json.Unmarshal(raw, &paged); err != nil {
+		return nil, &Error{Op: "getCDR", Kind: ErrBadResponse, Err: err}
+	}
+	return paged.Records, nil
+}
+
+// FlexInt は数値・数値文字列・空文字のどれでも読める整数（PHP由来のAPIは数値を文字列で返すことがある）
+type FlexInt int
+
+func (n *FlexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = FlexInt(v)
+	return nil
+}
@@ -0,0 +1,45 @@
+package mikopbx
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ActiveChannel は進行中の通話1本（Asteriskのチャンネルの組）
+type ActiveChannel struct {
+	Start    string `json:"start"`  // "2006-01-02 15:04:05.000"
+	Answer   string `json:"answer"` // 応答前は空
+	SrcChan  string `json:"src_chan"`
+	DstChan  string `json:"dst_chan"`
+	SrcNum   string `json:"src_num"`
+	DstNum   string `json:"dst_num"`
+	DID      string `json:"did"`
+	LinkedID string `json:"linkedid"`
+	// State はチャンネルの状態（Up / Ringing など）。版によっては返らないので Answered() で補う。
+	State string `json:"state"`
+}
+
+// StartTime は呼出開始時刻を返す
+func (a ActiveChannel) StartTime() time.Time { return parseCDRTime(a.Start) }
+
+// AnsweredAt は応答時刻を返す（応答前はゼロ値）
+func (a ActiveChannel) AnsweredAt() time.Time { return parseCDRTime(a.Answer) }
+
+// Answered は応答済み（通話中）かを返す
+func (a ActiveChannel) Answered() bool {
+	if a.State != "" {
+		return strings.EqualFold(a.State, "Up")
+	}
+	return !a.AnsweredAt().IsZero()
+}
+
+// GetActiveChannels は進行中の通話の一覧を返す
+func (c *Client) GetActiveChannels(ctx context.Context) ([]ActiveChannel, error) {
+	var out struct {
+		Result bool            `json:"result"`
+		Data   []ActiveChannel `json:"data"`
+	}
+	err := c.getJSON(ctx, "getActiveChannels", "/pbxcore/api/cdr/getActiveChannels", &out)
+	return out.Data, c.countError("getActiveChannels", err)
+}
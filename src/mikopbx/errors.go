@@ -0,0 +1,43 @@
+package mikopbx
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 呼び出し側が errors.Is で見分けるためのエラー種別
+var (
+	ErrUnreachable  = errors.New("mikopbx unreachable")      // 接続失敗・5xx等でリトライ上限に達した
+	ErrAuthRejected = errors.New("mikopbx auth rejected")    // ログインが拒否された / 再ログインしても401・403
+	ErrBadResponse  = errors.New("mikopbx unexpected reply") // リトライ対象外のステータスや壊れたJSON
+)
+
+// Error はAPI呼び出しの失敗。Kind は上の種別のいずれか。
+type Error struct {
+	Op         string // 例: "getPeersStatuses"
+	Kind       error
+	StatusCode int // 最後に受け取ったHTTPステータス（接続失敗なら0）
+	Attempts   int
+	Err        error // 最後の下位エラー（あれば）
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" after %d attempts", e.Attempts)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
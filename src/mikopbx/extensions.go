@@ -0,0 +1,85 @@
+package mikopbx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Extension はPBXの内線番号1つ
+type Extension struct {
+	Number string
+	Name   string // 空のこともある
+	Type   string // SIP / QUEUE / IVR など
+}
+
+type selectItem struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+var (
+	htmlTag        = regexp.MustCompile(`<[^>]*>`)
+	trailingNumber = regexp.MustCompile(`\s*<[^<>]*>\s*$`)
+)
+
+// GetExtensions は内線の一覧（番号と表示名）を返す
+func (c *Client) GetExtensions(ctx context.Context) ([]Extension, error) {
+	exts, err := c.getExtensions(ctx)
+	return exts, c.countError("getExtensions", err)
+}
+
+func (c *Client) getExtensions(ctx context.Context) ([]Extension, error) {
+	var out struct {
+		Result bool            `json:"result"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := c.getJSON(ctx, "getExtensions", "/pbxcore/api/extensions/getForSelect?type=all", &out); err != nil {
+		return nil, err
+	}
+	// data は配列そのもの、または {"results": [...]}（セレクトボックス用の形式）のどちらか
+	raw := bytes.TrimSpace(out.Data)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	var items []selectItem
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, &Error{Op: "getExtensions", Kind: ErrBadResponse, Err: err}
+		}
+	} else {
+		var wrapped struct {
+			Results []selectItem `json:"results"`
+		}
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			return nil, &Error{Op: "getExtensions", Kind: ErrBadResponse, Err: err}
+		}
+		items = wrapped.Results
+	}
+
+	exts := make([]Extension, 0, len(items))
+	for _, it := range items {
+		if it.Value == "" {
+			continue
+		}
+		exts = append(exts, Extension{Number: it.Value, Name: selectName(it.Name, it.Value), Type: it.Type})
+	}
+	return exts, nil
+}
+
+// selectName は `<i class="icon"></i> 総務 <201>` のような表示用の名前から名前だけを取り出す
+func selectName(s, number string) string {
+	s = strings.TrimSpace(s)
+	// 末尾の「<201>」はエスケープされていることもある
+	s = html.UnescapeString(s)
+	s = trailingNumber.ReplaceAllString(s, "")
+	s = strings.TrimSpace(htmlTag.ReplaceAllString(s, ""))
+	if s == number {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email は SMTP でメールを送る
+type Email struct {
+	Host     string
+	Port     int
+	Username string // 空なら認証しない
+	Password string
+	From     string
+	To       []string
+	TLS      string // starttls（既定）| tls（最初からTLS、465番など）| none
+}
+
+func (e *Email) Name() string { return "email" }
+
+func (e *Email) Send(ctx context.Context, m Message) error {
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if e.TLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: e.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if e.TLS == "" || e.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS (set tls: none to send in plain text)", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err := c.Mail(envelope(e.From)); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(envelope(to)); err != nil {
+			return fmt.Errorf("rcpt %s: %w", to, err)
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(e.compose(m)); err != nil {
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// envelope は "名前 <addr>" からアドレスだけを取り出す
+func envelope(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return addr
+}
+
+// compose はヘッダと本文（UTF-8、base64）を組み立てる
+func (e *Email) compose(m Message) []byte {
+	subject := m.Title
+	if m.Severity == Critical || m.Severity == Warning {
+		subject = "[" + strings.ToUpper(m.Severity.String()) + "] " + subject
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", m.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	body := base64.StdEncoding.EncodeToString([]byte(strings.ReplaceAll(m.Text(), "\n", "\r\n")))
+	for len(body) > 76 {
+		b.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	b.WriteString(body + "\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var httpClient = &http.Client{}
+
+// postJSON は body をJSONで POST し、2xx 以外はエラーにする
+func postJSON(ctx context.Context, url string, body any, header http.Header) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return post(ctx, url, raw, header)
+}
+
+func post(ctx context.Context, url string, raw []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Slack は Slack の Incoming Webhook へ attachment で送る
+type Slack struct {
+	WebhookURL string
+}
+
+func (s *Slack) Name() string { return "slack" }
+
+var slackColors = map[Severity]string{
+	Info:     "#95A5A6",
+	Resolved: "#2ECC71",
+	Warning:  "#E67E22",
+	Critical: "#E74C3C",
+}
+
+func (s *Slack) Send(ctx context.Context, m Message) error {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	att := map[string]any{
+		"color":    slackColors[m.Severity],
+		"title":    m.Title,
+		"fallback": m.Title,
+		"ts":       m.Time.Unix(),
+	}
+	if m.Body != "" {
+		att["text"] = m.Body
+	}
+	if m.Footer != "" || m.Source != "" {
+		att["footer"] = strings.TrimSpace(m.Source + " " + m.Footer)
+	}
+	var fields []field
+	for _, f := range m.Fields {
+		fields = append(fields, field{Title: f.Name, Value: f.Value, Short: f.Inline})
+	}
+	if len(fields) > 0 {
+		att["fields"] = fields
+	}
+	text := m.Summary
+	if text == "" {
+		text = m.Title
+	}
+	return postJSON(ctx, s.WebhookURL, map[string]any{"text": text, "attachments": []any{att}}, nil)
+}
+
+// Webhook は Message をそのままJSONで POST する。Secret があれば
+// HMAC-SHA256(Secret, "<timestamp>.<body>") を X-Odenwakun-Signature に "sha256=<hex>" で付ける。
+type Webhook struct {
+	URL    string
+	Secret string
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Send(ctx context.Context, m Message) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	h := http.Header{}
+	if w.Secret != "" {
+		ts := strconv.FormatInt(m.Time.Unix(), 10)
+		h.Set("X-Odenwakun-Timestamp", ts)
+		h.Set("X-Odenwakun-Signature", "sha256="+Sign(w.Secret, ts, raw))
+	}
+	return post(ctx, w.URL, raw, h)
+}
+
+// Sign は受け手が署名を確かめるための HMAC-SHA256（16進）
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Ntfy は ntfy サーバーの topic へJSONで publish する
+type Ntfy struct {
+	URL   string // サーバー（例: https://ntfy.sh）
+	Topic string
+	Token string // アクセストークン（空なら匿名）
+}
+
+func (n *Ntfy) Name() string { return "ntfy" }
+
+var ntfyTags = map[Severity][]string{
+	Info:     {"information_source"},
+	Resolved: {"white_check_mark"},
+	Warning:  {"warning"},
+	Critical: {"rotating_light"},
+}
+
+func (n *Ntfy) Send(ctx context.Context, m Message) error {
+	priority := map[Severity]int{Info: 2, Resolved: 3, Warning: 4, Critical: 5}[m.Severity]
+	var h http.Header
+	if n.Token != "" {
+		h = http.Header{"Authorization": {"Bearer " + n.Token}}
+	}
+	return postJSON(ctx, strings.TrimRight(n.URL, "/"), map[string]any{
+		"topic":    n.Topic,
+		"title":    m.Title,
+		"message":  m.Text(),
+		"priority": priority,
+		"tags":     ntfyTags[m.Severity],
+	}, h)
+}
+
+// Gotify は Gotify サーバーへアプリのトークンで送る
+type Gotify struct {
+	URL   string // サーバー（例: https://gotify.example.com）
+	Token string // アプリのトークン
+}
+
+func (g *Gotify) Name() string { return "gotify" }
+
+func (g *Gotify) Send(ctx context.Context, m Message) error {
+	priority := map[Severity]int{Info: 2, Resolved: 4, Warning: 6, Critical: 8}[m.Severity]
+	return postJSON(ctx, strings.TrimRight(g.URL, "/")+"/message", map[string]any{
+		"title":    m.Title,
+		"message":  m.Text(),
+		"priority": priority,
+	}, http.Header{"X-Gotify-Key": {g.Token}})
+}
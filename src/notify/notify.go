@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity は通知の重大度
+type Severity int
+
+const (
+	Info     Severity = iota // 情報（一覧・まとめなど）
+	Resolved                 // 復旧
+	Warning                  // 品質低下・一部の悪化
+	Critical                 // ダウン・到達不能
+)
+
+var severityNames = [...]string{"info", "resolved", "warning", "critical"}
+
+func (s Severity) String() string {
+	if s < Info || s > Critical {
+		return "info"
+	}
+	return severityNames[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *Severity) UnmarshalText(b []byte) error {
+	v, err := ParseSeverity(string(b))
+	*s = v
+	return err
+}
+
+// ParseSeverity は "info" / "resolved" / "warning" / "critical" を読む
+func ParseSeverity(s string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(strings.TrimSpace(s), n) {
+			return Severity(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown severity %q", s)
+}
+
+// rank は送るかどうかの比較に使う順位（復旧は悪化を送るなら必ず送るよう warning と同じ）
+func (s Severity) rank() Severity {
+	if s == Resolved {
+		return Warning
+	}
+	return s
+}
+
+// Field は名前と値の組
+type Field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// Message は送り先に依存しない通知
+type Message struct {
+	Title    string    `json:"title"`
+	Summary  string    `json:"summary,omitempty"` // 一言（Discordのメッセージ本文）
+	Body     string    `json:"body,omitempty"`
+	Severity Severity  `json:"severity"`
+	Fields   []Field   `json:"fields,omitempty"`
+	Footer   string    `json:"footer,omitempty"`
+	Source   string    `json:"source,omitempty"` // サイト名
+	Time     time.Time `json:"time"`
+}
+
+// Text はメッセージをプレーンテキストにする（メール・プッシュ通知の本文）
+func (m Message) Text() string {
+	var b strings.Builder
+	if m.Summary != "" {
+		b.WriteString(m.Summary + "\n\n")
+	}
+	if m.Body != "" {
+		b.WriteString(m.Body + "\n")
+	}
+	for _, f := range m.Fields {
+		fmt.Fprintf(&b, "\n%s: %s", f.Name, f.Value)
+	}
+	if m.Footer != "" {
+		b.WriteString("\n\n" + m.Footer)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Sender は1つの送り先
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, m Message) error
+}
+
+// Fanout は同じメッセージを全ての送り先へ送る。
+// 送り先ごとに順番を保つキューを持ち、遅い・落ちている送り先が監視や他の送り先を待たせない。
+type Fanout struct {
+	Min     Severity      // これより軽いものは送らない
+	Timeout time.Duration // 1回の送信の期限
+
+	queues []*queue
+}
+
+type queue struct {
+	s  Sender
+	ch chan Message
+}
+
+const queueSize = 100
+
+func NewFanout(senders ...Sender) *Fanout {
+	f := &Fanout{Timeout: 15 * time.Second}
+	for _, s := range senders {
+		q := &queue{s: s, ch: make(chan Message, queueSize)}
+		f.queues = append(f.queues, q)
+		go f.run(q)
+	}
+	return f
+}
+
+// Senders は送り先の名前を返す
+func (f *Fanout) Senders() []string {
+	var out []string
+	for _, q := range f.queues {
+		out = append(out, q.s.Name())
+	}
+	return out
+}
+
+// Publish は m を全ての送り先のキューへ入れる（待たない。キューが溢れたら捨てる）
+func (f *Fanout) Publish(m Message) {
+	if m.Severity.rank() < f.Min.rank() {
+		return
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	for _, q := range f.queues {
+		select {
+		case q.ch <- m:
+		default:
+			log.Printf("[notify] %s: queue full, dropped %q", q.s.Name(), m.Title)
+		}
+	}
+}
+
+func (f *Fanout) run(q *queue) {
+	for m := range q.ch {
+		ctx, cancel := context.WithTimeout(context.Background(), f.Timeout)
+		if err := q.s.Send(ctx, m); err != nil {
+			log.Printf("[notify] %s: %v", q.s.Name(), err)
+		}
+		cancel()
+	}
+}
+
+// Result は1つの送り先への送信結果
+type Result struct {
+	Sender string
+	Err    error
+}
+
+// SendAll は m を全ての送り先へ今すぐ送り、結果を待って返す（重大度は問わない。接続確認用）
+func (f *Fanout) SendAll(ctx context.Context, m Message) []Result {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	out := make([]Result, len(f.queues))
+	var wg sync.WaitGroup
+	for i, q := range f.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+			defer cancel()
+			out[i] = Result{Sender: q.s.Name(), Err: q.s.Send(ctx, m)}
+		}()
+	}
+	wg.Wait()
+	return out
+}
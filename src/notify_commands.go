@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/notify"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// notifyTestCommand は Discord 以外の送り先全てへ試しに送り、届いたかを返す管理者向けコマンド
+func notifyTestCommand(backends *notify.Fanout) *commands.Command {
+	return &commands.Command{
+		Name:        "notify-test",
+		Description: "Discord 以外の通知先（Slack・Webhook・ntfy・Gotify・メール）へ試しに送る",
+		AdminOnly:   true,
+		Handler: func(c *commands.Context) error {
+			if backends == nil {
+				return commands.Errorf("Discord 以外の通知先がありません（NOTIFY_* を設定してください）")
+			}
+			if err := c.Defer(true); err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			msg := notify.Message{
+				Title:    "🔔 通知のテスト",
+				Body:     fmt.Sprintf("%s が /notify-test で送りました。", c.User().Username),
+				Severity: notify.Info,
+				Time:     time.Now(),
+			}
+			var lines []string
+			color := 0x2ECC71 // green
+			for _, r := range backends.SendAll(ctx, msg) {
+				if r.Err != nil {
+					lines = append(lines, fmt.Sprintf("❌ %s: %s", r.Sender, truncateRunes(r.Err.Error(), 300)))
+					color = 0xE74C3C // red
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("✅ %s", r.Sender))
+			}
+			return c.EditEmbed("", &discordgo.MessageEmbed{
+				Title:       "🔔 通知のテスト",
+				Description: strings.Join(lines, "\n"),
+				Color:       color,
+				Footer:      &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("min_severity: %s（テストは重大度を問わず送る）", backends.Min)},
+			})
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package oncall
+
+import (
+	"time"
+)
+
+// Shift は1人が当番を務める期間
+type Shift struct {
+	Member   Member
+	Start    time.Time
+	End      time.Time
+	Override *Override // 差し替えによる当番なら元の差し替え
+}
+
+// Current は now の当番を返す。差し替えがあればそれを優先する（重なれば後から入れたもの）。
+func (s *Schedule) Current(now time.Time) (Shift, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.d.Overrides) - 1; i >= 0; i-- {
+		o := s.d.Overrides[i]
+		if now.Before(o.Start) || !now.Before(o.End) {
+			continue
+		}
+		if m, ok := s.member(o.UserID); ok {
+			return Shift{Member: m, Start: o.Start, End: o.End, Override: &o}, true
+		}
+	}
+	return s.rotationShift(s.shiftStart(now))
+}
+
+// Rotation は now を含む週から n 週分のローテーション上の当番を返す（差し替えは含めない）
+func (s *Schedule) Rotation(now time.Time, n int) []Shift {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Shift
+	start := s.shiftStart(now)
+	for i := 0; i < n; i++ {
+		sh, ok := s.rotationShift(start)
+		if !ok {
+			break
+		}
+		out = append(out, sh)
+		start = sh.End
+	}
+	return out
+}
+
+func (s *Schedule) rotationShift(start time.Time) (Shift, bool) {
+	n := len(s.d.Members)
+	if n == 0 {
+		return Shift{}, false
+	}
+	i := s.weekIndex(start) % n
+	if i < 0 {
+		i += n
+	}
+	// 1週間後の同じ現地時刻（夏時間の切り替えがあっても交代時刻はずれない）
+	end := start.AddDate(0, 0, 7)
+	return Shift{Member: s.d.Members[i], Start: start, End: end}, true
+}
+
+// rotationIndex は now のローテーション上の当番の順番（メンバーがいなければ -1）
+func (s *Schedule) rotationIndex(now time.Time) int {
+	n := len(s.d.Members)
+	if n == 0 || s.d.Epoch == "" {
+		return -1
+	}
+	i := s.weekIndex(s.shiftStart(now)) % n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+// anchor は now を含む週の当番が i 番目のメンバーになるよう起点をずらす
+func (s *Schedule) anchor(now time.Time, i int) {
+	s.d.Epoch = s.shiftStart(now).AddDate(0, 0, -7*i).Format(time.DateOnly)
+}
+
+// weekIndex は起点の週から start の週まで何週あるか
+func (s *Schedule) weekIndex(start time.Time) int {
+	epoch, err := time.ParseInLocation(time.DateOnly, s.d.Epoch, s.loc)
+	if err != nil {
+		return 0
+	}
+	days := civilDays(epoch, start.In(s.loc))
+	if days < 0 {
+		return (days - 6) / 7 // 切り捨て
+	}
+	return days / 7
+}
+
+// shiftStart は now 以前で直近の交代時刻（現地時刻の曜日・時刻）
+func (s *Schedule) shiftStart(now time.Time) time.Time {
+	h, _ := s.handoff()
+	hh, mm, _ := ParseClock(h.Time)
+	t := now.In(s.loc)
+	c := time.Date(t.Year(), t.Month(), t.Day(), hh, mm, 0, 0, s.loc)
+	for c.Weekday() != h.Day || c.After(t) {
+		c = time.Date(c.Year(), c.Month(), c.Day()-1, hh, mm, 0, 0, s.loc)
+	}
+	return c
+}
+
+// civilDays は a の日付から b の日付までの日数（時刻・夏時間は無視）
+func civilDays(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da) / (24 * time.Hour))
+}
@@ -0,0 +1,298 @@
+package oncall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not in on-call schedule")
+	ErrExists   = errors.New("already in on-call schedule")
+)
+
+// Member は当番を回す1人
+type Member struct {
+	UserID string `json:"user_id"` // DiscordのユーザーID
+	Name   string `json:"name"`
+	Number string `json:"number"` // 電話する番号
+}
+
+// Label は「名前(番号)」を返す
+func (m Member) Label() string {
+	return fmt.Sprintf("%s(%s)", m.Name, m.Number)
+}
+
+// Override は期間を決めた当番の差し替え（休みの代わりなど）
+type Override struct {
+	ID     int       `json:"id"`
+	UserID string    `json:"user_id"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Handoff は毎週の交代の曜日と時刻（Timezone の現地時刻）
+type Handoff struct {
+	Day      time.Weekday
+	Time     string // "09:00"
+	Timezone string // IANA名（例: Asia/Tokyo）
+}
+
+// String は「月曜 09:00 (Asia/Tokyo)」を返す
+func (h Handoff) String() string {
+	return fmt.Sprintf("%s曜 %s (%s)", weekdayJA[h.Day], h.Time, h.Timezone)
+}
+
+var weekdayJA = [...]string{"日", "月", "火", "水", "木", "金", "土"}
+
+// ParseWeekday は "mon" / "monday" / "月" などを曜日にする
+func ParseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] || s == weekdayJA[d] || s == weekdayJA[d]+"曜" || s == weekdayJA[d]+"曜日" {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+// ParseClock は "HH:MM" を時・分にする
+func ParseClock(s string) (int, int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, 0, fmt.Errorf("time %q is not HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// Schedule は週替わりのローテーションと差し替えからなる当番表。
+// path のJSONファイルに保存する（空ならメモリのみ）。
+type Schedule struct {
+	path string
+
+	mu  sync.RWMutex
+	d   file
+	loc *time.Location
+}
+
+type file struct {
+	Timezone    string     `json:"timezone"`
+	HandoffDay  string     `json:"handoff_day"`  // "monday"
+	HandoffTime string     `json:"handoff_time"` // "09:00"
+	Epoch       string     `json:"epoch"`        // 1人目の当番週が始まる日（交代日、YYYY-MM-DD）
+	Members     []Member   `json:"members"`      // 当番の順
+	Overrides   []Override `json:"overrides"`
+	NextID      int        `json:"next_id"`
+}
+
+// New は path から当番表を読む。交代の曜日・時刻が保存されていなければ def を使う。
+func New(path string, def Handoff) (*Schedule, error) {
+	s := &Schedule{path: path, d: file{NextID: 1}}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(raw, &s.d); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", path, err)
+			}
+		}
+	}
+	if s.d.Timezone == "" {
+		s.d.Timezone, s.d.HandoffDay, s.d.HandoffTime = def.Timezone, strings.ToLower(def.Day.String()), def.Time
+	}
+	loc, err := time.LoadLocation(s.d.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	s.loc = loc
+	if _, err := s.handoff(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Handoff は交代の曜日・時刻を返す
+func (s *Schedule) Handoff() Handoff {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, _ := s.handoff()
+	return h
+}
+
+func (s *Schedule) handoff() (Handoff, error) {
+	day, err := ParseWeekday(s.d.HandoffDay)
+	if err != nil {
+		return Handoff{}, err
+	}
+	if _, _, err := ParseClock(s.d.HandoffTime); err != nil {
+		return Handoff{}, err
+	}
+	return Handoff{Day: day, Time: s.d.HandoffTime, Timezone: s.d.Timezone}, nil
+}
+
+// Location は当番表のタイムゾーン
+func (s *Schedule) Location() *time.Location {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loc
+}
+
+// Members は当番の順に並んだメンバーを返す
+func (s *Schedule) Members() []Member {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.d.Members)
+}
+
+// Member は UserID のメンバーを返す
+func (s *Schedule) Member(userID string) (Member, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.member(userID)
+}
+
+func (s *Schedule) member(userID string) (Member, bool) {
+	i := slices.IndexFunc(s.d.Members, func(m Member) bool { return m.UserID == userID })
+	if i < 0 {
+		return Member{}, false
+	}
+	return s.d.Members[i], true
+}
+
+// AddMember は m を当番の最後に加える。今の当番は変えない。
+func (s *Schedule) AddMember(m Member, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.member(m.UserID); ok {
+		return fmt.Errorf("%w: %s", ErrExists, m.Name)
+	}
+	cur := s.rotationIndex(now)
+	s.d.Members = append(s.d.Members, m)
+	s.anchor(now, max(cur, 0))
+	return s.save()
+}
+
+// RemoveMember は当番から外す。外した人が今の当番なら次の人が引き継ぐ。
+func (s *Schedule) RemoveMember(userID string, now time.Time) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.d.Members, func(m Member) bool { return m.UserID == userID })
+	if i < 0 {
+		return Member{}, ErrNotFound
+	}
+	m := s.d.Members[i]
+	cur := s.rotationIndex(now)
+	s.d.Members = slices.Delete(s.d.Members, i, i+1)
+	if i < cur {
+		cur--
+	}
+	if n := len(s.d.Members); n > 0 {
+		s.anchor(now, cur%n)
+	}
+	// 外した人の差し替えも消す
+	s.d.Overrides = slices.DeleteFunc(s.d.Overrides, func(o Override) bool { return o.UserID == userID })
+	return m, s.save()
+}
+
+// SetHandoff は交代の曜日・時刻・タイムゾーンを変える。今の当番は変えない。
+func (s *Schedule) SetHandoff(h Handoff, now time.Time) error {
+	if _, _, err := ParseClock(h.Time); err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(h.Timezone)
+	if err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.rotationIndex(now)
+	s.d.Timezone, s.d.HandoffDay, s.d.HandoffTime = h.Timezone, strings.ToLower(h.Day.String()), h.Time
+	s.loc = loc
+	if cur >= 0 {
+		s.anchor(now, cur)
+	}
+	return s.save()
+}
+
+// AddOverride は o.UserID を o.Start〜o.End の当番にする（メンバーであること）
+func (s *Schedule) AddOverride(o Override) (Override, error) {
+	if !o.End.After(o.Start) {
+		return Override{}, errors.New("override ends before it starts")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.member(o.UserID); !ok {
+		return Override{}, ErrNotFound
+	}
+	o.ID = s.d.NextID
+	s.d.NextID++
+	s.d.Overrides = append(s.d.Overrides, o)
+	return o, s.save()
+}
+
+// RemoveOverride は差し替えを取り消す
+func (s *Schedule) RemoveOverride(id int) (Override, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.d.Overrides, func(o Override) bool { return o.ID == id })
+	if i < 0 {
+		return Override{}, ErrNotFound
+	}
+	o := s.d.Overrides[i]
+	s.d.Overrides = slices.Delete(s.d.Overrides, i, i+1)
+	return o, s.save()
+}
+
+// Overrides は終わっていない差し替えを開始順に返す（終わったものはここで片付ける）
+func (s *Schedule) Overrides(now time.Time) []Override {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.d.Overrides)
+	s.d.Overrides = slices.DeleteFunc(s.d.Overrides, func(o Override) bool { return !o.End.After(now) })
+	if len(s.d.Overrides) != n {
+		_ = s.save()
+	}
+	out := slices.Clone(s.d.Overrides)
+	slices.SortFunc(out, func(a, b Override) int { return a.Start.Compare(b.Start) })
+	return out
+}
+
+func (s *Schedule) save() error {
+	if s.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(s.d, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 書き込み途中で落ちても壊れないよう一時ファイル経由で置き換える
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/audit"
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/oncall"
+	"tacnet-odenwakun/src/sipclient"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// oncallCommands はオンコール当番表の確認・編集と、今の当番への発信のコマンド
+func oncallCommands(sched *oncall.Schedule, oki *sipclient.OkiSIP, al *audit.Log) []*commands.Command {
+	var dayChoices []*discordgo.ApplicationCommandOptionChoice
+	for d := time.Monday; d <= time.Saturday+1; d++ {
+		wd := d % 7
+		dayChoices = append(dayChoices, &discordgo.ApplicationCommandOptionChoice{Name: wd.String(), Value: strings.ToLower(wd.String())})
+	}
+	minHour, maxHours := 1.0, 24.0*30
+	completeOverride := func(c *commands.Context, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+		loc := sched.Location()
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, o := range sched.Overrides(time.Now()) {
+			name := fmt.Sprintf("#%d %s〜%s", o.ID, o.Start.In(loc).Format("01/02 15:04"), o.End.In(loc).Format("01/02 15:04"))
+			if m, ok := sched.Member(o.UserID); ok {
+				name += " " + m.Name
+			}
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncateRunes(name, 100), Value: strconv.Itoa(o.ID)})
+		}
+		return choices
+	}
+
+	return []*commands.Command{
+		{
+			Name:        "oncall",
+			Description: "オンコール当番",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "now",
+					Description: "今の当番と今後の予定を見る",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "call",
+					Description: "今の当番に電話する",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "add",
+					Description: "当番のローテーションの最後に加える",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "当番になる人", Required: true},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "number", Description: "電話番号", Required: true, MaxLength: 32},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "表示名（既定はDiscordの名前）", MaxLength: 50},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "ローテーションから外す（今の当番なら次の人が引き継ぐ）",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "外す人", Required: true},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "handoff",
+					Description: "毎週の交代の曜日・時刻を変える",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "day", Description: "曜日", Required: true, Choices: dayChoices},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "time", Description: "時刻（HH:MM）", Required: true, MaxLength: 5},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "timezone", Description: "タイムゾーン（例: Asia/Tokyo。既定は今の設定）", MaxLength: 64},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "override",
+					Description: "期間を決めて当番を差し替える",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "代わりに当番になる人（ローテーションのメンバー）", Required: true},
+						{Type: discordgo.ApplicationCommandOptionInteger, Name: "hours", Description: "時間", Required: true, MinValue: &minHour, MaxValue: maxHours},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "開始（YYYY-MM-DD HH:MM、当番表のタイムゾーン。既定は今）", MaxLength: 16},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "reason", Description: "理由", MaxLength: 100},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "override-cancel",
+					Description: "差し替えを取り消す",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "差し替え", Required: true, Autocomplete: true},
+					},
+				},
+			},
+			Autocomplete: map[string]commands.AutocompleteHandler{"id": completeOverride},
+			Handler: func(c *commands.Context) error {
+				now := time.Now()
+				switch c.Subcommand {
+				case "now":
+					return c.ReplyEmbed("", oncallEmbed(sched, now), false)
+				case "call":
+					sh, ok := sched.Current(now)
+					if !ok {
+						return commands.Errorf("当番が決まっていません（/oncall add で加えてください）")
+					}
+					return dial(c, oki, al, sh.Member.Number, "当番 "+sh.Member.Label(), "")
+				case "add":
+					number := strings.TrimSpace(c.String("number"))
+					if !sipclient.Dialable(number) {
+						return commands.Errorf("電話番号は数字（と * # +）で指定してください: %s", number)
+					}
+					u := c.ResolvedUser("user")
+					m := oncall.Member{UserID: u.ID, Name: strings.TrimSpace(c.String("name")), Number: number}
+					if m.Name == "" {
+						m.Name = u.Username
+						if u.GlobalName != "" {
+							m.Name = u.GlobalName
+						}
+					}
+					if err := sched.AddMember(m, now); err != nil {
+						if errors.Is(err, oncall.ErrExists) {
+							return commands.Errorf("すでに当番のメンバーです: %s", m.Name)
+						}
+						return err
+					}
+					return c.Reply(fmt.Sprintf("📟 当番に加えました: <@%s> %s（%d 人で交代）", m.UserID, m.Label(), len(sched.Members())))
+				case "remove":
+					m, err := sched.RemoveMember(c.UserID("user"), now)
+					if errors.Is(err, oncall.ErrNotFound) {
+						return commands.Errorf("当番のメンバーではありません")
+					}
+					if err != nil {
+						return err
+					}
+					return c.Reply(fmt.Sprintf("🗑️ 当番から外しました: %s", m.Label()))
+				case "handoff":
+					day, err := oncall.ParseWeekday(c.String("day"))
+					if err != nil {
+						return commands.Errorf("曜日が分かりません: %s", c.String("day"))
+					}
+					h := oncall.Handoff{Day: day, Time: strings.TrimSpace(c.String("time")), Timezone: strings.TrimSpace(c.String("timezone"))}
+					if h.Timezone == "" {
+						h.Timezone = sched.Handoff().Timezone
+					}
+					if err := sched.SetHandoff(h, now); err != nil {
+						return commands.Errorf("交代の設定が正しくありません: %v", err)
+					}
+					return c.Reply(fmt.Sprintf("🔁 交代を %s にしました（今の当番はそのまま）", sched.Handoff()))
+				case "override":
+					loc := sched.Location()
+					start := now
+					if from := strings.TrimSpace(c.String("from")); from != "" {
+						t, err := time.ParseInLocation("2006-01-02 15:04", from, loc)
+						if err != nil {
+							return commands.Errorf("開始は YYYY-MM-DD HH:MM で指定してください: %s", from)
+						}
+						start = t
+					}
+					o, err := sched.AddOverride(oncall.Override{
+						UserID: c.UserID("user"),
+						Start:  start,
+						End:    start.Add(time.Duration(c.Int("hours")) * time.Hour),
+						Reason: strings.TrimSpace(c.String("reason")),
+					})
+					if errors.Is(err, oncall.ErrNotFound) {
+						return commands.Errorf("差し替えられるのは当番のメンバーだけです（/oncall add で加えてください）")
+					}
+					if err != nil {
+						return err
+					}
+					return c.Reply(fmt.Sprintf("🔀 差し替え #%d: <@%s> %s〜%s", o.ID, o.UserID,
+						o.Start.In(loc).Format("01/02 15:04"), o.End.In(loc).Format("01/02 15:04 MST")))
+				case "override-cancel":
+					id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(c.String("id")), "#"))
+					if err != nil {
+						return commands.Errorf("差し替えを候補から選んでください")
+					}
+					if _, err := sched.RemoveOverride(id); err != nil {
+						if errors.Is(err, oncall.ErrNotFound) {
+							return commands.Errorf("その差し替えは見つかりません: #%d", id)
+						}
+						return err
+					}
+					return c.Reply(fmt.Sprintf("↩️ 差し替え #%d を取り消しました", id))
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// oncallEmbed は今の当番・今後のローテーション・差し替えを表す
+func oncallEmbed(sched *oncall.Schedule, now time.Time) *discordgo.MessageEmbed {
+	loc := sched.Location()
+	embed := &discordgo.MessageEmbed{
+		Title: "📟 オンコール当番",
+		Color: 0x9B59B6, // purple
+	}
+	sh, ok := sched.Current(now)
+	if !ok {
+		embed.Description = "当番が決まっていません（/oncall add で加えてください）"
+		return embed
+	}
+	embed.Description = fmt.Sprintf("今の当番: <@%s> %s\n%s まで", sh.Member.UserID, sh.Member.Label(), sh.End.In(loc).Format("01/02(Mon) 15:04 MST"))
+	if sh.Override != nil {
+		embed.Description += fmt.Sprintf("（差し替え #%d", sh.Override.ID)
+		if sh.Override.Reason != "" {
+			embed.Description += ": " + sh.Override.Reason
+		}
+		embed.Description += "）"
+	}
+	var rot []string
+	for _, r := range sched.Rotation(now, 4) {
+		rot = append(rot, fmt.Sprintf("%s〜 <@%s> %s", r.Start.In(loc).Format("01/02(Mon) 15:04"), r.Member.UserID, r.Member.Name))
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:  fmt.Sprintf("ローテーション（交代 %s）", sched.Handoff()),
+		Value: truncateRunes(strings.Join(rot, "\n"), 1024),
+	})
+	if overrides := sched.Overrides(now); len(overrides) > 0 {
+		var lines []string
+		for _, o := range overrides {
+			line := fmt.Sprintf("#%d %s〜%s <@%s>", o.ID, o.Start.In(loc).Format("01/02 15:04"), o.End.In(loc).Format("01/02 15:04"), o.UserID)
+			if o.Reason != "" {
+				line += " " + o.Reason
+			}
+			lines = append(lines, line)
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "差し替え", Value: truncateRunes(strings.Join(lines, "\n"), 1024)})
+	}
+	return embed
+}
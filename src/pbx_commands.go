@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/watcher"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// pbxCommands はMikoPBXに問い合わせるコマンド
+func pbxCommands(watchers []*watcher.Watcher) []*commands.Command {
+	calls := &commands.Command{
+		Name:        "calls",
+		Description: "PBXで通話中の電話を一覧する",
+		Handler: func(c *commands.Context) error {
+			targets := watchers
+			if site := c.String("site"); site != "" {
+				targets = nil
+				for _, w := range watchers {
+					if w.Site == site {
+						targets = append(targets, w)
+					}
+				}
+				if len(targets) == 0 {
+					return commands.Errorf("サイトが見つかりません: %s", site)
+				}
+			}
+			if len(targets) > 10 {
+				targets = targets[:10] // 1メッセージのEmbed上限
+			}
+			// PBXの応答待ちで3秒の応答期限を過ぎないよう先に受け付ける
+			if err := c.Defer(false); err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			var embeds []*discordgo.MessageEmbed
+			for _, w := range targets {
+				chans, err := w.Client.GetActiveChannels(ctx)
+				embed := watcher.ActiveCallsEmbed(w.Site, chans, time.Now())
+				if err != nil {
+					embed.Description = "⚠️ 通話一覧を取得できません: " + err.Error()
+				}
+				embeds = append(embeds, embed)
+			}
+			return c.EditEmbeds("", embeds)
+		},
+	}
+	if len(watchers) > 1 {
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, w := range watchers {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: w.Site, Value: w.Site})
+		}
+		if len(choices) > 25 {
+			choices = choices[:25]
+		}
+		calls.Options = []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "site",
+				Description: "サイト（省略時は全て）",
+				Choices:     choices,
+			},
+		}
+	}
+	return []*commands.Command{calls}
+}
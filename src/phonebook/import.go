@@ -0,0 +1,98 @@
+package phonebook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"tacnet-odenwakun/src/mikopbx"
+)
+
+// PBX は内線を取り込むMikoPBX 1台
+type PBX struct {
+	Site   string
+	Client *mikopbx.Client
+}
+
+// Import はPBXの内線一覧と各内線の EndpointName（getSipPeer）から内線の電話帳を作る。
+// 名前は EndpointName を優先し、取れなければ内線一覧の表示名を使う。
+func Import(ctx context.Context, cli *mikopbx.Client) ([]Entry, error) {
+	exts, extErr := cli.GetExtensions(ctx)
+	peers, peerErr := cli.GetPeersStatuses(ctx)
+	if extErr != nil && peerErr != nil {
+		return nil, errors.Join(extErr, peerErr)
+	}
+
+	names := map[string]string{}
+	var numbers []string
+	add := func(num, name string) {
+		if _, ok := names[num]; !ok {
+			numbers = append(numbers, num)
+		}
+		if name != "" || names[num] == "" {
+			names[num] = name
+		}
+	}
+	for _, e := range exts {
+		add(e.Number, e.Name)
+	}
+	for _, p := range peers.Data {
+		add(p.ID, "")
+	}
+
+	out := make([]Entry, 0, len(numbers))
+	for _, num := range numbers {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		name := names[num]
+		// SIP内線以外（キュー・IVRなど）は見つからないだけなので失敗扱いにしない
+		if n, err := cli.GetPeerName(ctx, num); err == nil && n != "" {
+			name = n
+		}
+		if name == "" {
+			continue // 名前の無い内線は番号で /oki すればよい
+		}
+		out = append(out, Entry{Name: name, Number: num})
+	}
+	return out, nil
+}
+
+// Sync はPBXごとに内線を取り込み直す。失敗したサイトは前回の内容を残す。
+func (b *Book) Sync(ctx context.Context, pbxs []PBX) error {
+	var errs []error
+	for _, p := range pbxs {
+		entries, err := Import(ctx, p.Client)
+		if err != nil {
+			errs = append(errs, siteErr(p.Site, err))
+			continue
+		}
+		b.SetPBX(p.Site, entries)
+	}
+	return errors.Join(errs...)
+}
+
+// Run は interval ごとに内線を取り込み直す
+func (b *Book) Run(ctx context.Context, pbxs []PBX, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Sync(ctx, pbxs); err != nil && ctx.Err() == nil {
+			log.Printf("phonebook sync error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func siteErr(site string, err error) error {
+	if site == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", site, err)
+}
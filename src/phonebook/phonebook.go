@@ -0,0 +1,268 @@
+package phonebook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var (
+	ErrNotFound = errors.New("not found in phonebook")
+	ErrExists   = errors.New("already in phonebook")
+)
+
+// Source は電話帳の項目の出どころ
+type Source string
+
+const (
+	SourcePBX     Source = "pbx"     // MikoPBXから取り込んだ内線
+	SourceContact Source = "contact" // 利用者が登録した外線
+)
+
+// Entry は電話帳の1件
+type Entry struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+	Source Source `json:"-"`
+	Site   string `json:"-"` // 取り込み元のサイト（外線は空）
+}
+
+// Label は「名前(番号)」を返す
+func (e Entry) Label() string {
+	if e.Name == "" {
+		return e.Number
+	}
+	return fmt.Sprintf("%s(%s)", e.Name, e.Number)
+}
+
+// Book は内線（PBXから取り込み）と外線（利用者が登録）の電話帳。
+// 外線は path のJSONファイルに保存する（空ならメモリのみ）。
+type Book struct {
+	path string
+
+	mu       sync.RWMutex
+	pbx      map[string][]Entry // site -> 内線
+	contacts []Entry
+}
+
+type file struct {
+	Contacts []Entry `json:"contacts"`
+}
+
+func New(path string) (*Book, error) {
+	b := &Book{path: path, pbx: map[string][]Entry{}}
+	if path == "" {
+		return b, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, e := range f.Contacts {
+		e.Source = SourceContact
+		b.contacts = append(b.contacts, e)
+	}
+	return b, nil
+}
+
+// SetPBX はサイトの内線を取り込み直した結果で置き換える
+func (b *Book) SetPBX(site string, entries []Entry) {
+	for i := range entries {
+		entries[i].Source, entries[i].Site = SourcePBX, site
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pbx[site] = entries
+}
+
+// AddContact は外線を登録する（同じ名前の外線があれば ErrExists）
+func (b *Book) AddContact(name, number string) error {
+	name = strings.TrimSpace(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range b.contacts {
+		if normalize(e.Name) == normalize(name) {
+			return fmt.Errorf("%s: %w", e.Label(), ErrExists)
+		}
+	}
+	b.contacts = append(b.contacts, Entry{Name: name, Number: number, Source: SourceContact})
+	return b.save()
+}
+
+// RemoveContact は名前が一致する外線を消す
+func (b *Book) RemoveContact(name string) (Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, e := range b.contacts {
+		if normalize(e.Name) == normalize(name) {
+			b.contacts = append(b.contacts[:i:i], b.contacts[i+1:]...)
+			return e, b.save()
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+// Contacts は登録された外線を名前順に返す
+func (b *Book) Contacts() []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := append([]Entry(nil), b.contacts...)
+	sortEntries(out)
+	return out
+}
+
+// Entries は全件を名前順に返す（同じ番号は1件にまとめ、外線の名前を優先する）
+func (b *Book) Entries() []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	seen := map[string]bool{}
+	var out []Entry
+	add := func(e Entry) {
+		if seen[e.Number] {
+			return
+		}
+		seen[e.Number] = true
+		out = append(out, e)
+	}
+	for _, e := range b.contacts {
+		add(e)
+	}
+	sites := make([]string, 0, len(b.pbx))
+	for s := range b.pbx {
+		sites = append(sites, s)
+	}
+	sort.Strings(sites)
+	for _, s := range sites {
+		for _, e := range b.pbx[s] {
+			add(e)
+		}
+	}
+	sortEntries(out)
+	return out
+}
+
+// Search は名前か番号に query を含む項目を、前方一致を先にして最大 limit 件返す（オートコンプリート用）
+func (b *Book) Search(query string, limit int) []Entry {
+	q := normalize(query)
+	var prefix, contains []Entry
+	for _, e := range b.Entries() {
+		name := normalize(e.Name)
+		switch {
+		case q == "" || strings.HasPrefix(name, q) || strings.HasPrefix(e.Number, q):
+			prefix = append(prefix, e)
+		case strings.Contains(name, q) || strings.Contains(e.Number, q):
+			contains = append(contains, e)
+		}
+	}
+	out := append(prefix, contains...)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Resolve は名前（または登録済みの番号）から発信先を探す。
+// 名前か番号が完全に一致すればそれを、無ければ名前に query を含むものを返す。
+// 2件以上なら呼び出し側で選ばせる。1件も無ければ ErrNotFound。
+func (b *Book) Resolve(query string) ([]Entry, error) {
+	q := normalize(query)
+	if q == "" {
+		return nil, ErrNotFound
+	}
+	all := b.Entries()
+	var exact, partial []Entry
+	for _, e := range all {
+		name := normalize(e.Name)
+		switch {
+		case name == q || e.Number == strings.TrimSpace(query):
+			exact = append(exact, e)
+		case strings.Contains(name, q):
+			partial = append(partial, e)
+		}
+	}
+	if len(exact) > 0 {
+		return exact, nil
+	}
+	if len(partial) > 0 {
+		return partial, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Lookup は番号が一致する項目を返す
+func (b *Book) Lookup(number string) (Entry, bool) {
+	for _, e := range b.Entries() {
+		if e.Number == number {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// save は外線をファイルに書く（mu を持った状態で呼ぶ）
+func (b *Book) save() error {
+	if b.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(file{Contacts: b.contacts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 書き込み途中で落ちても壊れないよう一時ファイル経由で置き換える
+	dir := filepath.Dir(b.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(b.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
+
+func sortEntries(es []Entry) {
+	sort.SliceStable(es, func(i, j int) bool {
+		if es[i].Name != es[j].Name {
+			return es[i].Name < es[j].Name
+		}
+		return es[i].Number < es[j].Number
+	})
+}
+
+// normalize は大文字小文字・空白・全角英数の違いを無視して比べるための形にする
+func normalize(s string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		// 全角英数記号（！〜～）を半角に寄せる
+		if r >= '！' && r <= '～' {
+			r = unicode.ToLower(r - '！' + '!')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
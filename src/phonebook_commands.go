@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/audit"
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/phonebook"
+	"tacnet-odenwakun/src/sipclient"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// phonebookCommands は電話帳を使った発信と電話帳の編集のコマンド
+func phonebookCommands(book *phonebook.Book, pbxs []phonebook.PBX, oki *sipclient.OkiSIP, al *audit.Log) []*commands.Command {
+	complete := func(c *commands.Context, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, e := range book.Search(focused.StringValue(), 25) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncateRunes(e.Label(), 100), Value: e.Number})
+		}
+		return choices
+	}
+	completeContact := func(c *commands.Context, focused *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+		q := strings.ToLower(focused.StringValue())
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, e := range book.Contacts() {
+			if strings.Contains(strings.ToLower(e.Name), q) {
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: truncateRunes(e.Label(), 100), Value: e.Name})
+			}
+		}
+		return choices
+	}
+
+	return []*commands.Command{
+		{
+			Name:        "call",
+			Description: "電話帳の名前で発信する",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "name",
+					Description:  "名前（内線・登録した外線）",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+			Autocomplete: map[string]commands.AutocompleteHandler{"name": complete},
+			Handler: func(c *commands.Context) error {
+				query := c.String("name")
+				entries, err := book.Resolve(query)
+				if errors.Is(err, phonebook.ErrNotFound) {
+					return commands.Errorf("電話帳に見つかりません: %s（番号へは /oki で発信できます）", query)
+				}
+				if err != nil {
+					return err
+				}
+				if len(entries) == 1 {
+					return dial(c, oki, al, entries[0].Number, entries[0].Label(), "")
+				}
+				return c.ReplyComponents(fmt.Sprintf("「%s」に当てはまる宛先が %d 件あります。発信先を選んでください", query, len(entries)),
+					pickerButtons(entries), true)
+			},
+		},
+		{
+			Name:        "phonebook",
+			Description: "電話帳",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "add",
+					Description: "外線を登録する",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "名前", Required: true, MaxLength: 50},
+						{Type: discordgo.ApplicationCommandOptionString, Name: "number", Description: "電話番号", Required: true, MaxLength: 32},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "登録した外線を消す",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "名前", Required: true, Autocomplete: true},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "電話帳を表示する",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "sync",
+					Description: "MikoPBXから内線を取り込み直す",
+				},
+			},
+			Autocomplete: map[string]commands.AutocompleteHandler{"name": completeContact},
+			Handler: func(c *commands.Context) error {
+				switch c.Subcommand {
+				case "add":
+					name, number := strings.TrimSpace(c.String("name")), strings.TrimSpace(c.String("number"))
+					if name == "" {
+						return commands.Errorf("名前を指定してください")
+					}
+					if !sipclient.Dialable(number) {
+						return commands.Errorf("電話番号は数字（と * # +）で指定してください: %s", number)
+					}
+					if err := book.AddContact(name, number); err != nil {
+						if errors.Is(err, phonebook.ErrExists) {
+							return commands.Errorf("同じ名前の外線が登録済みです: %v", err)
+						}
+						return err
+					}
+					return c.Reply(fmt.Sprintf("📒 電話帳に登録しました: %s(%s)", name, number))
+				case "remove":
+					e, err := book.RemoveContact(c.String("name"))
+					if errors.Is(err, phonebook.ErrNotFound) {
+						return commands.Errorf("登録した外線に見つかりません: %s（内線はPBX側で変更してください）", c.String("name"))
+					}
+					if err != nil {
+						return err
+					}
+					return c.Reply(fmt.Sprintf("🗑️ 電話帳から消しました: %s", e.Label()))
+				case "list":
+					return c.ReplyEmbed("", phonebookEmbed(book.Entries()), true)
+				case "sync":
+					if err := c.Defer(true); err != nil {
+						return err
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+					defer cancel()
+					if err := book.Sync(ctx, pbxs); err != nil {
+						return c.Edit(fmt.Sprintf("⚠️ 一部の取り込みに失敗しました: %v", err))
+					}
+					return c.Edit(fmt.Sprintf("📒 内線を取り込み直しました（全 %d 件）", len(book.Entries())))
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// registerPhonebookComponents は /call の候補ボタンのハンドラを登録する
+func registerPhonebookComponents(r *commands.Router, book *phonebook.Book, oki *sipclient.OkiSIP, al *audit.Log) {
+	r.Component("call-pick", func(c *commands.Context) error {
+		// ボタンを押すまでの間に消された宛先には掛けない
+		e, ok := book.Lookup(c.Payload)
+		if !ok {
+			return commands.Errorf("その宛先は電話帳から消されています")
+		}
+		return dial(c, oki, al, e.Number, e.Label(), "")
+	})
+}
+
+func pickerButtons(entries []phonebook.Entry) []discordgo.MessageComponent {
+	var rows []discordgo.MessageComponent
+	var row []discordgo.MessageComponent
+	for i, e := range entries {
+		if i == 25 {
+			break // 5ボタン×5行が上限
+		}
+		row = append(row, discordgo.Button{
+			Label:    truncateRunes(e.Label(), 80),
+			Style:    discordgo.SecondaryButton,
+			CustomID: "call-pick:" + e.Number,
+		})
+		if len(row) == 5 {
+			rows = append(rows, discordgo.ActionsRow{Components: row})
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, discordgo.ActionsRow{Components: row})
+	}
+	return rows
+}
+
+func phonebookEmbed(entries []phonebook.Entry) *discordgo.MessageEmbed {
+	var ext, contacts []string
+	for _, e := range entries {
+		line := fmt.Sprintf("%s `%s`", e.Name, e.Number)
+		if e.Source == phonebook.SourceContact {
+			contacts = append(contacts, line)
+		} else {
+			ext = append(ext, line)
+		}
+	}
+	field := func(name string, lines []string) *discordgo.MessageEmbedField {
+		v := "なし"
+		if len(lines) > 0 {
+			v = truncateRunes(strings.Join(lines, "\n"), 1024)
+		}
+		return &discordgo.MessageEmbedField{Name: fmt.Sprintf("%s（%d件）", name, len(lines)), Value: v}
+	}
+	return &discordgo.MessageEmbed{
+		Title:  "📒 電話帳",
+		Color:  0x3498DB, // blue
+		Fields: []*discordgo.MessageEmbedField{field("内線", ext), field("外線", contacts)},
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
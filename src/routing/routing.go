@@ -0,0 +1,173 @@
+package routing
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Event は1つの端末・プロバイダのstate変化（ルールを当てる対象）
+type Event struct {
+	Site       string
+	Kind       string // "peer" / "provider"
+	ID         string
+	Name       string // 端末は MikoPBX の名前、プロバイダはID
+	From, To   string // state（"" は未検出）
+	FromHealth string // "healthy" / "degraded" / "down"
+	ToHealth   string
+	Time       time.Time
+}
+
+// Rule は一致した変化の通知先を決める。条件は全て満たしたときに一致し、空の条件は何にでも一致する。
+type Rule struct {
+	Name     string
+	Sites    []string       // サイト名
+	Kinds    []string       // "peer" / "provider"
+	IDs      []string       // IDのパターン（* ? [...] が使える。大文字小文字は区別しない）
+	Names    []string       // 名前のパターン
+	From     []string       // 変化前の区分（healthy/degraded/down）か state
+	To       []string       // 変化後の区分か state
+	Days     []time.Weekday // 曜日
+	Hours    string         // 時間帯 "HH:MM-HH:MM"（日を跨いでよい）
+	Channel  string         // 送り先のチャンネルID（空なら既定のチャンネル）
+	Mentions []string       // メンションするロールのID
+	Drop     bool           // 通知しない
+}
+
+// Engine は上から順にルールを当て、最初に一致したものを使う
+type Engine struct {
+	Rules    []Rule
+	Location *time.Location // Days と Hours のタイムゾーン
+
+	hours [][2]int // ルールごとの時間帯（0時からの分）。無ければ -1
+}
+
+func New(rules []Rule, loc *time.Location) (*Engine, error) {
+	e := &Engine{Rules: rules, Location: loc}
+	for i, r := range rules {
+		h := [2]int{-1, -1}
+		if r.Hours != "" {
+			from, to, err := ParseHours(r.Hours)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", e.Name(i), err)
+			}
+			h = [2]int{from, to}
+		}
+		e.hours = append(e.hours, h)
+	}
+	return e, nil
+}
+
+// ParseHours は "22:00-07:00" を0時からの分の組にする
+func ParseHours(s string) (int, int, error) {
+	a, b, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("hours %q is not HH:MM-HH:MM", s)
+	}
+	from, err := time.Parse("15:04", strings.TrimSpace(a))
+	if err != nil {
+		return 0, 0, fmt.Errorf("hours %q is not HH:MM-HH:MM", s)
+	}
+	to, err := time.Parse("15:04", strings.TrimSpace(b))
+	if err != nil {
+		return 0, 0, fmt.Errorf("hours %q is not HH:MM-HH:MM", s)
+	}
+	if from.Equal(to) {
+		return 0, 0, fmt.Errorf("hours %q is empty", s)
+	}
+	return from.Hour()*60 + from.Minute(), to.Hour()*60 + to.Minute(), nil
+}
+
+// Match は ev に最初に一致したルールとその番号を返す（無ければ -1）
+func (e *Engine) Match(ev Event) (Rule, int) {
+	for i := range e.Rules {
+		if e.mismatch(i, ev) == "" {
+			return e.Rules[i], i
+		}
+	}
+	return Rule{}, -1
+}
+
+// Result は1つのルールを ev に当てた結果（dry-run 用）
+type Result struct {
+	Name     string
+	Rule     Rule
+	Mismatch string // 一致しなかった理由（一致なら空）
+}
+
+// Explain は全てのルールについて一致したか、しなかった理由を返す
+func (e *Engine) Explain(ev Event) []Result {
+	out := make([]Result, len(e.Rules))
+	for i, r := range e.Rules {
+		out[i] = Result{Name: e.Name(i), Rule: r, Mismatch: e.mismatch(i, ev)}
+	}
+	return out
+}
+
+// Name は i 番目のルールの名前（無ければ "#1" など）
+func (e *Engine) Name(i int) string {
+	if n := e.Rules[i].Name; n != "" {
+		return n
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// mismatch は i 番目のルールが ev に一致しない理由を返す（一致なら空）
+func (e *Engine) mismatch(i int, ev Event) string {
+	r := e.Rules[i]
+	switch {
+	case len(r.Sites) > 0 && !slices.ContainsFunc(r.Sites, func(s string) bool { return strings.EqualFold(s, ev.Site) }):
+		return "サイトが違う"
+	case len(r.Kinds) > 0 && !slices.ContainsFunc(r.Kinds, func(k string) bool { return strings.EqualFold(k, ev.Kind) }):
+		return "種類が違う"
+	case len(r.IDs) > 0 && !matchAny(r.IDs, ev.ID):
+		return "IDが一致しない"
+	case len(r.Names) > 0 && !matchAny(r.Names, ev.Name):
+		return "名前が一致しない"
+	case len(r.From) > 0 && !matchState(r.From, ev.From, ev.FromHealth):
+		return "変化前が一致しない"
+	case len(r.To) > 0 && !matchState(r.To, ev.To, ev.ToHealth):
+		return "変化後が一致しない"
+	}
+	t := ev.Time
+	if e.Location != nil {
+		t = t.In(e.Location)
+	}
+	if len(r.Days) > 0 && !slices.Contains(r.Days, t.Weekday()) {
+		return "曜日が違う"
+	}
+	if h := e.hours[i]; h[0] >= 0 {
+		m := t.Hour()*60 + t.Minute()
+		in := m >= h[0] && m < h[1]
+		if h[0] > h[1] { // 日を跨ぐ
+			in = m >= h[0] || m < h[1]
+		}
+		if !in {
+			return "時間帯の外"
+		}
+	}
+	return ""
+}
+
+// matchAny は s がパターンのどれかに一致するか
+func matchAny(patterns []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchState は区分名か state（パターン可）のどれかに一致するか
+func matchState(patterns []string, state, health string) bool {
+	for _, p := range patterns {
+		if strings.EqualFold(p, health) {
+			return true
+		}
+	}
+	return matchAny(patterns, state)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/commands"
+	"tacnet-odenwakun/src/routing"
+	"tacnet-odenwakun/src/watcher"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// routingCommand は通知の振り分けルールの一覧と、変化がどのルールに当たるかの確認（dry-run）のコマンド
+func routingCommand(rules *routing.Engine, watchers []*watcher.Watcher) *commands.Command {
+	testOpts := []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "kind", Description: "種類", Required: true, Choices: []*discordgo.ApplicationCommandOptionChoice{
+			{Name: "端末", Value: "peer"},
+			{Name: "プロバイダ", Value: "provider"},
+		}},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "端末・プロバイダのID", Required: true, MaxLength: 100},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "to", Description: "変化後のstate（OK, LAGGED, UNREACHABLE, REJECTED, OFF など）", Required: true, MaxLength: 32},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "変化前のstate（既定は to が正常なら OFF、それ以外は OK）", MaxLength: 32},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "端末の名前（既定はPBXから引く）", MaxLength: 100},
+		{Type: discordgo.ApplicationCommandOptionString, Name: "at", Description: "時刻（YYYY-MM-DD HH:MM か HH:MM。既定は今）", MaxLength: 16},
+	}
+	if len(watchers) > 1 {
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, w := range watchers {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: w.Site, Value: w.Site})
+		}
+		if len(choices) > 25 {
+			choices = choices[:25]
+		}
+		testOpts = append(testOpts, &discordgo.ApplicationCommandOption{
+			Type: discordgo.ApplicationCommandOptionString, Name: "site", Description: "サイト（既定は最初のサイト）", Choices: choices,
+		})
+	}
+
+	return &commands.Command{
+		Name:        "routing",
+		Description: "通知の振り分けルール",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "ルールを上から順に見る",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "test",
+				Description: "変化がどのルールに当たるかを確かめる（通知はしない）",
+				Options:     testOpts,
+			},
+		},
+		Handler: func(c *commands.Context) error {
+			if rules == nil || len(rules.Rules) == 0 {
+				return commands.Errorf("ルールがありません（routing.rules に書くと全ての変化が既定のチャンネルへ出ます）")
+			}
+			switch c.Subcommand {
+			case "list":
+				var lines []string
+				for i, r := range rules.Rules {
+					lines = append(lines, fmt.Sprintf("**%s** %s → %s", rules.Name(i), ruleConditions(r), ruleAction(r)))
+				}
+				embed := &discordgo.MessageEmbed{
+					Title:       "🔀 通知の振り分けルール",
+					Description: truncateRunes(strings.Join(lines, "\n"), 4000),
+					Color:       0x3498DB, // blue
+					Footer:      &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("最初に一致したルールを使う・時刻は %s", rules.Location)},
+				}
+				return c.ReplyEmbed("", embed, true)
+			case "test":
+				w := watchers[0]
+				if site := c.String("site"); site != "" {
+					for _, sw := range watchers {
+						if sw.Site == site {
+							w = sw
+						}
+					}
+				}
+				at := time.Now()
+				if s := strings.TrimSpace(c.String("at")); s != "" {
+					t, err := parseRoutingTime(s, at, rules.Location)
+					if err != nil {
+						return commands.Errorf("時刻は YYYY-MM-DD HH:MM か HH:MM で指定してください: %s", s)
+					}
+					at = t
+				}
+				kind, id := c.String("kind"), strings.TrimSpace(c.String("id"))
+				to := strings.ToUpper(strings.TrimSpace(c.String("to")))
+				from := strings.ToUpper(strings.TrimSpace(c.String("from")))
+				if from == "" {
+					from = watcher.StateOK
+					if w.States.Classify(to) == watcher.Healthy {
+						from = watcher.StateOff
+					}
+				}
+				name := strings.TrimSpace(c.String("name"))
+				if name == "" && kind == "peer" {
+					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+					name, _ = w.Client.GetPeerName(ctx, id) // 引けなければ名前なしで当てる
+					cancel()
+				}
+				if kind == "provider" {
+					name = id
+				}
+				ev := w.RoutingEvent(kind, id, name, from, to, at)
+				return c.ReplyEmbed("", routingTestEmbed(rules, ev), true)
+			}
+			return nil
+		},
+	}
+}
+
+// parseRoutingTime は "2006-01-02 15:04" か "15:04"（今日）を loc の時刻として読む
+func parseRoutingTime(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	n := now.In(loc)
+	return time.Date(n.Year(), n.Month(), n.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+}
+
+// routingTestEmbed は ev が当たるルールと、手前のルールに当たらなかった理由を表す
+func routingTestEmbed(rules *routing.Engine, ev routing.Event) *discordgo.MessageEmbed {
+	kind := "端末"
+	if ev.Kind == "provider" {
+		kind = "プロバイダ"
+	}
+	subject := fmt.Sprintf("%s %s", kind, ev.ID)
+	if ev.Name != "" && ev.Name != ev.ID {
+		subject = fmt.Sprintf("%s %s(%s)", kind, ev.Name, ev.ID)
+	}
+	embed := &discordgo.MessageEmbed{
+		Title: "🔀 振り分けの確認",
+		Description: fmt.Sprintf("%s: %s(%s) → %s(%s)\n%s", subject, ev.From, ev.FromHealth, ev.To, ev.ToHealth,
+			ev.Time.In(rules.Location).Format("2006-01-02(Mon) 15:04 MST")),
+		Color: 0x95A5A6, // gray
+	}
+	if ev.Site != "" {
+		embed.Description = fmt.Sprintf("[%s] %s", ev.Site, embed.Description)
+	}
+	var lines []string
+	hit := ""
+	for _, r := range rules.Explain(ev) {
+		if r.Mismatch != "" {
+			lines = append(lines, fmt.Sprintf("❌ %s: %s", r.Name, r.Mismatch))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("✅ **%s**: 一致", r.Name))
+		hit = fmt.Sprintf("ルール **%s** → %s", r.Name, ruleAction(r.Rule))
+		embed.Color = 0x2ECC71 // green
+		break
+	}
+	if hit == "" {
+		hit = "どのルールにも当たりません → 既定のチャンネル"
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		{Name: "結果", Value: hit},
+		{Name: "ルール（上から順）", Value: truncateRunes(strings.Join(lines, "\n"), 1024)},
+	}
+	return embed
+}
+
+// ruleConditions はルールの条件を1行で表す
+func ruleConditions(r routing.Rule) string {
+	var conds []string
+	add := func(label string, vals []string) {
+		if len(vals) > 0 {
+			conds = append(conds, label+"="+strings.Join(vals, "|"))
+		}
+	}
+	add("site", r.Sites)
+	add("kind", r.Kinds)
+	add("id", r.IDs)
+	add("name", r.Names)
+	add("from", r.From)
+	add("to", r.To)
+	var days []string
+	for _, d := range r.Days {
+		days = append(days, d.String()[:3])
+	}
+	add("days", days)
+	if r.Hours != "" {
+		conds = append(conds, "hours="+r.Hours)
+	}
+	if len(conds) == 0 {
+		return "（全て）"
+	}
+	return "`" + strings.Join(conds, " ") + "`"
+}
+
+// ruleAction はルールが一致したときの扱いを表す
+func ruleAction(r routing.Rule) string {
+	if r.Drop {
+		return "🗑️ 通知しない"
+	}
+	var parts []string
+	if r.Channel != "" {
+		parts = append(parts, fmt.Sprintf("<#%s>", r.Channel))
+	} else {
+		parts = append(parts, "既定のチャンネル")
+	}
+	for _, id := range r.Mentions {
+		parts = append(parts, fmt.Sprintf("<@&%s>", id))
+	}
+	return strings.Join(parts, " ")
+}
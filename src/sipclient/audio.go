@@ -0,0 +1,212 @@
+package sipclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sampleRate はG.711の標本化周波数
+const sampleRate = 8000
+
+// Audio は通話で流す音声（8kHz・モノラルの16bitリニアPCM）
+type Audio struct {
+	Name    string
+	samples []int16
+}
+
+// Duration は再生時間を返す
+func (a *Audio) Duration() time.Duration {
+	return time.Duration(len(a.samples)) * time.Second / sampleRate
+}
+
+// LoadAudio は WAV（リニアPCM 16bit / µ-law / A-law、8kHz）か、拡張子 .pcm/.raw の
+// 16bitリトルエンディアン・8kHz・モノラルの生データを読む。ステレオはモノラルに混ぜる。
+func LoadAudio(name, path string) (*Audio, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pcm", ".raw":
+		return &Audio{Name: name, samples: pcm16(b, 1)}, nil
+	}
+	samples, err := decodeWAV(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Audio{Name: name, samples: samples}, nil
+}
+
+// WAVの形式コード
+const (
+	wavPCM        = 1
+	wavALaw       = 6
+	wavULaw       = 7
+	wavExtensible = 0xFFFE
+)
+
+func decodeWAV(b []byte) ([]int16, error) {
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+	var (
+		format, channels, bits uint16
+		rate                   uint32
+		data                   []byte
+		haveFmt                bool
+	)
+	for p := 12; p+8 <= len(b); {
+		id, size := string(b[p:p+4]), int(binary.LittleEndian.Uint32(b[p+4:p+8]))
+		body := b[p+8 : min(p+8+size, len(b))]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, errors.New("short fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			rate = binary.LittleEndian.Uint32(body[4:8])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format == wavExtensible && len(body) >= 26 {
+				format = binary.LittleEndian.Uint16(body[24:26]) // SubFormat GUID の先頭
+			}
+			haveFmt = true
+		case "data":
+			data = body
+		}
+		p += 8 + size + size%2 // チャンクは偶数境界
+	}
+	if !haveFmt || data == nil {
+		return nil, errors.New("missing fmt or data chunk")
+	}
+	if rate != sampleRate {
+		return nil, fmt.Errorf("sample rate %d Hz is not supported, convert to 8000 Hz mono (e.g. sox in.wav -r 8000 -c 1 -b 16 out.wav)", rate)
+	}
+	if channels == 0 {
+		return nil, errors.New("no channels")
+	}
+	switch {
+	case format == wavPCM && bits == 16:
+		return pcm16(data, int(channels)), nil
+	case format == wavULaw && bits == 8:
+		return g711Samples(data, int(channels), ulawToLinear), nil
+	case format == wavALaw && bits == 8:
+		return g711Samples(data, int(channels), alawToLinear), nil
+	}
+	return nil, fmt.Errorf("WAV format %d / %d bit is not supported (use 16 bit PCM, µ-law or A-law)", format, bits)
+}
+
+// pcm16 は16bitリトルエンディアンのPCMをモノラルにする
+func pcm16(b []byte, channels int) []int16 {
+	frames := len(b) / 2 / channels
+	out := make([]int16, frames)
+	for i := range out {
+		sum := 0
+		for ch := 0; ch < channels; ch++ {
+			off := (i*channels + ch) * 2
+			sum += int(int16(binary.LittleEndian.Uint16(b[off:])))
+		}
+		out[i] = int16(sum / channels)
+	}
+	return out
+}
+
+func g711Samples(b []byte, channels int, decode func(byte) int16) []int16 {
+	frames := len(b) / channels
+	out := make([]int16, frames)
+	for i := range out {
+		sum := 0
+		for ch := 0; ch < channels; ch++ {
+			sum += int(decode(b[i*channels+ch]))
+		}
+		out[i] = int16(sum / channels)
+	}
+	return out
+}
+
+// --- G.711（ITU-T G.711 / Sun g711.c と同じ変換） ---
+
+var (
+	ulawSegEnd = [8]int{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+	alawSegEnd = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+)
+
+func segment(v int, ends *[8]int) int {
+	for i, e := range ends {
+		if v <= e {
+			return i
+		}
+	}
+	return 8
+}
+
+func linearToULaw(s int16) byte {
+	const bias, clip = 0x84, 8159
+	v := int(s) >> 2
+	mask := 0xFF
+	if v < 0 {
+		v, mask = -v, 0x7F
+	}
+	if v > clip {
+		v = clip
+	}
+	v += bias >> 2
+	seg := segment(v, &ulawSegEnd)
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	return byte((seg<<4 | (v>>(seg+1))&0x0F) ^ mask)
+}
+
+func ulawToLinear(u byte) int16 {
+	const bias = 0x84
+	u = ^u
+	t := (int(u&0x0F) << 3) + bias
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(bias - t)
+	}
+	return int16(t - bias)
+}
+
+func linearToALaw(s int16) byte {
+	v := int(s) >> 3
+	mask := 0xD5
+	if v < 0 {
+		v, mask = -v-1, 0x55
+	}
+	seg := segment(v, &alawSegEnd)
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	a := seg << 4
+	if seg < 2 {
+		a |= (v >> 1) & 0x0F
+	} else {
+		a |= (v >> seg) & 0x0F
+	}
+	return byte(a ^ mask)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	switch seg := int(a&0x70) >> 4; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
@@ -0,0 +1,278 @@
+package sipclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"tacnet-odenwakun/src/metrics"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// CallState は発信の進行状況
+type CallState int
+
+const (
+	CallTrying   CallState = iota // INVITE送信済み
+	CallRinging                   // 相手を呼び出し中（180/183）
+	CallAnswered                  // 応答あり（通話中）
+	CallFailed                    // 不成立（SIPコード付き）
+	CallEnded                     // 通話終了
+)
+
+func (s CallState) String() string {
+	switch s {
+	case CallTrying:
+		return "trying"
+	case CallRinging:
+		return "ringing"
+	case CallAnswered:
+		return "answered"
+	case CallFailed:
+		return "failed"
+	case CallEnded:
+		return "ended"
+	default:
+		return "unknown"
+	}
+}
+
+// Final は以降の遷移がない状態かを返す
+func (s CallState) Final() bool { return s == CallFailed || s == CallEnded }
+
+// CallEvent は状態遷移1回分
+type CallEvent struct {
+	State  CallState
+	Code   int    // SIPステータスコード（不明なら0）
+	Reason string // SIPのReasonやエラー内容
+	At     time.Time
+}
+
+// Call は1回の発信のハンドル。Events() で遷移を受け取れる（最終状態の後にcloseされる）。
+type Call struct {
+	Number    string
+	StartedAt time.Time
+
+	mu         sync.Mutex
+	id         string // SIP Call-ID（INVITE送信後に確定）
+	state      CallState
+	code       int
+	reason     string
+	answeredAt time.Time
+	endedAt    time.Time
+	sess       *session.Session
+	events     chan CallEvent
+	digits     chan rune // 相手が押したキー（InviteListen のときだけ届く）
+
+	provisional bool          // 1xxを受信済み（CANCEL可能）
+	hangupWant  bool          // 1xx前に切断が要求された
+	localReason string        // こちらから切った理由（最終イベントのReasonに使う）
+	timer       *time.Timer   // 呼出/通話の上限タイマー
+	media       *mediaSession // 応答後に流す音声（なければnil）
+}
+
+var ErrCallFinished = errors.New("call already finished")
+
+func newCall(number string) *Call {
+	return &Call{
+		Number:    number,
+		StartedAt: time.Now(),
+		state:     CallTrying,
+		events:    make(chan CallEvent, 16),
+		digits:    make(chan rune, 16),
+	}
+}
+
+// ID はSIP Call-IDを返す（INVITE送信前は空）
+func (c *Call) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+func (c *Call) Events() <-chan CallEvent { return c.events }
+
+// Digits は相手が押したDTMFのキーを流す（最終状態の後にcloseされる）
+func (c *Call) Digits() <-chan rune { return c.digits }
+
+// pushDigit は受け取ったキーを流す。読まれなければ捨てる。
+func (c *Call) pushDigit(d rune) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Final() {
+		return
+	}
+	select {
+	case c.digits <- d:
+	default:
+	}
+}
+
+func (c *Call) State() CallState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Result は最終的なSIPコードと理由を返す
+func (c *Call) Result() (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.code, c.reason
+}
+
+// Duration は通話時間（応答から終了まで。通話中なら現在まで）を返す
+func (c *Call) Duration() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.answeredAt.IsZero() {
+		return 0
+	}
+	if c.endedAt.IsZero() {
+		return time.Since(c.answeredAt)
+	}
+	return c.endedAt.Sub(c.answeredAt)
+}
+
+// Hangup は通話を終わらせる。呼出中ならCANCEL、通話中ならBYEを送る。
+// reason は終了イベントの理由として表示される（空なら既定）。
+func (c *Call) Hangup(reason string) error {
+	c.mu.Lock()
+	if c.state.Final() {
+		c.mu.Unlock()
+		return ErrCallFinished
+	}
+	if reason == "" {
+		reason = "切断"
+	}
+	c.localReason = reason
+	sess, state := c.sess, c.state
+	if sess == nil || (state != CallAnswered && !c.provisional) {
+		// CANCELは1xx受信後でないと送れないので、届いたところで送る
+		c.hangupWant = true
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+	if state == CallAnswered {
+		_, err := sess.Bye()
+		return err
+	}
+	return sess.End()
+}
+
+// markProvisional は1xx受信を記録し、保留中の切断要求があれば true を返す
+func (c *Call) markProvisional() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.provisional = true
+	want := c.hangupWant
+	c.hangupWant = false
+	return want
+}
+
+// armTimer は d 経過後に reason で切断するタイマーを張り直す（d<=0 なら解除のみ）
+func (c *Call) armTimer(d time.Duration, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if d <= 0 || c.state.Final() {
+		return
+	}
+	c.timer = time.AfterFunc(d, func() { _ = c.Hangup(reason) })
+}
+
+// transition は状態を進めてイベントを流す。後戻りや最終状態後の遷移は無視する。
+func (c *Call) transition(state CallState, code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Final() || (!state.Final() && state <= c.state) {
+		return
+	}
+	now := time.Now()
+	c.state = state
+	if code != 0 {
+		c.code = code
+	}
+	if reason != "" {
+		c.reason = reason
+	}
+	switch state {
+	case CallAnswered:
+		c.answeredAt = now
+	case CallFailed, CallEnded:
+		c.endedAt = now
+		if c.localReason != "" {
+			c.reason = c.localReason
+			reason = c.localReason
+		}
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		if c.media != nil {
+			c.media.close()
+		}
+		if c.answeredAt.IsZero() {
+			metrics.SIPOutgoingCalls.Inc("unanswered")
+		} else {
+			metrics.SIPOutgoingCalls.Inc("answered")
+			metrics.SIPCallTalkDuration.Observe(now.Sub(c.answeredAt).Seconds())
+		}
+	}
+	// 遷移は最大4回なのでバッファで足りるが、読まれなくても詰まらないようにしておく
+	select {
+	case c.events <- CallEvent{State: state, Code: code, Reason: reason, At: now}:
+	default:
+	}
+	if state.Final() {
+		close(c.events)
+		close(c.digits)
+	}
+}
+
+// sessionToCall は go-sip-ua のセッション状態を CallState に対応付ける
+func sessionToCall(state session.Status, code int, answered bool) (CallState, bool) {
+	switch state {
+	case session.InviteSent:
+		return CallTrying, true
+	case session.Provisional:
+		if code == 180 || code == 183 {
+			return CallRinging, true
+		}
+		return CallTrying, true
+	case session.EarlyMedia:
+		return CallRinging, true
+	case session.Answered, session.WaitingForACK, session.Confirmed:
+		return CallAnswered, true
+	case session.Failure, session.Canceled:
+		return CallFailed, true
+	case session.Terminated:
+		if answered {
+			return CallEnded, true
+		}
+		return CallFailed, true
+	}
+	return 0, false
+}
+
+func responseStatus(resp *sip.Response) (int, string) {
+	if resp == nil || *resp == nil {
+		return 0, ""
+	}
+	return int((*resp).StatusCode()), (*resp).Reason()
+}
+
+func requestUser(req *sip.Request) string {
+	if req == nil || *req == nil || (*req).Recipient() == nil {
+		return ""
+	}
+	if u := (*req).Recipient().User(); u != nil {
+		return u.String()
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+package sipclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"tacnet-odenwakun/src/metrics"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// IncomingOutcome は着信の結末
+type IncomingOutcome int
+
+const (
+	IncomingRinging     IncomingOutcome = iota // 呼び出し中
+	IncomingMissed                             // 相手が切った（不在着信）
+	IncomingRejected                           // こちらで拒否した
+	IncomingTransferred                        // 別の内線へ転送した
+)
+
+func (o IncomingOutcome) String() string {
+	switch o {
+	case IncomingMissed:
+		return "missed"
+	case IncomingRejected:
+		return "rejected"
+	case IncomingTransferred:
+		return "transferred"
+	default:
+		return "ringing"
+	}
+}
+
+// IncomingCall はOKIアカウントへの着信1件
+type IncomingCall struct {
+	ID          string // SIP Call-ID
+	From        string // 発信者番号
+	DisplayName string // 発信者の表示名（無ければ空）
+	ReceivedAt  time.Time
+
+	mu      sync.Mutex
+	outcome IncomingOutcome
+	target  string // 転送先
+	endedAt time.Time
+	sess    *session.Session
+}
+
+// Outcome は現在の結末と転送先を返す
+func (c *IncomingCall) Outcome() (IncomingOutcome, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.outcome, c.target
+}
+
+// EndedAt は結末が決まった時刻（呼び出し中はゼロ値）
+func (c *IncomingCall) EndedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endedAt
+}
+
+// finish は結末を確定する。既に確定済みなら false。
+func (c *IncomingCall) finish(outcome IncomingOutcome, target string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.outcome != IncomingRinging {
+		return false
+	}
+	c.outcome = outcome
+	c.target = target
+	c.endedAt = time.Now()
+	metrics.SIPIncomingCalls.Inc(outcome.String())
+	return true
+}
+
+// OnIncoming は着信の開始と結末の確定のたびに呼ばれる関数を登録する（Start前に呼ぶ）
+func (o *OkiSIP) OnIncoming(fn func(*IncomingCall)) {
+	o.onIncoming = fn
+}
+
+// trackIncoming は InviteStateHandler の着信イベントを処理する
+func (o *OkiSIP) trackIncoming(sess *session.Session, req *sip.Request, state session.Status) {
+	if sess.CallID() == nil {
+		return
+	}
+	id := string(*sess.CallID())
+
+	switch state {
+	case session.InviteReceived:
+		call := &IncomingCall{ID: id, ReceivedAt: time.Now(), sess: sess}
+		if req != nil && *req != nil {
+			if from, ok := (*req).From(); ok && from != nil {
+				if from.DisplayName != nil {
+					call.DisplayName = from.DisplayName.String()
+				}
+				if from.Address != nil && from.Address.User() != nil {
+					call.From = from.Address.User().String()
+				}
+			}
+		}
+		o.mu.Lock()
+		o.incoming[id] = call
+		o.mu.Unlock()
+		// 取らない着信なので呼び出し音だけ返しておく
+		sess.Provisional(180, "Ringing")
+		o.emitIncoming(call)
+	case session.Canceled, session.Failure, session.Terminated:
+		o.mu.Lock()
+		call, ok := o.incoming[id]
+		delete(o.incoming, id)
+		o.mu.Unlock()
+		if ok && call.finish(IncomingMissed, "") {
+			o.emitIncoming(call)
+		}
+	}
+}
+
+// RejectIncoming は呼び出し中の着信を拒否する（486 Busy Here）
+func (o *OkiSIP) RejectIncoming(id string) (*IncomingCall, error) {
+	call, err := o.takeIncoming(id)
+	if err != nil {
+		return nil, err
+	}
+	if !call.finish(IncomingRejected, "") {
+		return call, fmt.Errorf("call already finished")
+	}
+	call.sess.Reject(486, "Busy Here")
+	o.emitIncoming(call)
+	return call, nil
+}
+
+// TransferIncoming は呼び出し中の着信を別の内線へ転送する（302 Moved Temporarily）
+func (o *OkiSIP) TransferIncoming(id, ext string) (*IncomingCall, error) {
+	if !Dialable(ext) {
+		return nil, fmt.Errorf("invalid extension %q", ext)
+	}
+	if err := o.checkNumber("transfer", ext); err != nil {
+		return nil, err
+	}
+	uri, err := parser.ParseUri(fmt.Sprintf("sip:%s@%s", ext, o.domain))
+	if err != nil {
+		return nil, err
+	}
+	call, err := o.takeIncoming(id)
+	if err != nil {
+		return nil, err
+	}
+	if !call.finish(IncomingTransferred, ext) {
+		return call, fmt.Errorf("call already finished")
+	}
+	call.sess.Redirect(&sip.Address{Uri: uri}, 302)
+	o.emitIncoming(call)
+	return call, nil
+}
+
+func (o *OkiSIP) takeIncoming(id string) (*IncomingCall, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	call, ok := o.incoming[id]
+	if !ok {
+		return nil, fmt.Errorf("incoming call %s not found", id)
+	}
+	delete(o.incoming, id)
+	return call, nil
+}
+
+func (o *OkiSIP) emitIncoming(call *IncomingCall) {
+	if o.onIncoming != nil {
+		o.onIncoming(call)
+	}
+}
+
+// Dialable は番号として使える文字（数字と * # +）だけかを返す
+func Dialable(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && r != '*' && r != '#' && r != '+' {
+			return false
+		}
+	}
+	return true
+}
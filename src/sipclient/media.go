@@ -0,0 +1,294 @@
+package sipclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RTPのペイロードタイプ（RFC 3551 の静的割り当て）
+const (
+	payloadPCMU = 0 // G.711 µ-law
+	payloadPCMA = 8 // G.711 A-law
+	// payloadDTMF はオファーに書く telephone-event（RFC 4733）の動的ペイロードタイプ
+	payloadDTMF = 101
+)
+
+const (
+	rtpFrame   = 20 * time.Millisecond // 1パケットの長さ
+	rtpSamples = sampleRate / 50       // 20ms分のサンプル数
+)
+
+// mediaSession は1回の発信の音声。onDigit があれば相手のDTMF（RFC 4733）も受け取る。
+type mediaSession struct {
+	audio   *Audio
+	loop    bool       // 止められるまで繰り返す
+	onDigit func(rune) // 押されたキー（nilなら送信専用）
+	conn    *net.UDPConn
+	ip      string // SDPに書く自分のアドレス
+
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newMediaSession は ip の空きポートでRTPソケットを開く
+func newMediaSession(ip string, audio *Audio) (*mediaSession, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		return nil, fmt.Errorf("rtp listen: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mediaSession{audio: audio, conn: conn, ip: ip, ctx: ctx, cancel: cancel}, nil
+}
+
+// offer はINVITEに載せるSDPオファー（G.711 µ-law/A-law。DTMFを受けるなら telephone-event も）
+func (m *mediaSession) offer() string {
+	port := m.conn.LocalAddr().(*net.UDPAddr).Port
+	id := time.Now().Unix()
+	media := fmt.Sprintf("m=audio %d RTP/AVP %d %d", port, payloadPCMU, payloadPCMA)
+	if m.onDigit != nil {
+		media += fmt.Sprintf(" %d", payloadDTMF)
+	}
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=odenwakun %d %d IN IP4 %s", id, id, m.ip),
+		"s=odenwakun",
+		"c=IN IP4 " + m.ip,
+		"t=0 0",
+		media,
+		fmt.Sprintf("a=rtpmap:%d PCMU/8000", payloadPCMU),
+		fmt.Sprintf("a=rtpmap:%d PCMA/8000", payloadPCMA),
+	}
+	if m.onDigit != nil {
+		lines = append(lines,
+			fmt.Sprintf("a=rtpmap:%d telephone-event/8000", payloadDTMF),
+			fmt.Sprintf("a=fmtp:%d 0-15", payloadDTMF),
+			"a=ptime:20",
+			"a=sendrecv")
+	} else {
+		lines = append(lines, "a=ptime:20", "a=sendonly")
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// play は answer の宛先へ音声を最後まで送る（loop なら止められるまで繰り返す）。close されたら途中で止める。
+func (m *mediaSession) play(answer string) error {
+	ans, err := parseSDPAnswer(answer)
+	if err != nil {
+		return err
+	}
+	if m.onDigit != nil {
+		go m.receive(ans.dtmf)
+	}
+	encode := linearToULaw
+	if ans.payload == payloadPCMA {
+		encode = linearToALaw
+	}
+
+	// SSRC・シーケンス番号・タイムスタンプの初期値は乱数（RFC 3550）
+	var rnd [10]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return err
+	}
+	var hdr [12]byte
+	hdr[0] = 0x80 // V=2
+	copy(hdr[8:12], rnd[0:4])
+	seq := binary.BigEndian.Uint16(rnd[4:6])
+	ts := binary.BigEndian.Uint32(rnd[6:10])
+
+	samples := m.audio.samples
+	// 繰り返すときは1秒の無音を挟む
+	if m.loop {
+		samples = append(samples[:len(samples):len(samples)], make([]int16, sampleRate)...)
+	}
+	pkt := make([]byte, 12+rtpSamples)
+	ticker := time.NewTicker(rtpFrame)
+	defer ticker.Stop()
+	first := true
+	for {
+		for off := 0; off < len(samples); off += rtpSamples {
+			hdr[1] = byte(ans.payload)
+			if first {
+				hdr[1] |= 0x80 // マーカー: 話頭
+				first = false
+			}
+			binary.BigEndian.PutUint16(hdr[2:4], seq)
+			binary.BigEndian.PutUint32(hdr[4:8], ts)
+			copy(pkt, hdr[:])
+			for i := 0; i < rtpSamples; i++ {
+				var s int16 // 最後のフレームの残りは無音で埋める
+				if off+i < len(samples) {
+					s = samples[off+i]
+				}
+				pkt[12+i] = encode(s)
+			}
+			if _, err := m.conn.WriteToUDP(pkt, ans.addr); err != nil {
+				if m.ctx.Err() != nil {
+					return m.ctx.Err()
+				}
+				return fmt.Errorf("rtp send: %w", err)
+			}
+			seq++
+			ts += rtpSamples
+			select {
+			case <-m.ctx.Done():
+				return m.ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		if !m.loop {
+			return nil
+		}
+	}
+}
+
+// receive は届いたRTPから telephone-event を拾って onDigit に渡す（close まで続く）
+func (m *mediaSession) receive(pt int) {
+	buf := make([]byte, 1500)
+	var (
+		lastTS uint32
+		seen   bool
+	)
+	for {
+		n, _, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			return // close された
+		}
+		ts, digit, ok := parseDTMF(buf[:n], pt)
+		// 1回の押下は同じタイムスタンプで何度も（終了パケットは3回）届くので最初の1つだけ使う
+		if !ok || (seen && ts == lastTS) {
+			continue
+		}
+		lastTS, seen = ts, true
+		m.onDigit(digit)
+	}
+}
+
+// dtmfDigits は telephone-event のイベント番号 0〜15 に対応するキー
+const dtmfDigits = "0123456789*#ABCD"
+
+// parseDTMF はRTPパケットが telephone-event ならタイムスタンプとキーを返す
+func parseDTMF(pkt []byte, pt int) (uint32, rune, bool) {
+	if len(pkt) < 12 || pkt[0]>>6 != 2 || int(pkt[1]&0x7F) != pt {
+		return 0, 0, false
+	}
+	off := 12 + 4*int(pkt[0]&0x0F) // CSRC
+	if pkt[0]&0x10 != 0 {          // 拡張ヘッダ
+		if len(pkt) < off+4 {
+			return 0, 0, false
+		}
+		off += 4 + 4*int(binary.BigEndian.Uint16(pkt[off+2:off+4]))
+	}
+	if len(pkt) < off+4 || int(pkt[off]) >= len(dtmfDigits) {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(pkt[4:8]), rune(dtmfDigits[pkt[off]]), true
+}
+
+// close は再生を止めてソケットを閉じる（何度呼んでもよい）
+func (m *mediaSession) close() {
+	m.once.Do(func() {
+		m.cancel()
+		m.conn.Close()
+	})
+}
+
+// sdpAnswer は相手のSDPのうち音声を送るのに要るもの
+type sdpAnswer struct {
+	addr    *net.UDPAddr
+	payload int // PCMU か PCMA
+	dtmf    int // telephone-event のペイロードタイプ
+}
+
+// parseSDPAnswer は相手のSDPから音声の宛先と使うペイロードタイプ（PCMU/PCMA）を取り出す
+func parseSDPAnswer(sdp string) (sdpAnswer, error) {
+	var (
+		sessionAddr, mediaAddr string
+		port                   = -1
+		pt                     = -1
+		dtmf                   = payloadDTMF // アンサーに無ければオファーの値
+		inAudio                bool
+	)
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			inAudio = false
+			f := strings.Fields(line[2:])
+			if port >= 0 || len(f) < 4 || f[0] != "audio" {
+				continue // 最初の音声ストリームだけ使う
+			}
+			p, err := strconv.Atoi(f[1])
+			if err != nil {
+				return sdpAnswer{}, fmt.Errorf("bad media line: %q", line)
+			}
+			port, inAudio = p, true
+			for _, fmtStr := range f[3:] {
+				if n, err := strconv.Atoi(fmtStr); err == nil && (n == payloadPCMU || n == payloadPCMA) {
+					pt = n
+					break
+				}
+			}
+		case inAudio && strings.HasPrefix(line, "a=rtpmap:"):
+			// a=rtpmap:101 telephone-event/8000
+			n, enc, _ := strings.Cut(line[len("a=rtpmap:"):], " ")
+			if p, err := strconv.Atoi(n); err == nil && strings.HasPrefix(strings.ToLower(enc), "telephone-event/") {
+				dtmf = p
+			}
+		case strings.HasPrefix(line, "c="):
+			f := strings.Fields(line[2:])
+			if len(f) < 3 {
+				continue
+			}
+			addr := strings.SplitN(f[2], "/", 2)[0]
+			if inAudio {
+				mediaAddr = addr
+			} else if port < 0 {
+				sessionAddr = addr
+			}
+		}
+	}
+	if port < 0 {
+		return sdpAnswer{}, errors.New("no audio stream in SDP answer")
+	}
+	if port == 0 {
+		return sdpAnswer{}, errors.New("audio stream was rejected")
+	}
+	if pt < 0 {
+		return sdpAnswer{}, errors.New("callee does not accept G.711 (PCMU/PCMA)")
+	}
+	addr := mediaAddr
+	if addr == "" {
+		addr = sessionAddr
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		ips, err := net.LookupIP(addr)
+		if err != nil || len(ips) == 0 {
+			return sdpAnswer{}, fmt.Errorf("bad connection address %q in SDP answer", addr)
+		}
+		ip = ips[0]
+	}
+	return sdpAnswer{addr: &net.UDPAddr{IP: ip, Port: port}, payload: pt, dtmf: dtmf}, nil
+}
+
+// localIP は SIPサーバーへ向かうときに使われる自分のアドレスを返す（SDPに書く）
+func localIP(server string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "5060")
+	}
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
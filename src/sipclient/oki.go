@@ -3,11 +3,13 @@ package sipclient
 import (
 	"fmt"
 	"net"
-	"os"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"tacnet-odenwakun/src/config"
+
 	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
@@ -33,27 +35,35 @@ type OkiSIP struct {
 	user      string
 	password  string
 	expires   int
+	maxRing   time.Duration // 呼出の上限（0で無制限）
+	maxTalk   time.Duration // 通話の上限（0で無制限）
+	rules     NumberRules   // 発信・転送してよい番号
+	mediaIP   string        // SDPに書くRTPのアドレス（空なら自動）
+
+	announcements map[string]*Audio // 名前 -> 応答後に流す音声
+
+	// 発信中の通話
+	mu      sync.Mutex
+	pending map[string][]*Call // 番号 -> Call-ID未確定の発信（INVITE送信イベントで紐付ける）
+	calls   map[string]*Call   // Call-ID -> 発信
+
+	// 着信
+	incoming   map[string]*IncomingCall // Call-ID -> 呼び出し中の着信
+	onIncoming func(*IncomingCall)
+
+	reg Registration // 最新のREGISTER結果
 }
 
-func NewFromEnv() (*OkiSIP, error) {
-	srv := strings.TrimSpace(os.Getenv("OKI_SIP_SERVER")) // host:port
+// New は検証済みの設定からクライアントを作る（Start で登録まで行う）
+func New(cfg config.SIP) (*OkiSIP, error) {
+	srv := strings.TrimSpace(cfg.Server) // host:port
 	if srv == "" {
-		return nil, fmt.Errorf("OKI_SIP_SERVER not set")
+		return nil, fmt.Errorf("sip.server not set")
 	}
-	user := os.Getenv("OKI_SIP_USER")
-	pass := os.Getenv("OKI_SIP_PASSWORD")
-	if user == "" || pass == "" {
-		return nil, fmt.Errorf("OKI_SIP_USER/OKI_SIP_PASSWORD must be set")
+	if cfg.User == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("sip.user/sip.password must be set")
 	}
-	listen := os.Getenv("OKI_SIP_LISTEN")
-	if listen == "" {
-		listen = ":0"
-	}
-	transport := strings.ToLower(os.Getenv("OKI_SIP_TRANSPORT"))
-	if transport == "" {
-		transport = "udp"
-	}
-	domain := os.Getenv("OKI_SIP_DOMAIN")
+	domain := cfg.Domain
 	if domain == "" {
 		// default to server host
 		host, _, _ := net.SplitHostPort(srv)
@@ -62,23 +72,39 @@ func NewFromEnv() (*OkiSIP, error) {
 		}
 		domain = host
 	}
-	exp := 1800
-	if v := os.Getenv("OKI_SIP_EXPIRES"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			exp = n
-		}
-	}
 
 	o := &OkiSIP{
 		logger:    utils.NewLogrusLogger(log.InfoLevel, "OkiSIP", nil),
-		listen:    listen,
-		transport: transport,
+		listen:    cfg.Listen,
+		transport: strings.ToLower(cfg.Transport),
 		server:    srv,
 		domain:    domain,
-		user:      user,
-		password:  pass,
-		expires:   exp,
+		user:      cfg.User,
+		password:  cfg.Password,
+		expires:   cfg.Expires,
+		maxRing:   time.Duration(cfg.MaxRingSec) * time.Second,
+		maxTalk:   time.Duration(cfg.MaxTalkSec) * time.Second,
+		rules: NumberRules{
+			AllowPrefixes: cfg.DialAllowPrefixes,
+			BlockPrefixes: cfg.DialBlockPrefixes,
+			MaxLength:     cfg.DialMaxLength,
+			DigitsOnly:    cfg.DialDigitsOnly,
+		},
+		mediaIP:       cfg.MediaIP,
+		announcements: map[string]*Audio{},
+		pending:       map[string][]*Call{},
+		calls:         map[string]*Call{},
+		incoming:      map[string]*IncomingCall{},
 	}
+	// 音声は起動時に読んでおき、壊れたファイルはここで気付けるようにする
+	for name, path := range cfg.Announcements {
+		a, err := LoadAudio(name, path)
+		if err != nil {
+			return nil, fmt.Errorf("announcement %s: %w", name, err)
+		}
+		o.announcements[name] = a
+	}
+	o.registerMetrics()
 	return o, nil
 }
 
@@ -97,11 +123,16 @@ func (o *OkiSIP) Start() error {
 	// Handlers (主にログと後始末)
 	u.InviteStateHandler = func(sess *session.Session, req *sip.Request, resp *sip.Response, state session.Status) {
 		o.logger.Infof("InviteState: state=%v dir=%s", state, sess.Direction())
-		// 今回は発信専用。受信はログのみ。
+		if sess.Direction() == session.Outgoing {
+			o.trackOutgoing(sess, req, resp, state)
+		} else {
+			o.trackIncoming(sess, req, state)
+		}
 	}
 
 	u.RegisterStateHandler = func(state account.RegisterState) {
 		o.logger.Infof("Register: user=%s status=%v expires=%v", state.Account.AuthInfo.AuthUser, state.StatusCode, state.Expiration)
+		o.setRegistration(state)
 	}
 
 	// Profile/recipient
@@ -131,23 +162,229 @@ func (o *OkiSIP) Start() error {
 	return nil
 }
 
-func (o *OkiSIP) Invite(number string) error {
+// Announcements は設定された音声の名前を返す
+func (o *OkiSIP) Announcements() []string {
+	var names []string
+	for name := range o.announcements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Invite は number へ発信し、進行状況を追える Call を返す（音声なし）
+func (o *OkiSIP) Invite(number string) (*Call, error) {
+	return o.invite(number, nil, false)
+}
+
+// InvitePlay は number へ発信し、応答したら音声 announcement を流して、流し終えたら切る
+func (o *OkiSIP) InvitePlay(number, announcement string) (*Call, error) {
+	a, ok := o.announcements[announcement]
+	if !ok {
+		return nil, fmt.Errorf("announcement %q not found", announcement)
+	}
+	return o.invite(number, a, false)
+}
+
+// InviteListen は number へ発信し、応答したら音声 announcement を繰り返し流しながら
+// 相手のDTMF（RFC 4733）を Call.Digits() に流す。切るのは呼び出し側。
+func (o *OkiSIP) InviteListen(number, announcement string) (*Call, error) {
+	a, ok := o.announcements[announcement]
+	if !ok {
+		return nil, fmt.Errorf("announcement %q not found", announcement)
+	}
+	return o.invite(number, a, true)
+}
+
+func (o *OkiSIP) invite(number string, audio *Audio, listen bool) (*Call, error) {
 	if o.ua == nil || o.profile == nil {
-		return fmt.Errorf("SIP not initialized")
+		return nil, fmt.Errorf("SIP not initialized")
 	}
 	if strings.TrimSpace(number) == "" {
-		return fmt.Errorf("empty number")
+		return nil, fmt.Errorf("empty number")
+	}
+	if err := o.checkNumber("invite", number); err != nil {
+		return nil, err
 	}
 	// 宛先
 	called, err := parser.ParseUri(fmt.Sprintf("sip:%s@%s", number, o.domain))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// 実送信先（プロキシ）
 	recp := o.recipient
-	// 遅延オファー: SDPはnilでINVITEを送る（相手が200 OKでSDPオファー）
-	go o.ua.Invite(o.profile, called, recp, nil)
-	return nil
+
+	call := newCall(number)
+	// 遅延オファー: 音声なしならSDPはnilでINVITEを送る（相手が200 OKでSDPオファー）。
+	// 音声を流すときは、ACKにSDPアンサーを載せられないので、INVITEにオファーを載せて200 OKのアンサーで宛先を知る。
+	var body *string
+	if audio != nil {
+		ip := o.mediaIP
+		if ip == "" {
+			if ip, err = localIP(o.server); err != nil {
+				return nil, fmt.Errorf("media address: %w", err)
+			}
+		}
+		m, err := newMediaSession(ip, audio)
+		if err != nil {
+			return nil, err
+		}
+		if listen {
+			m.loop, m.onDigit = true, call.pushDigit
+		}
+		sdp := m.offer()
+		body = &sdp
+		call.media = m
+	}
+	o.mu.Lock()
+	o.pending[number] = append(o.pending[number], call)
+	o.mu.Unlock()
+	call.armTimer(o.maxRing, "呼出タイムアウト")
+
+	go func() {
+		if _, err := o.ua.Invite(o.profile, called, recp, body); err != nil {
+			o.logger.Warnf("Invite %s failed: %v", number, err)
+			call.transition(CallFailed, 0, err.Error())
+			o.forget(call)
+		}
+	}()
+	return call, nil
+}
+
+// trackOutgoing は InviteStateHandler の発信イベントを Call に反映する
+func (o *OkiSIP) trackOutgoing(sess *session.Session, req *sip.Request, resp *sip.Response, state session.Status) {
+	if sess.CallID() == nil {
+		return
+	}
+	id := string(*sess.CallID())
+	code, reason := responseStatus(resp)
+
+	o.mu.Lock()
+	call, ok := o.calls[id]
+	if !ok && state == session.InviteSent {
+		// Call-ID確定: 同じ番号で待っている一番古い発信に紐付ける
+		number := requestUser(req)
+		if q := o.pending[number]; len(q) > 0 {
+			call, ok = q[0], true
+			if len(q) == 1 {
+				delete(o.pending, number)
+			} else {
+				o.pending[number] = q[1:]
+			}
+			call.mu.Lock()
+			call.id = id
+			call.sess = sess
+			call.mu.Unlock()
+			o.calls[id] = call
+		}
+	}
+	o.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	cs, known := sessionToCall(state, code, call.State() == CallAnswered)
+	if !known {
+		return
+	}
+	if state == session.Canceled && code == 0 {
+		code, reason = 487, "Request Terminated"
+	}
+	if state == session.Provisional || state == session.EarlyMedia {
+		if call.markProvisional() {
+			go func() { _ = sess.End() }()
+		}
+	}
+	wasAnswered := call.State() == CallAnswered
+	call.transition(cs, code, reason)
+	if cs == CallAnswered && !wasAnswered {
+		if call.markProvisional() {
+			// 呼出中に切断要求が来ていたが先に応答された
+			go func() { _, _ = sess.Bye() }()
+		} else {
+			call.armTimer(o.maxTalk, "通話時間の上限")
+			if call.media != nil {
+				go o.playAndHangup(call, sess, resp)
+			}
+		}
+	}
+	if cs.Final() {
+		o.forget(call)
+	}
+}
+
+// playAndHangup は応答した相手へ音声を流し、終わったら切る
+func (o *OkiSIP) playAndHangup(call *Call, sess *session.Session, resp *sip.Response) {
+	answer := ""
+	if resp != nil && *resp != nil {
+		answer = (*resp).Body()
+	}
+	if strings.TrimSpace(answer) == "" {
+		answer = sess.RemoteSdp()
+	}
+	o.logger.Infof("Playing %s (%s) to %s", call.media.audio.Name, call.media.audio.Duration().Round(time.Second), call.Number)
+	reason := "再生終了"
+	if err := call.media.play(answer); err != nil {
+		if call.State().Final() {
+			return // 再生中に切れた
+		}
+		o.logger.Warnf("Playback to %s failed: %v", call.Number, err)
+		reason = "音声を送れません"
+	}
+	if err := call.Hangup(reason); err != nil && err != ErrCallFinished {
+		o.logger.Warnf("Hangup after playback to %s failed: %v", call.Number, err)
+	}
+}
+
+// ActiveCalls は進行中の発信を古い順に返す
+func (o *OkiSIP) ActiveCalls() []*Call {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []*Call
+	for _, c := range o.calls {
+		out = append(out, c)
+	}
+	for _, q := range o.pending {
+		out = append(out, q...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// Call はCall-IDから進行中の発信を探す
+func (o *OkiSIP) Call(id string) (*Call, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c, ok := o.calls[id]
+	return c, ok
+}
+
+// Hangup はCall-IDで指定した発信を終わらせる（呼出中はCANCEL、通話中はBYE）
+func (o *OkiSIP) Hangup(id, reason string) error {
+	c, ok := o.Call(id)
+	if !ok {
+		return fmt.Errorf("call %s not found", id)
+	}
+	return c.Hangup(reason)
+}
+
+// forget は終了した発信を管理対象から外す
+func (o *OkiSIP) forget(call *Call) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if id := call.ID(); id != "" {
+		delete(o.calls, id)
+	}
+	q := o.pending[call.Number]
+	for i, c := range q {
+		if c == call {
+			o.pending[call.Number] = append(q[:i:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(o.pending[call.Number]) == 0 {
+		delete(o.pending, call.Number)
+	}
 }
 
 func (o *OkiSIP) Shutdown() {
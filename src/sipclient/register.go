@@ -0,0 +1,59 @@
+package sipclient
+
+import (
+	"time"
+
+	"tacnet-odenwakun/src/metrics"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+)
+
+// Registration はREGISTERの最新の結果
+type Registration struct {
+	Registered bool
+	Code       int
+	Reason     string
+	ExpiresAt  time.Time // 登録の期限（未登録ならゼロ値）
+	UpdatedAt  time.Time // 最後に応答を受け取った時刻（未受信ならゼロ値）
+}
+
+// Registration は現在の登録状態を返す
+func (o *OkiSIP) Registration() Registration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.reg
+}
+
+// setRegistration は RegisterStateHandler の結果を記録する
+func (o *OkiSIP) setRegistration(state account.RegisterState) {
+	now := time.Now()
+	r := Registration{Code: int(state.StatusCode), Reason: state.Reason, UpdatedAt: now}
+	if r.Code == 200 && state.Expiration > 0 {
+		r.Registered = true
+		r.ExpiresAt = now.Add(time.Duration(state.Expiration) * time.Second)
+	}
+	o.mu.Lock()
+	o.reg = r
+	o.mu.Unlock()
+
+	metrics.SIPRegisterStatus.Set(float64(r.Code))
+	if r.Registered {
+		metrics.SIPRegistered.Set(1)
+		metrics.SIPRegisterExpiry.Set(float64(r.ExpiresAt.Unix()))
+	} else {
+		metrics.SIPRegistered.Set(0)
+		metrics.SIPRegisterExpiry.Set(0)
+	}
+}
+
+// registerMetrics は取得時に数える通話中のゲージを登録する
+func (o *OkiSIP) registerMetrics() {
+	metrics.NewGaugeFunc("odenwakun_sip_active_outgoing_calls", "Outgoing calls currently in progress.", func() float64 {
+		return float64(len(o.ActiveCalls()))
+	})
+	metrics.NewGaugeFunc("odenwakun_sip_ringing_incoming_calls", "Incoming calls currently ringing.", func() float64 {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return float64(len(o.incoming))
+	})
+}
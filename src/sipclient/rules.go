@@ -0,0 +1,61 @@
+package sipclient
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNumberDenied は発信先が番号ルールで禁止されている
+var ErrNumberDenied = errors.New("number denied by dial rules")
+
+// NumberRules は発信してよい番号の条件。INVITE（と転送）を組み立てる前に確認する。
+type NumberRules struct {
+	AllowPrefixes []string // 空でなければ、いずれかで始まる番号だけ許可
+	BlockPrefixes []string // いずれかで始まる番号は拒否（許可より優先）
+	MaxLength     int      // 0で無制限
+	DigitsOnly    bool     // 0-9 だけ（* # + を認めない）
+}
+
+// Check は number が発信してよい番号かを確かめる。拒否なら ErrNumberDenied を包んだエラーを返す。
+func (r NumberRules) Check(number string) error {
+	deny := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrNumberDenied, fmt.Sprintf(format, args...))
+	}
+	if !Dialable(number) {
+		return deny("%q is not a phone number", number)
+	}
+	if r.DigitsOnly {
+		for _, c := range number {
+			if c < '0' || c > '9' {
+				return deny("%q contains non-digit %q", number, c)
+			}
+		}
+	}
+	if r.MaxLength > 0 && len(number) > r.MaxLength {
+		return deny("%q is longer than %d digits", number, r.MaxLength)
+	}
+	for _, p := range r.BlockPrefixes {
+		if p != "" && strings.HasPrefix(number, p) {
+			return deny("%q matches blocked prefix %q", number, p)
+		}
+	}
+	if len(r.AllowPrefixes) == 0 {
+		return nil
+	}
+	for _, p := range r.AllowPrefixes {
+		if strings.HasPrefix(number, p) {
+			return nil
+		}
+	}
+	return deny("%q does not match any allowed prefix", number)
+}
+
+// checkNumber はルールを確認し、拒否ならログに残す
+func (o *OkiSIP) checkNumber(op, number string) error {
+	if err := o.rules.Check(number); err != nil {
+		o.logger.Warnf("%s denied: %v", op, err)
+		return err
+	}
+	return nil
+}
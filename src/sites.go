@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/config"
+	"tacnet-odenwakun/src/escalation"
+	"tacnet-odenwakun/src/maintenance"
+	"tacnet-odenwakun/src/mikopbx"
+	"tacnet-odenwakun/src/notify"
+	"tacnet-odenwakun/src/oncall"
+	"tacnet-odenwakun/src/routing"
+	"tacnet-odenwakun/src/sipclient"
+	"tacnet-odenwakun/src/watcher"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newSiteWatcher は監視対象のPBX 1台分のクライアントとWatcherを組み立てる
+func newSiteWatcher(ds *discordgo.Session, cfg *config.Config, site config.Site, debug bool) (*watcher.Watcher, error) {
+	cli, err := mikopbx.NewClient(site.BaseURL, site.Login, site.Password)
+	if err != nil {
+		return nil, siteError(site, err)
+	}
+	cli.SetDebug(debug)
+	cli.SetName(site.Name)
+	retry := mikopbx.DefaultRetryPolicy()
+	retry.MaxAttempts = site.RetryMaxAttempts
+	retry.MaxElapsed = time.Duration(site.RetryMaxElapsedSec) * time.Second
+	retry.RetryableStatus = site.RetryStatusCodes
+	cli.SetRetryPolicy(retry)
+
+	var store watcher.Store
+	if site.StateFile != "" {
+		fs, err := watcher.NewJSONFileStore(site.StateFile)
+		if err != nil {
+			return nil, siteError(site, err)
+		}
+		store = fs
+	}
+
+	interval := time.Duration(site.PollIntervalSec) * time.Second
+	w := watcher.New(cli, &watcher.DiscordNotifier{Session: ds, ChannelID: site.ChannelID}, interval, store)
+	w.Site = site.Name
+	w.Debounce = watcher.DebounceConfig{
+		DownConfirm:   cfg.Watcher.DebounceDownPolls,
+		UpConfirm:     cfg.Watcher.DebounceUpPolls,
+		FlapThreshold: cfg.Watcher.FlapThreshold,
+		FlapWindow:    time.Duration(cfg.Watcher.FlapWindowMin) * time.Minute,
+	}
+	states, err := watcher.StateMapFrom(cfg.Watcher.StateHealth)
+	if err != nil {
+		return nil, fmt.Errorf("watcher.state_health: %w", err)
+	}
+	w.States = states
+	w.UnreachableAfter = cfg.Watcher.UnreachableAfter
+	w.Channel = func(channelID string) watcher.Notifier {
+		return &watcher.DiscordNotifier{Session: ds, ChannelID: channelID}
+	}
+	w.Incidents = watcher.IncidentConfig{
+		Mode:     watcher.IncidentMode(strings.ToLower(cfg.Watcher.IncidentMode)),
+		Reminder: time.Duration(cfg.Watcher.IncidentRemindMin) * time.Minute,
+	}
+	return w, nil
+}
+
+// newCDRFeed はサイトのWatcherと同じクライアントで通話履歴を流すフィードを作る
+func newCDRFeed(ds *discordgo.Session, cfg *config.Config, site config.Site, w *watcher.Watcher) *watcher.CDRFeed {
+	channelID := cfg.CDR.ChannelID
+	if channelID == "" {
+		channelID = site.ChannelID
+	}
+	f := watcher.NewCDRFeed(w.Client, &watcher.DiscordNotifier{Session: ds, ChannelID: channelID},
+		time.Duration(cfg.CDR.PollIntervalSec)*time.Second, cfg.CDR.ImportantNumbers)
+	f.Site = site.Name
+	return f
+}
+
+// newOnCallSchedule は当番表を読む（交代の曜日・時刻は保存されていなければ設定の値）
+func newOnCallSchedule(cfg *config.Config) (*oncall.Schedule, error) {
+	day, err := oncall.ParseWeekday(cfg.OnCall.HandoffDay)
+	if err != nil {
+		return nil, err
+	}
+	return oncall.New(cfg.OnCall.File, oncall.Handoff{Day: day, Time: cfg.OnCall.HandoffTime, Timezone: cfg.OnCall.Timezone})
+}
+
+// newMaintenance は /maintenance の期間（ファイル）と設定の定期メンテナンスを読む
+func newMaintenance(cfg *config.Config) (*maintenance.Manager, error) {
+	loc, err := time.LoadLocation(cfg.Maintenance.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []maintenance.Schedule
+	for _, s := range cfg.Maintenance.Schedules {
+		schedules = append(schedules, maintenance.Schedule{
+			Cron:     s.Cron,
+			Duration: time.Duration(s.DurationMin) * time.Minute,
+			Site:     s.Site,
+			IDs:      s.IDs,
+			Reason:   s.Reason,
+		})
+	}
+	m, err := maintenance.New(cfg.Maintenance.File, loc, schedules)
+	if err != nil {
+		return nil, err
+	}
+	m.Mode = maintenance.Mode(strings.ToLower(cfg.Maintenance.Mode))
+	return m, nil
+}
+
+// newRouting は設定のルールから通知の振り分けを作る（ルールが無ければ nil）
+func newRouting(cfg *config.Config) (*routing.Engine, error) {
+	if len(cfg.Routing.Rules) == 0 {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(cfg.Routing.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	var rules []routing.Rule
+	for _, r := range cfg.Routing.Rules {
+		var days []time.Weekday
+		for _, d := range r.Days {
+			wd, err := oncall.ParseWeekday(d)
+			if err != nil {
+				return nil, err
+			}
+			days = append(days, wd)
+		}
+		rules = append(rules, routing.Rule{
+			Name:     r.Name,
+			Sites:    r.Sites,
+			Kinds:    r.Kinds,
+			IDs:      r.IDs,
+			Names:    r.Names,
+			From:     r.From,
+			To:       r.To,
+			Days:     days,
+			Hours:    r.Hours,
+			Channel:  r.ChannelID,
+			Mentions: r.MentionRoles,
+			Drop:     r.Drop,
+		})
+	}
+	return routing.New(rules, loc)
+}
+
+// newNotifyBackends は設定された Discord 以外の送り先を作る（1つもなければ nil）
+func newNotifyBackends(cfg *config.Config) *notify.Fanout {
+	n := cfg.Notify
+	var senders []notify.Sender
+	if n.SlackWebhookURL != "" {
+		senders = append(senders, &notify.Slack{WebhookURL: n.SlackWebhookURL})
+	}
+	if n.WebhookURL != "" {
+		senders = append(senders, &notify.Webhook{URL: n.WebhookURL, Secret: n.WebhookSecret})
+	}
+	if n.NtfyURL != "" {
+		senders = append(senders, &notify.Ntfy{URL: n.NtfyURL, Topic: n.NtfyTopic, Token: n.NtfyToken})
+	}
+	if n.GotifyURL != "" {
+		senders = append(senders, &notify.Gotify{URL: n.GotifyURL, Token: n.GotifyToken})
+	}
+	if n.SMTP.Host != "" {
+		senders = append(senders, &notify.Email{
+			Host:     n.SMTP.Host,
+			Port:     n.SMTP.Port,
+			Username: n.SMTP.Username,
+			Password: n.SMTP.Password,
+			From:     n.SMTP.From,
+			To:       n.SMTP.To,
+			TLS:      strings.ToLower(n.SMTP.TLS),
+		})
+	}
+	if len(senders) == 0 {
+		return nil
+	}
+	f := notify.NewFanout(senders...)
+	f.Min, _ = notify.ParseSeverity(n.MinSeverity) // 検証済み
+	return f
+}
+
+// newEscalator はインシデントが開いたらオンコールへ電話するエスカレーションを作る。
+// 今の当番に最初に電話し、続けて設定のリストの順に電話する。
+func newEscalator(cfg *config.Config, oki *sipclient.OkiSIP, sched *oncall.Schedule) *escalation.Escalator {
+	list := escalation.ParseTargets(cfg.Escalation.OnCall)
+	e := escalation.New(oki, list, cfg.Escalation.Announcement)
+	e.Targets = func() []escalation.Target {
+		sh, ok := sched.Current(time.Now())
+		if !ok {
+			return list
+		}
+		out := []escalation.Target{{Name: sh.Member.Name, Number: sh.Member.Number}}
+		for _, t := range list {
+			if t.Number != sh.Member.Number {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+	if trigger := strings.ToLower(cfg.Escalation.Trigger); trigger != "all" {
+		e.Kinds = []string{trigger}
+	}
+	e.Delay = time.Duration(cfg.Escalation.DelaySec) * time.Second
+	e.Ring = time.Duration(cfg.Escalation.RingSec) * time.Second
+	e.AckWait = time.Duration(cfg.Escalation.AckWaitSec) * time.Second
+	e.Rounds = cfg.Escalation.Rounds
+	return e
+}
+
+// newCallsBoard はサイトの通話中一覧をピン留めメッセージで出すボードを作る
+func newCallsBoard(ds *discordgo.Session, cfg *config.Config, site config.Site, w *watcher.Watcher) *watcher.CallsBoard {
+	channelID := cfg.Calls.ChannelID
+	if channelID == "" {
+		channelID = site.ChannelID
+	}
+	b := watcher.NewCallsBoard(w.Client, &watcher.DiscordNotifier{Session: ds, ChannelID: channelID},
+		time.Duration(cfg.Calls.UpdateIntervalSec)*time.Second)
+	b.Site = site.Name
+	return b
+}
+
+// authenticate は起動時に一度ログインしておく（失敗しても監視は始める）
+func authenticate(ctx context.Context, w *watcher.Watcher) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	err := w.Client.Authenticate(ctx)
+	switch {
+	case err == nil:
+	case errors.Is(err, mikopbx.ErrAuthRejected):
+		// 認証情報の誤りは待っても直らないので目立たせる
+		log.Printf("[ERROR] %sMikoPBX rejected login, check login/password: %v", sitePrefix(w.Site), err)
+	default:
+		// PBXが落ちている/未起動でもプロセスは落とさない
+		log.Printf("[WARN] %sMikoPBX authenticate failed (will retry on demand): %v", sitePrefix(w.Site), err)
+	}
+}
+
+func siteError(site config.Site, err error) error {
+	if site.Name == "" {
+		return err
+	}
+	return fmt.Errorf("site %s: %w", site.Name, err)
+}
+
+func sitePrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "[" + name + "] "
+}
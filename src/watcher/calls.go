@@ -0,0 +1,201 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/mikopbx"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// 通話一覧のピン留めメッセージ用の補助インターフェース
+type boardNotifier interface {
+	PostEmbed(content string, embed *discordgo.MessageEmbed) (messageID string, err error)
+	EditEmbed(messageID string, embed *discordgo.MessageEmbed) error
+	Pin(messageID string) error
+	// FindPinned は自分がピン留めした、タイトルが prefix で始まるメッセージを探す（無ければ空）
+	FindPinned(prefix string) (messageID string, err error)
+}
+
+func (d *DiscordNotifier) Pin(messageID string) error {
+	if d.Session == nil || d.ChannelID == "" {
+		return fmt.Errorf("discord notifier not configured")
+	}
+	return d.Session.ChannelMessagePin(d.ChannelID, messageID)
+}
+
+func (d *DiscordNotifier) FindPinned(prefix string) (string, error) {
+	if d.Session == nil || d.ChannelID == "" {
+		return "", fmt.Errorf("discord notifier not configured")
+	}
+	msgs, err := d.Session.ChannelMessagesPinned(d.ChannelID)
+	if err != nil {
+		return "", err
+	}
+	var self string
+	if d.Session.State != nil && d.Session.State.User != nil {
+		self = d.Session.State.User.ID
+	}
+	for _, m := range msgs {
+		if m.Author == nil || m.Author.ID != self {
+			continue
+		}
+		for _, e := range m.Embeds {
+			if strings.HasPrefix(e.Title, prefix) {
+				return m.ID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// CallsBoard は進行中の通話の一覧をピン留めしたメッセージに書き続ける
+type CallsBoard struct {
+	Site     string
+	Client   *mikopbx.Client
+	Notifier boardNotifier
+	Interval time.Duration
+
+	messageID string
+}
+
+func NewCallsBoard(client *mikopbx.Client, notifier boardNotifier, interval time.Duration) *CallsBoard {
+	return &CallsBoard{Client: client, Notifier: notifier, Interval: interval}
+}
+
+func (b *CallsBoard) Run(ctx context.Context) {
+	// 再起動のたびにピン留めが増えないよう、前回のメッセージがあれば使い回す
+	if id, err := b.Notifier.FindPinned(siteTitle(b.Site, activeCallsTitle)); err != nil {
+		b.logf("calls board: find pinned: %v", err)
+	} else {
+		b.messageID = id
+	}
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+	b.update(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.update(ctx)
+		}
+	}
+}
+
+func (b *CallsBoard) update(ctx context.Context) {
+	now := time.Now()
+	chans, err := b.Client.GetActiveChannels(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	var embed *discordgo.MessageEmbed
+	if err != nil {
+		b.logf("calls board: fetch error: %v", err)
+		embed = ActiveCallsEmbed(b.Site, nil, now)
+		embed.Description = "⚠️ 通話一覧を取得できません: " + truncate(errorText(err), 200)
+		embed.Color = 0x95A5A6 // grey
+	} else {
+		embed = ActiveCallsEmbed(b.Site, chans, now)
+	}
+
+	if b.messageID != "" {
+		err := b.Notifier.EditEmbed(b.messageID, embed)
+		if err == nil {
+			return
+		}
+		if !isNotFound(err) {
+			b.logf("calls board: edit error: %v", err)
+			return
+		}
+		// ピン留めが消されていたら作り直す
+		b.messageID = ""
+	}
+	id, err := b.Notifier.PostEmbed("", embed)
+	if err != nil {
+		b.logf("calls board: post error: %v", err)
+		return
+	}
+	b.messageID = id
+	if err := b.Notifier.Pin(id); err != nil {
+		b.logf("calls board: pin error (needs Manage Messages permission): %v", err)
+	}
+}
+
+func (b *CallsBoard) logf(format string, args ...any) {
+	if b.Site != "" {
+		format = "[" + b.Site + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+const activeCallsTitle = "📞 通話中の電話"
+
+// ActiveCallsEmbed は進行中の通話の一覧を表すEmbed（/calls とピン留めで共通）
+func ActiveCallsEmbed(site string, chans []mikopbx.ActiveChannel, now time.Time) *discordgo.MessageEmbed {
+	sort.Slice(chans, func(i, j int) bool { return chans[i].StartTime().Before(chans[j].StartTime()) })
+	embed := &discordgo.MessageEmbed{
+		Title:     siteTitle(site, fmt.Sprintf("%s（%d件）", activeCallsTitle, len(chans))),
+		Color:     0x3498DB, // blue
+		Timestamp: now.Format(time.RFC3339),
+		Footer:    &discordgo.MessageEmbedFooter{Text: "最終更新"},
+	}
+	if len(chans) == 0 {
+		embed.Description = "通話中の電話はありません"
+		embed.Color = 0x95A5A6 // grey
+		return embed
+	}
+	const maxLines = 30
+	var lines []string
+	for i, ch := range chans {
+		if i == maxLines {
+			lines = append(lines, fmt.Sprintf("…ほか %d 件", len(chans)-maxLines))
+			break
+		}
+		lines = append(lines, activeCallLine(ch, now))
+	}
+	embed.Description = strings.Join(lines, "\n")
+	return embed
+}
+
+func activeCallLine(ch mikopbx.ActiveChannel, now time.Time) string {
+	emoji, state := "🔔", "呼出中"
+	since := ch.StartTime()
+	if ch.Answered() {
+		emoji, state = "🗣️", "通話中"
+		if t := ch.AnsweredAt(); !t.IsZero() {
+			since = t
+		}
+	} else if ch.State != "" && !strings.EqualFold(ch.State, "Ringing") && !strings.EqualFold(ch.State, "Ring") {
+		state = ch.State
+	}
+	elapsed := "?"
+	if !since.IsZero() {
+		elapsed = formatDuration(now.Sub(since))
+	}
+	return fmt.Sprintf("%s %s ⇄ %s — %s %s", emoji, partyLabel(ch.SrcNum, ch.SrcChan), partyLabel(ch.DstNum, ch.DstChan), state, elapsed)
+}
+
+// partyLabel は番号、無ければチャンネル名を返す
+func partyLabel(num, channel string) string {
+	switch {
+	case num != "":
+		return num
+	case channel != "":
+		return "`" + channel + "`"
+	}
+	return "不明"
+}
+
+// isNotFound はDiscordのAPIが404（メッセージが消された等）を返したかを判定する
+func isNotFound(err error) bool {
+	var rest *discordgo.RESTError
+	return errors.As(err, &rest) && rest.Response != nil && rest.Response.StatusCode == http.StatusNotFound
+}
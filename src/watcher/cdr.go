@@ -0,0 +1,238 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/mikopbx"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CDRFeed は終わった通話を通話履歴（CDR）から拾ってチャンネルに流す
+type CDRFeed struct {
+	Site      string
+	Client    *mikopbx.Client
+	Notifier  Notifier
+	Interval  time.Duration
+	Important map[string]bool // 強調する番号（発信元・着信先のどちらかに含まれれば強調）
+	// Lookback は毎回さかのぼって取得する幅。長い通話はこれより前に始まっていると拾えない。
+	Lookback time.Duration
+
+	posted map[string]time.Time // linkedid -> 流した（または起動時に既読にした）時刻
+	names  map[string]string    // 番号 -> 内線名（取れなければ空）
+	primed bool
+}
+
+// cdrSettle は最後のレッグが終わってから投稿するまで待つ時間（転送先のレッグが後から記録されるため）
+const cdrSettle = 15 * time.Second
+
+func NewCDRFeed(client *mikopbx.Client, notifier Notifier, interval time.Duration, important []string) *CDRFeed {
+	f := &CDRFeed{
+		Client:    client,
+		Notifier:  notifier,
+		Interval:  interval,
+		Important: map[string]bool{},
+		Lookback:  time.Hour,
+		posted:    map[string]time.Time{},
+		names:     map[string]string{},
+	}
+	for _, n := range important {
+		f.Important[strings.TrimSpace(n)] = true
+	}
+	return f
+}
+
+func (f *CDRFeed) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+	f.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.poll(ctx)
+		}
+	}
+}
+
+func (f *CDRFeed) poll(ctx context.Context) {
+	now := time.Now()
+	recs, err := f.Client.GetCDR(ctx, now.Add(-f.Lookback), 500)
+	if err != nil {
+		if ctx.Err() == nil {
+			f.logf("CDR fetch error: %v", err)
+		}
+		return
+	}
+	for _, call := range groupCDR(recs) {
+		if _, done := f.posted[call.linkedID]; done || !call.finished(now) {
+			continue
+		}
+		f.posted[call.linkedID] = now
+		// 起動時点で終わっていた通話は流さない
+		if f.primed {
+			f.post(ctx, call)
+		}
+	}
+	f.primed = true
+	for id, t := range f.posted {
+		if now.Sub(t) > 2*f.Lookback {
+			delete(f.posted, id)
+		}
+	}
+}
+
+// cdrCall は linkedid でまとめた1通話
+type cdrCall struct {
+	linkedID    string
+	start       time.Time
+	lastEnd     time.Time
+	open        bool // まだ終わっていないレッグがある
+	caller      string
+	callee      string
+	did         string
+	disposition string
+	billsec     int
+}
+
+func (c cdrCall) finished(now time.Time) bool {
+	return !c.open && !c.lastEnd.IsZero() && now.Sub(c.lastEnd) >= cdrSettle
+}
+
+func (c cdrCall) answered() bool { return c.disposition == mikopbx.DispositionAnswered }
+
+// groupCDR はCDRの行を通話ごとにまとめ、開始の古い順に返す
+func groupCDR(recs []mikopbx.CDRRecord) []cdrCall {
+	byID := map[string][]mikopbx.CDRRecord{}
+	for _, r := range recs {
+		id := r.LinkedID
+		if id == "" {
+			id = fmt.Sprintf("id:%d", r.ID)
+		}
+		byID[id] = append(byID[id], r)
+	}
+	calls := make([]cdrCall, 0, len(byID))
+	for id, rows := range byID {
+		sort.Slice(rows, func(i, j int) bool { return rows[i].StartTime().Before(rows[j].StartTime()) })
+		c := cdrCall{
+			linkedID:    id,
+			start:       rows[0].StartTime(),
+			caller:      rows[0].SrcNum,
+			callee:      rows[0].DstNum,
+			did:         rows[0].DID,
+			disposition: strings.ToUpper(rows[0].Disposition),
+		}
+		for _, r := range rows {
+			end := r.EndedAt()
+			if end.IsZero() {
+				c.open = true
+			} else if end.After(c.lastEnd) {
+				c.lastEnd = end
+			}
+			// どこかのレッグで応答していれば応答扱い（着信者は応答した内線）
+			if strings.EqualFold(r.Disposition, mikopbx.DispositionAnswered) && !c.answered() {
+				c.disposition = mikopbx.DispositionAnswered
+				c.callee = r.DstNum
+			}
+			if strings.EqualFold(r.Disposition, mikopbx.DispositionAnswered) && int(r.BillSec) > c.billsec {
+				c.billsec = int(r.BillSec)
+			}
+		}
+		calls = append(calls, c)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].start.Before(calls[j].start) })
+	return calls
+}
+
+func (f *CDRFeed) post(ctx context.Context, c cdrCall) {
+	if f.Notifier == nil {
+		return
+	}
+	important := f.Important[c.caller] || f.Important[c.callee] || (c.did != "" && f.Important[c.did])
+
+	title, content, color := "📞 通話", "", 0x2ECC71 // green
+	if !c.answered() {
+		title, content, color = "📵 不在着信", "出られなかった電話があるよ", 0xE74C3C // red
+	}
+	if important {
+		title = "⭐ " + title
+		content = strings.TrimSpace("⭐ 重要な番号の通話です " + content)
+		color = 0xF1C40F // gold
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: siteTitle(f.Site, fmt.Sprintf("%s: %s → %s", title, c.caller, c.callee)),
+		Color: color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "発信者", Value: f.numberLabel(ctx, c.caller), Inline: true},
+			{Name: "着信者", Value: f.numberLabel(ctx, c.callee), Inline: true},
+			{Name: "結果", Value: dispositionLabel(c.disposition), Inline: true},
+			{Name: "通話時間", Value: formatDuration(time.Duration(c.billsec) * time.Second), Inline: true},
+			{Name: "開始", Value: c.start.Format("2006-01-02 15:04:05"), Inline: true},
+		},
+		Timestamp: c.lastEnd.Format(time.RFC3339),
+	}
+	if c.did != "" && c.did != c.callee {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "着信番号(DID)", Value: c.did, Inline: true})
+	}
+	if en, ok := f.Notifier.(embedNotifier); ok {
+		if err := en.NotifyEmbed(content, embed); err != nil {
+			f.logf("CDR post error: %v", err)
+		}
+		return
+	}
+	text := strings.TrimSpace(content + "\n" + embed.Title)
+	for _, fl := range embed.Fields {
+		text += fmt.Sprintf("\n%s: %s", fl.Name, fl.Value)
+	}
+	if err := f.Notifier.Notify(text); err != nil {
+		f.logf("CDR post error: %v", err)
+	}
+}
+
+// numberLabel は内線なら「名前(番号)」、それ以外は番号を返す
+func (f *CDRFeed) numberLabel(ctx context.Context, num string) string {
+	if num == "" {
+		return "不明"
+	}
+	name, ok := f.names[num]
+	if !ok {
+		var err error
+		name, err = f.Client.GetPeerName(ctx, num)
+		if err != nil {
+			return num // 一時的な失敗はキャッシュしない
+		}
+		f.names[num] = name
+	}
+	if name != "" {
+		return fmt.Sprintf("%s(%s)", name, num)
+	}
+	return num
+}
+
+func dispositionLabel(d string) string {
+	switch d {
+	case mikopbx.DispositionAnswered:
+		return "✅ 応答"
+	case mikopbx.DispositionNoAnswer:
+		return "📵 不在"
+	case mikopbx.DispositionBusy:
+		return "🚫 話し中"
+	case mikopbx.DispositionFailed:
+		return "❌ 失敗"
+	}
+	return d
+}
+
+func (f *CDRFeed) logf(format string, args ...any) {
+	if f.Site != "" {
+		format = "[" + f.Site + "] " + format
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,142 @@
+package watcher
+
+import (
+	"fmt"
+	"time"
+)
+
+// DebounceConfig はState変化の確定条件とフラッピング判定の設定
+type DebounceConfig struct {
+	DownConfirm   int           // 連続何回のポーリングで悪化（Down/Degraded）を確定するか（1で即時）
+	UpConfirm     int           // 連続何回のポーリングで回復を確定するか（1で即時）
+	FlapThreshold int           // FlapWindow 内の変化回数がこれを超えたらフラッピング扱い（0で無効）
+	FlapWindow    time.Duration // フラッピング判定の時間窓
+}
+
+func DefaultDebounceConfig() DebounceConfig {
+	return DebounceConfig{DownConfirm: 1, UpConfirm: 1, FlapThreshold: 0, FlapWindow: 10 * time.Minute}
+}
+
+type flapEvent int
+
+const (
+	flapNone  flapEvent = iota
+	flapStart           // フラッピング状態に入った
+	flapEnd             // フラッピングが収まった
+)
+
+// ID単位の状態機械
+type tracker struct {
+	confirmed string      // 確定済み（通知済み）のstate。"" は未検出
+	lastRaw   string      // 直近に観測した生のstate
+	pending   string      // 確定待ちのstate
+	count     int         // pending を連続で観測した回数
+	flips     []time.Time // 生のstateの区分(Health)が変わった時刻
+	flapping  bool
+}
+
+func newTracker(initial string) *tracker {
+	return &tracker{confirmed: initial, lastRaw: initial}
+}
+
+// observe は1回分の観測を反映し、フラッピングの開始/終了を返す
+func (t *tracker) observe(now time.Time, raw string, cfg DebounceConfig, classify func(string) Health) flapEvent {
+	if classify(raw) != classify(t.lastRaw) {
+		t.flips = append(t.flips, now)
+	}
+	t.lastRaw = raw
+	// 窓の外に出た反転は捨てる
+	cutoff := now.Add(-cfg.FlapWindow)
+	i := 0
+	for i < len(t.flips) && t.flips[i].Before(cutoff) {
+		i++
+	}
+	t.flips = t.flips[i:]
+
+	if t.flapping {
+		if len(t.flips) > 0 {
+			return flapNone
+		}
+		t.flapping = false
+		t.confirmed = raw
+		t.pending, t.count = "", 0
+		return flapEnd
+	}
+	if cfg.FlapThreshold > 0 && len(t.flips) > cfg.FlapThreshold {
+		t.flapping = true
+		t.pending, t.count = "", 0
+		return flapStart
+	}
+
+	if classify(raw) == classify(t.confirmed) {
+		// 同じ区分内の変化（例: REJECTED→UNREACHABLE）は確定を待たずに追従
+		t.confirmed = raw
+		t.pending, t.count = "", 0
+		return flapNone
+	}
+	if t.count > 0 && classify(raw) == classify(t.pending) {
+		t.count++
+	} else {
+		t.count = 1
+	}
+	t.pending = raw
+	need := cfg.DownConfirm
+	if classify(raw) > classify(t.confirmed) {
+		need = cfg.UpConfirm
+	}
+	if t.count >= need {
+		t.confirmed = raw
+		t.pending, t.count = "", 0
+	}
+	return flapNone
+}
+
+// settle は生の観測値をデバウンスし、確定済みのstateマップとフラッピング通知を返す。
+// last は前回の確定済みstate（フラッピング終了時はここも更新して重複通知を防ぐ）。
+func (w *Watcher) settle(track map[string]*tracker, last, raw map[string]string,
+	classify func(string) Health, label func(string) string) (map[string]string, changeSet) {
+	now := time.Now()
+	ids := map[string]struct{}{}
+	for id := range raw {
+		ids[id] = struct{}{}
+	}
+	for id := range last {
+		ids[id] = struct{}{}
+	}
+	for id := range track {
+		ids[id] = struct{}{}
+	}
+
+	cur := map[string]string{}
+	var flaps changeSet
+	for id := range ids {
+		tr, ok := track[id]
+		if !ok {
+			tr = newTracker(last[id])
+			track[id] = tr
+		}
+		state := raw[id]
+		switch tr.observe(now, state, w.Debounce, classify) {
+		case flapStart:
+			// 悪化扱い（メンションの対象）にする
+			flaps.add(stateChange{id: id, label: label(id), from: last[id], to: state, fromH: Healthy, toH: Down,
+				text: fmt.Sprintf("%s: フラッピング中（%d分以内に%d回変化）。落ち着くまで個別通知を止めます",
+					label(id), int(w.Debounce.FlapWindow.Minutes()), len(tr.flips))})
+		case flapEnd:
+			flaps.add(stateChange{id: id, label: label(id), from: last[id], to: state, fromH: classify(last[id]), toH: classify(state),
+				text: fmt.Sprintf("%s: フラッピングが収まりました（現在: %s）", label(id), stateLabel(state))})
+			if state == "" {
+				delete(last, id)
+			} else {
+				last[id] = state
+			}
+		}
+		if tr.confirmed != "" {
+			cur[id] = tr.confirmed
+		} else if !tr.flapping && tr.count == 0 && state == "" {
+			// 消えたまま落ち着いたIDは追跡をやめる
+			delete(track, id)
+		}
+	}
+	return cur, flaps
+}
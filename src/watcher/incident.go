@@ -0,0 +1,258 @@
+package watcher
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// IncidentMode はダウンの続報の出し方
+type IncidentMode string
+
+const (
+	IncidentOff    IncidentMode = "off"    // 変化のたびに新しいメッセージを出す
+	IncidentEdit   IncidentMode = "edit"   // 最初のメッセージを書き換える
+	IncidentThread IncidentMode = "thread" // 最初のメッセージにスレッドを作って続報を流す
+)
+
+type IncidentConfig struct {
+	Mode     IncidentMode
+	Reminder time.Duration // 「まだ復旧していません」を出す間隔（0で出さない）
+}
+
+// Escalator はインシデントを電話でも知らせる（Watcher.Escalation が nil なら電話しない）
+type Escalator interface {
+	// Escalate は新しいインシデントで呼ばれる。post で経過をインシデントのスレッドへ書き込める。
+	Escalate(key, kind, summary string, post func(text string))
+	// Resolve はインシデントが全て復旧したら呼ばれる
+	Resolve(key string)
+}
+
+func DefaultIncidentConfig() IncidentConfig {
+	return IncidentConfig{Mode: IncidentEdit, Reminder: 10 * time.Minute}
+}
+
+// Incident はダウンした端末・プロバイダを1つのメッセージにまとめたもの。
+// 開いている間に新たにダウンしたものは同じインシデントに加え、全て復旧したら閉じる。
+type Incident struct {
+	Kind       string                     `json:"kind"` // "端末" / "プロバイダ"
+	MessageID  string                     `json:"message_id"`
+	OpenedAt   time.Time                  `json:"opened_at"`
+	LastNotice time.Time                  `json:"last_notice"` // 最後に続報を出した時刻
+	Members    map[string]*IncidentMember `json:"members"`
+}
+
+// IncidentMember はインシデント中の1つのID
+type IncidentMember struct {
+	Label    string     `json:"label"`
+	From     string     `json:"from"` // ダウン直前のstate
+	State    string     `json:"state"`
+	DownAt   time.Time  `json:"down_at"`
+	UpAt     *time.Time `json:"up_at,omitempty"`
+	upEvent  bool       // 今回の更新で復旧した
+	isNew    bool       // 今回の更新でダウンした
+	mentions []string   // 今回ダウンしたときにメンションするロール（ルーティングのルール）
+}
+
+func (inc *Incident) open() []*IncidentMember {
+	var out []*IncidentMember
+	for _, m := range inc.Members {
+		if m.UpAt == nil {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// routeIncidents はダウンとその復旧をインシデントに振り分け、それ以外の変化を返す
+func (w *Watcher) routeIncidents(key, kind string, cs changeSet, now time.Time) changeSet {
+	in, ok := w.Notifier.(incidentNotifier)
+	if !ok || w.Incidents.Mode == IncidentOff {
+		return cs
+	}
+	var rest changeSet
+	inc := w.incidents[key]
+	changed := false
+	for _, ch := range cs.changes {
+		var m *IncidentMember
+		if inc != nil {
+			m = inc.Members[ch.id]
+		}
+		switch {
+		case m != nil && m.UpAt == nil && ch.toH == Down:
+			// ダウン中のstate違い（REJECTED → UNREACHABLE など）は表示だけ更新
+			m.State = ch.to
+			changed = true
+		case m != nil && m.UpAt == nil:
+			t := now
+			m.UpAt, m.State, m.upEvent = &t, ch.to, true
+			changed = true
+		case ch.toH == Down:
+			if inc == nil {
+				inc = &Incident{Kind: kind, OpenedAt: now, LastNotice: now, Members: map[string]*IncidentMember{}}
+				w.incidents[key] = inc
+			}
+			inc.Members[ch.id] = &IncidentMember{Label: ch.label, From: ch.from, State: ch.to, DownAt: now, isNew: true, mentions: ch.mentions}
+			changed = true
+		default:
+			rest.add(ch)
+		}
+	}
+	if changed {
+		w.updateIncident(in, key, inc, now)
+	}
+	return rest
+}
+
+// updateIncident は今回の変化をメッセージに反映し、全て復旧していれば閉じる
+func (w *Watcher) updateIncident(in incidentNotifier, key string, inc *Incident, now time.Time) {
+	var downs, ups, mentions []string
+	for _, m := range inc.Members {
+		if m.isNew {
+			downs = append(downs, m.Label)
+			for _, id := range m.mentions {
+				if !slices.Contains(mentions, id) {
+					mentions = append(mentions, id)
+				}
+			}
+		}
+		if m.upEvent {
+			ups = append(ups, fmt.Sprintf("%s（停止 %s）", m.Label, formatDuration(m.UpAt.Sub(m.DownAt))))
+		}
+		m.isNew, m.upEvent, m.mentions = false, false, nil
+	}
+	closed := len(inc.open()) == 0
+	embed := w.incidentEmbed(inc, now)
+
+	if inc.MessageID == "" {
+		id, err := in.PostEmbed(roleMentions(mentions, w.alertContent(w.pickContent(true, false))), embed)
+		if err != nil {
+			w.logf("incident post error: %v", err)
+		}
+		inc.MessageID = id
+		if !closed {
+			w.escalate(in, key, inc, downs)
+		}
+	} else {
+		if err := in.EditEmbed(inc.MessageID, embed); err != nil {
+			w.logf("incident edit error: %v", err)
+		}
+		if w.Incidents.Mode == IncidentThread {
+			var lines []string
+			if len(downs) > 0 {
+				sort.Strings(downs)
+				lines = append(lines, "🔴 ダウン: "+strings.Join(downs, ", "))
+			}
+			if len(ups) > 0 {
+				sort.Strings(ups)
+				lines = append(lines, "🟢 復旧: "+strings.Join(ups, ", "))
+			}
+			if closed {
+				lines = append(lines, fmt.Sprintf("✅ すべて復旧しました（発生から %s）", formatDuration(now.Sub(inc.OpenedAt))))
+			}
+			w.threadReply(in, inc, roleMentions(mentions, strings.Join(lines, "\n")))
+		}
+	}
+	inc.LastNotice = now
+	if closed {
+		delete(w.incidents, key)
+		if w.Escalation != nil {
+			w.Escalation.Resolve(w.Site + "/" + key)
+		}
+	}
+}
+
+// escalate は開いたインシデントを Escalation に渡す。経過はインシデントのスレッドに書く。
+func (w *Watcher) escalate(in incidentNotifier, key string, inc *Incident, downs []string) {
+	if w.Escalation == nil || inc.MessageID == "" {
+		return
+	}
+	sort.Strings(downs)
+	summary := w.title(fmt.Sprintf("%sのダウン: %s", inc.Kind, strings.Join(downs, ", ")))
+	messageID, name := inc.MessageID, w.threadName(inc)
+	w.Escalation.Escalate(w.Site+"/"+key, key, summary, func(text string) {
+		if err := in.ThreadReply(messageID, name, text); err != nil {
+			w.logf("escalation thread error: %v", err)
+		}
+	})
+}
+
+// remindIncidents は開いたままのインシデントに「まだ復旧していません」を出す
+func (w *Watcher) remindIncidents(now time.Time) {
+	in, ok := w.Notifier.(incidentNotifier)
+	if !ok || w.Incidents.Reminder <= 0 {
+		return
+	}
+	for _, inc := range w.incidents {
+		if inc.MessageID == "" || now.Sub(inc.LastNotice) < w.Incidents.Reminder {
+			continue
+		}
+		inc.LastNotice = now
+		if err := in.EditEmbed(inc.MessageID, w.incidentEmbed(inc, now)); err != nil {
+			w.logf("incident edit error: %v", err)
+		}
+		if w.Incidents.Mode == IncidentThread {
+			var labels []string
+			for _, m := range inc.open() {
+				labels = append(labels, m.Label)
+			}
+			sort.Strings(labels)
+			w.threadReply(in, inc, fmt.Sprintf("⏳ まだ復旧していません（発生から %s）: %s",
+				formatDuration(now.Sub(inc.OpenedAt)), strings.Join(labels, ", ")))
+		}
+	}
+}
+
+func (w *Watcher) threadReply(in incidentNotifier, inc *Incident, text string) {
+	if err := in.ThreadReply(inc.MessageID, w.threadName(inc), text); err != nil {
+		w.logf("incident thread error: %v", err)
+	}
+}
+
+func (w *Watcher) threadName(inc *Incident) string {
+	return truncate(w.title(fmt.Sprintf("%sダウン %s", inc.Kind, inc.OpenedAt.Local().Format("01/02 15:04"))), 100)
+}
+
+// incidentEmbed はインシデントの現状を表すEmbed
+func (w *Watcher) incidentEmbed(inc *Incident, now time.Time) *discordgo.MessageEmbed {
+	ids := make([]string, 0, len(inc.Members))
+	for id := range inc.Members {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return inc.Members[ids[i]].Label < inc.Members[ids[j]].Label })
+
+	var lines []string
+	for _, id := range ids {
+		m := inc.Members[id]
+		if m.UpAt == nil {
+			lines = append(lines, fmt.Sprintf("🔴 %s: %s → %s（%s〜、%s）", m.Label, stateLabel(m.From), stateLabel(m.State),
+				m.DownAt.Local().Format("15:04"), formatDuration(now.Sub(m.DownAt))))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %s: 復旧 %s（停止 %s）", w.States.Classify(m.State).emoji(), m.Label,
+				stateLabel(m.State), formatDuration(m.UpAt.Sub(m.DownAt))))
+		}
+	}
+
+	open := len(inc.open())
+	embed := &discordgo.MessageEmbed{
+		Description: "- " + strings.Join(lines, "\n- "),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "発生", Value: inc.OpenedAt.Local().Format("2006-01-02 15:04:05"), Inline: true},
+		},
+		Timestamp: now.Format(time.RFC3339),
+	}
+	if open > 0 {
+		embed.Title = w.title(fmt.Sprintf("🚨 %sのダウン（%d/%d 件が停止中）", inc.Kind, open, len(inc.Members)))
+		embed.Color = 0xE74C3C // red
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "継続時間", Value: formatDuration(now.Sub(inc.OpenedAt)), Inline: true})
+	} else {
+		embed.Title = w.title(fmt.Sprintf("✅ %sのダウン（復旧済み）", inc.Kind))
+		embed.Color = 0x2ECC71 // green
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "停止時間", Value: formatDuration(now.Sub(inc.OpenedAt)), Inline: true})
+	}
+	return embed
+}
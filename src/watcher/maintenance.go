@@ -0,0 +1,173 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/maintenance"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maintLog はメンテナンス中に止めた変化（終わったらまとめて通知する）。再起動を跨いでは残らない。
+type maintLog struct {
+	win   maintenance.Window
+	lines []string
+}
+
+// inMaintenance は id（空ならPBX自体）がメンテナンス中ならその期間を返す
+func (w *Watcher) inMaintenance(id string, now time.Time) (maintenance.Window, bool) {
+	if w.Maintenance == nil {
+		return maintenance.Window{}, false
+	}
+	return w.Maintenance.Active(w.Site, id, now)
+}
+
+// holdMaintenance はメンテナンス中のIDの変化を取り除いて記録し、残りを返す。
+// key のインシデントで停止中のID（メンテナンス前からのダウン）の続報は止めない。
+// quiet モードでは止めた変化をメンションなしで流す（インシデント・電話にはしない）。
+func (w *Watcher) holdMaintenance(key, title string, cs changeSet, now time.Time) changeSet {
+	if w.Maintenance == nil || len(cs.changes) == 0 {
+		return cs
+	}
+	inc := w.incidents[key]
+	var rest, held changeSet
+	for _, ch := range cs.changes {
+		win, ok := w.inMaintenance(ch.id, now)
+		if ok && inc != nil {
+			if m := inc.Members[ch.id]; m != nil && m.UpAt == nil {
+				ok = false
+			}
+		}
+		if !ok {
+			rest.add(ch)
+			continue
+		}
+		held.add(ch)
+		w.noteMaintenance(win, ch.line(), now)
+	}
+	if len(held.changes) > 0 {
+		w.logf("maintenance: held %d change(s)", len(held.changes))
+		if w.Maintenance.Mode == maintenance.Quiet {
+			w.postChanges("🛠 "+title+"（メンテナンス中）", "メンテナンス中だよ", held, "", 0x95A5A6) // gray
+		}
+	}
+	return rest
+}
+
+// maintLog は期間 win の記録を返す（無ければ記録を始める）
+func (w *Watcher) maintLog(win maintenance.Window) *maintLog {
+	key := win.Key()
+	if l, ok := w.maint[key]; ok {
+		return l
+	}
+	w.logf("maintenance %s started (%s, until %s)", win.Label(), win.Scope(), win.End.Format(time.RFC3339))
+	l := &maintLog{win: win}
+	w.maint[key] = l
+	return l
+}
+
+func (w *Watcher) noteMaintenance(win maintenance.Window, line string, now time.Time) {
+	l := w.maintLog(win)
+	l.lines = append(l.lines, now.Local().Format("15:04 ")+line)
+}
+
+// trackMaintenance は始まったメンテナンスの記録を始め、終わったものは期間中の変化をまとめて通知する
+func (w *Watcher) trackMaintenance(now time.Time) {
+	if w.Maintenance == nil {
+		return
+	}
+	for _, win := range w.Maintenance.ActiveFor(w.Site, now) {
+		w.maintLog(win)
+	}
+	for key, l := range w.maint {
+		if w.Maintenance.IsActive(key, now) {
+			continue
+		}
+		delete(w.maint, key)
+		w.logf("maintenance %s ended (%d change(s))", l.win.Label(), len(l.lines))
+		w.notifyMaintenanceEnd(l, now)
+	}
+}
+
+// notifyMaintenanceEnd は期間中の変化と、終わった時点でまだ止まっているものを通知する
+func (w *Watcher) notifyMaintenanceEnd(l *maintLog, now time.Time) {
+	end := l.win.End
+	if now.Before(end) {
+		end = now // 途中で止めた
+	}
+	embed := &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("🛠 メンテナンス %s 終了", l.win.Label()),
+		Color: 0x3498DB, // blue
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "期間", Value: fmt.Sprintf("%s 〜 %s（%s）", l.win.Start.Local().Format("01-02 15:04"),
+				end.Local().Format("01-02 15:04"), formatDuration(end.Sub(l.win.Start))), Inline: true},
+			{Name: "対象", Value: l.win.Scope(), Inline: true},
+		},
+		Timestamp: now.Format(time.RFC3339),
+	}
+	if l.win.Reason != "" {
+		embed.Description = l.win.Reason
+	}
+
+	changes := "なし"
+	if n := len(l.lines); n > 0 {
+		const maxLines = 20
+		shown := l.lines
+		if n > maxLines {
+			shown = shown[:maxLines]
+		}
+		changes = strings.Join(shown, "\n")
+		if n > maxLines {
+			changes += fmt.Sprintf("\n…ほか %d 件", n-maxLines)
+		}
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:  fmt.Sprintf("期間中の変化（%d 件）", len(l.lines)),
+		Value: truncate(changes, 1000),
+	})
+
+	content := "メンテナンスおわり！"
+	if down := w.downIn(l.win); len(down) > 0 {
+		embed.Color = 0xE67E22 // orange
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("🔴 終了時点で停止中（%d 件）", len(down)),
+			Value: truncate(strings.Join(down, "\n"), 1000),
+		})
+		content = w.alertContent("メンテナンスは終わったけど、まだ止まってるのがあるよ…")
+	} else {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "終了時点", Value: "✅ すべて正常"})
+	}
+	w.notifyEmbed(content, embed)
+}
+
+// downIn は win の対象で、最後に確定したstateが Down のもの（とPBX自体の到達不能）を返す
+func (w *Watcher) downIn(win maintenance.Window) []string {
+	var out []string
+	if w.down.reported && win.Covers(w.Site, "") {
+		out = append(out, "MikoPBX（到達不能）")
+	}
+	var lines []string
+	for id, state := range w.lastPeer {
+		if win.Covers(w.Site, id) && w.States.Classify(state) == Down {
+			lines = append(lines, fmt.Sprintf("端末 %s: %s", w.cachedPeerLabel(id), stateLabel(state)))
+		}
+	}
+	for id, state := range w.lastProv {
+		if win.Covers(w.Site, id) && w.States.Classify(state) == Down {
+			lines = append(lines, fmt.Sprintf("プロバイダ %s: %s", id, stateLabel(state)))
+		}
+	}
+	sort.Strings(lines)
+	return append(out, lines...)
+}
+
+// cachedPeerLabel は問い合わせずに分かる範囲で「名前(ID)」を返す
+func (w *Watcher) cachedPeerLabel(id string) string {
+	if name := w.peerNameCache[id]; name != "" {
+		return fmt.Sprintf("%s(%s)", name, id)
+	}
+	return id
+}
@@ -0,0 +1,31 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/metrics"
+)
+
+// observePoll は取得1回分の所要時間と成否を記録する
+func (w *Watcher) observePoll(endpoint string, start time.Time, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	metrics.PollDuration.Observe(time.Since(start).Seconds(), w.Site, endpoint)
+	if err != nil {
+		metrics.PollFailures.Inc(w.Site, endpoint)
+	}
+}
+
+// exportStates は取得したstateをゲージに反映する（消えたIDの系列は残さない）
+func (w *Watcher) exportStates(health, state *metrics.GaugeVec, cur map[string]string) {
+	health.DeleteMatching("site", w.Site)
+	state.DeleteMatching("site", w.Site)
+	for id, s := range cur {
+		health.Set(float64(w.States.Classify(s)), w.Site, id)
+		state.Set(1, w.Site, id, strings.ToUpper(s))
+	}
+}
@@ -0,0 +1,144 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"tacnet-odenwakun/src/notify"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Mirror は Notifier（とルーティングのチャンネル）へ送る通知を backends にも流すようにする
+func (w *Watcher) Mirror(backends *notify.Fanout) {
+	w.Notifier = &MirrorNotifier{Notifier: w.Notifier, Site: w.Site, Backends: backends}
+	if ch := w.Channel; ch != nil {
+		w.Channel = func(channelID string) Notifier {
+			return &MirrorNotifier{Notifier: ch(channelID), Site: w.Site, Backends: backends}
+		}
+	}
+}
+
+// MirrorNotifier は Discord へ送った通知を Backends にも流す。
+// 書き換えはタイトルが変わったとき（ダウンの追加・復旧など）だけ流し、「まだ復旧していません」の書き換えは流さない。
+type MirrorNotifier struct {
+	Notifier // Discord
+	Site     string
+	Backends *notify.Fanout
+
+	mu   sync.Mutex
+	sent map[string]notify.Message // メッセージID -> 最後に流したもの
+}
+
+func (m *MirrorNotifier) Notify(text string) error {
+	title, body, _ := strings.Cut(text, "\n")
+	m.Backends.Publish(notify.Message{Title: stripMentions(title), Body: body, Source: m.Site})
+	return m.Notifier.Notify(text)
+}
+
+func (m *MirrorNotifier) NotifyEmbed(content string, embed *discordgo.MessageEmbed) error {
+	m.Backends.Publish(messageFromEmbed(m.Site, content, embed))
+	if en, ok := m.Notifier.(embedNotifier); ok {
+		return en.NotifyEmbed(content, embed)
+	}
+	return m.Notifier.Notify(content + "\n" + embed.Title + "\n" + embed.Description)
+}
+
+func (m *MirrorNotifier) PostEmbed(content string, embed *discordgo.MessageEmbed) (string, error) {
+	in, ok := m.Notifier.(incidentNotifier)
+	if !ok {
+		return "", fmt.Errorf("notifier does not support incidents")
+	}
+	msg := messageFromEmbed(m.Site, content, embed)
+	m.Backends.Publish(msg)
+	id, err := in.PostEmbed(content, embed)
+	if id != "" {
+		m.mu.Lock()
+		if m.sent == nil {
+			m.sent = map[string]notify.Message{}
+		}
+		m.sent[id] = msg
+		m.mu.Unlock()
+	}
+	return id, err
+}
+
+func (m *MirrorNotifier) EditEmbed(messageID string, embed *discordgo.MessageEmbed) error {
+	in, ok := m.Notifier.(incidentNotifier)
+	if !ok {
+		return fmt.Errorf("notifier does not support incidents")
+	}
+	msg := messageFromEmbed(m.Site, "", embed)
+	m.mu.Lock()
+	prev, known := m.sent[messageID]
+	changed := known && prev.Title != msg.Title
+	switch {
+	case changed && msg.Severity == notify.Resolved:
+		delete(m.sent, messageID) // 閉じたインシデントはもう書き換えない
+	case changed:
+		m.sent[messageID] = msg
+	}
+	m.mu.Unlock()
+	if changed {
+		m.Backends.Publish(msg)
+	}
+	return in.EditEmbed(messageID, embed)
+}
+
+func (m *MirrorNotifier) ThreadReply(messageID, threadName, text string) error {
+	in, ok := m.Notifier.(incidentNotifier)
+	if !ok {
+		return fmt.Errorf("notifier does not support incidents")
+	}
+	m.mu.Lock()
+	sev := m.sent[messageID].Severity
+	m.mu.Unlock()
+	m.Backends.Publish(notify.Message{Title: threadName, Body: stripMentions(text), Severity: sev, Source: m.Site})
+	return in.ThreadReply(messageID, threadName, text)
+}
+
+// messageFromEmbed は通知のEmbedを送り先に依存しない形にする（重大度は色から決める）
+func messageFromEmbed(site, content string, embed *discordgo.MessageEmbed) notify.Message {
+	msg := notify.Message{
+		Title:    embed.Title,
+		Summary:  stripMentions(content),
+		Body:     embed.Description,
+		Severity: severityOf(embed.Color),
+		Source:   site,
+		Time:     time.Now(),
+	}
+	if t, err := time.Parse(time.RFC3339, embed.Timestamp); err == nil {
+		msg.Time = t
+	}
+	for _, f := range embed.Fields {
+		msg.Fields = append(msg.Fields, notify.Field{Name: f.Name, Value: f.Value, Inline: f.Inline})
+	}
+	if embed.Footer != nil {
+		msg.Footer = embed.Footer.Text
+	}
+	return msg
+}
+
+// severityOf は通知の色（chooseColor などの配色）を重大度にする
+func severityOf(color int) notify.Severity {
+	switch color {
+	case 0xE74C3C: // red
+		return notify.Critical
+	case 0xE67E22, 0xF1C40F: // orange, yellow
+		return notify.Warning
+	case 0x2ECC71: // green
+		return notify.Resolved
+	default:
+		return notify.Info
+	}
+}
+
+var mentionRe = regexp.MustCompile(`<(@[!&]?|#)\d+>\s*`)
+
+// stripMentions は Discord のメンションを取り除く（他の送り先では意味がない）
+func stripMentions(s string) string {
+	return strings.TrimSpace(mentionRe.ReplaceAllString(s, ""))
+}
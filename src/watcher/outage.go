@@ -0,0 +1,225 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tacnet-odenwakun/src/maintenance"
+	"tacnet-odenwakun/src/metrics"
+	"tacnet-odenwakun/src/mikopbx"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// outage はMikoPBX自体に到達できない状態（ポーリング失敗の継続）を追跡する
+type outage struct {
+	failures   int       // 連続失敗回数
+	since      time.Time // 最初に失敗した時刻
+	lastErr    error
+	reported   bool      // 到達不能を通知済み
+	messageID  string    // 通知したメッセージ（インシデントとして書き換える）
+	lastNotice time.Time // 最後に続報を出した時刻
+	held       string    // メンテナンス中で通知を止めた場合、その期間の Key
+}
+
+// pollFailed は取得失敗を記録し、UnreachableAfter 回続いたら到達不能として通知する。
+// 通知済みなら Incidents.Reminder ごとに続報を出す。
+func (w *Watcher) pollFailed(err error) {
+	metrics.PBXUp.Set(0, w.Site)
+	now := time.Now()
+	if w.down.failures == 0 && !w.down.reported {
+		w.down.since = now
+	}
+	w.down.failures++
+	w.down.lastErr = err
+	if w.down.reported {
+		if _, ok := w.inMaintenance("", now); w.down.held == "" || ok {
+			w.remindOutage(now)
+			return
+		}
+		// メンテナンスが終わってもまだ繋がらない: ここで改めて通知する
+		w.down.held = ""
+	} else if w.down.failures < max(w.UnreachableAfter, 1) {
+		return
+	}
+	w.down.reported = true
+	w.down.lastNotice = now
+	if win, ok := w.inMaintenance("", now); ok {
+		w.down.held = win.Key()
+		w.logf("MikoPBX unreachable during maintenance %s: %v", win.Label(), err)
+		w.noteMaintenance(win, "🔴 MikoPBXに到達できなくなりました", now)
+		if w.Maintenance.Mode == maintenance.Quiet {
+			w.notifyEmbed("メンテナンス中だよ", w.outageEmbed(now))
+		}
+		w.saveState()
+		return
+	}
+	w.logf("[ALERT] MikoPBX unreachable since %s: %v", w.down.since.Format(time.RFC3339), err)
+	content := w.alertContent("PBXに繋がらない…！")
+	if in, ok := w.Notifier.(incidentNotifier); ok && w.Incidents.Mode != IncidentOff {
+		embed := w.outageEmbed(now)
+		embed.Title = w.title(embed.Title)
+		id, perr := in.PostEmbed(content, embed)
+		if perr != nil {
+			w.logf("incident post error: %v", perr)
+		}
+		w.down.messageID = id
+	} else {
+		w.notifyEmbed(content, w.outageEmbed(now))
+	}
+	w.saveState()
+}
+
+// remindOutage は到達不能が続いていれば元のメッセージを書き換え、スレッドモードなら続報を出す
+func (w *Watcher) remindOutage(now time.Time) {
+	in, ok := w.Notifier.(incidentNotifier)
+	if !ok || w.down.messageID == "" || w.Incidents.Reminder <= 0 || now.Sub(w.down.lastNotice) < w.Incidents.Reminder {
+		return
+	}
+	w.down.lastNotice = now
+	embed := w.outageEmbed(now)
+	embed.Title = w.title(embed.Title)
+	if err := in.EditEmbed(w.down.messageID, embed); err != nil {
+		w.logf("incident edit error: %v", err)
+	}
+	if w.Incidents.Mode == IncidentThread {
+		w.outageThreadReply(in, w.down, fmt.Sprintf("⏳ まだ繋がりません（発生から %s、連続失敗 %d回）",
+			formatDuration(now.Sub(w.down.since)), w.down.failures))
+	}
+}
+
+// pollSucceeded は到達不能を通知済みなら復旧と停止時間を通知し、失敗の記録をリセットする
+func (w *Watcher) pollSucceeded() {
+	metrics.PBXUp.Set(1, w.Site)
+	down := w.down
+	w.down = outage{}
+	if !down.reported {
+		return
+	}
+	now := time.Now()
+	downtime := now.Sub(down.since)
+	w.logf("MikoPBX reachable again after %s", downtime.Round(time.Second))
+	embed := &discordgo.MessageEmbed{
+		Title:       "✅ MikoPBX 復旧",
+		Description: "MikoPBXへのポーリングが再開しました",
+		Color:       0x2ECC71, // green
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "停止時間", Value: formatDuration(downtime), Inline: true},
+			{Name: "期間", Value: fmt.Sprintf("%s 〜 %s",
+				down.since.Local().Format("01-02 15:04:05"), now.Local().Format("01-02 15:04:05")), Inline: true},
+		},
+		Timestamp: now.Format(time.RFC3339),
+	}
+	if down.held != "" {
+		// メンテナンス中に止めた到達不能の復旧（期間が続いていれば記録に残す）
+		if l, ok := w.maint[down.held]; ok {
+			l.lines = append(l.lines, now.Local().Format("15:04 ")+fmt.Sprintf("🟢 MikoPBXに再び繋がりました（停止 %s）", formatDuration(downtime)))
+		}
+		if w.Maintenance != nil && w.Maintenance.Mode == maintenance.Quiet {
+			w.notifyEmbed("メンテナンス中だよ", embed)
+		}
+		return
+	}
+	in, ok := w.Notifier.(incidentNotifier)
+	if !ok || down.messageID == "" || w.Incidents.Mode == IncidentOff {
+		w.notifyEmbed("PBXが戻ってきた！", embed)
+		return
+	}
+	// 到達不能のメッセージ自体を復旧済みに書き換える
+	embed.Title = w.title(embed.Title)
+	if err := in.EditEmbed(down.messageID, embed); err != nil {
+		w.logf("incident edit error: %v", err)
+	}
+	if w.Incidents.Mode == IncidentThread {
+		w.outageThreadReply(in, down, fmt.Sprintf("✅ 復旧しました（停止 %s）", formatDuration(downtime)))
+	}
+}
+
+func (w *Watcher) outageEmbed(now time.Time) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title:       "🚨 MikoPBX に到達できません",
+		Description: fetchErrorSummary(w.down.lastErr),
+		Color:       0xE74C3C, // red
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "発生", Value: w.down.since.Local().Format("2006-01-02 15:04:05"), Inline: true},
+			{Name: "継続時間", Value: formatDuration(now.Sub(w.down.since)), Inline: true},
+			{Name: "連続失敗", Value: fmt.Sprintf("%d回", w.down.failures), Inline: true},
+			{Name: "エラー", Value: "```" + truncate(errorText(w.down.lastErr), 1000) + "```"},
+		},
+		Timestamp: now.Format(time.RFC3339),
+	}
+}
+
+func (w *Watcher) outageThreadReply(in incidentNotifier, down outage, text string) {
+	name := w.title("MikoPBX到達不能 " + down.since.Local().Format("01/02 15:04"))
+	if err := in.ThreadReply(down.messageID, truncate(name, 100), text); err != nil {
+		w.logf("incident thread error: %v", err)
+	}
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return "（再起動前から継続）"
+	}
+	return err.Error()
+}
+
+// notifyEmbed はEmbed（非対応ならテキスト）で1通送る
+func (w *Watcher) notifyEmbed(content string, embed *discordgo.MessageEmbed) {
+	if w.Notifier == nil {
+		return
+	}
+	embed.Title = w.title(embed.Title)
+	if en, ok := w.Notifier.(embedNotifier); ok {
+		_ = en.NotifyEmbed(content, embed)
+		return
+	}
+	text := content + "\n" + embed.Title
+	if embed.Description != "" {
+		text += "\n" + embed.Description
+	}
+	for _, f := range embed.Fields {
+		text += fmt.Sprintf("\n%s: %s", f.Name, f.Value)
+	}
+	_ = w.Notifier.Notify(text)
+}
+
+// fetchErrorSummary は失敗の種類を一言で表す
+func fetchErrorSummary(err error) string {
+	switch {
+	case errors.Is(err, mikopbx.ErrAuthRejected):
+		return "ログインが拒否されています（認証情報を確認してください）"
+	case errors.Is(err, mikopbx.ErrUnreachable):
+		return "接続できない、またはエラー応答が続いています"
+	case errors.Is(err, mikopbx.ErrBadResponse):
+		return "想定外の応答が返っています"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "応答がタイムアウトしました"
+	default:
+		return "ポーリングに失敗しています"
+	}
+}
+
+// formatDuration は「1時間23分」「45秒」のように表す
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%d時間%d分", h, m)
+	case m > 0:
+		return fmt.Sprintf("%d分%d秒", m, s)
+	default:
+		return fmt.Sprintf("%d秒", s)
+	}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"tacnet-odenwakun/src/routing"
+)
+
+// applyRouting は Routing のルールで変化を振り分け、既定のチャンネルへ出すものを返す。
+// drop のルールに当たったものは捨て、別チャンネル行きはここで送る（インシデント・電話にはしない）。
+// メンションだけのルールに当たったものは印を付けて返す。
+func (w *Watcher) applyRouting(kind, title string, cs changeSet, now time.Time) changeSet {
+	if w.Routing == nil || len(cs.changes) == 0 {
+		return cs
+	}
+	var rest changeSet
+	groups := map[string]*changeSet{}
+	var channels []string
+	for _, ch := range cs.changes {
+		r, i := w.Routing.Match(w.routeEvent(kind, ch, now))
+		switch {
+		case i < 0:
+			rest.add(ch)
+		case r.Drop:
+			w.logf("routing: rule %s dropped %s", w.Routing.Name(i), ch.line())
+		case r.Channel != "" && w.Channel != nil:
+			ch.mentions = r.Mentions
+			g, ok := groups[r.Channel]
+			if !ok {
+				g = &changeSet{}
+				groups[r.Channel] = g
+				channels = append(channels, r.Channel)
+			}
+			g.add(ch)
+		default:
+			ch.mentions = r.Mentions
+			rest.add(ch)
+		}
+	}
+	for _, id := range channels {
+		g := *groups[id]
+		w.notifyChangesTo(w.Channel(id), title, w.pickContent(g.worsened(), g.hasUp), g, "")
+	}
+	return rest
+}
+
+// routeEvent は変化をルールに当てる形にする
+func (w *Watcher) routeEvent(kind string, ch stateChange, now time.Time) routing.Event {
+	name := ch.id
+	if kind == "peer" {
+		name = w.peerNameCache[ch.id]
+	}
+	return w.RoutingEvent(kind, ch.id, name, ch.from, ch.to, now)
+}
+
+// RoutingEvent は kind（"peer" / "provider"）の id が from から to へ変わったときにルールへ渡すイベントを返す（dry-run 用）
+func (w *Watcher) RoutingEvent(kind, id, name, from, to string, at time.Time) routing.Event {
+	return routing.Event{
+		Site:       w.Site,
+		Kind:       kind,
+		ID:         id,
+		Name:       name,
+		From:       from,
+		To:         to,
+		FromHealth: w.States.Classify(from).String(),
+		ToHealth:   w.States.Classify(to).String(),
+		Time:       at,
+	}
+}
+
+// roleMentions は本文の先頭にロールへのメンションを付ける
+func roleMentions(roles []string, content string) string {
+	if len(roles) == 0 {
+		return content
+	}
+	var b strings.Builder
+	for _, id := range roles {
+		fmt.Fprintf(&b, "<@&%s> ", id)
+	}
+	return b.String() + content
+}
@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Health はMikoPBXのstateを通知用に分類したもの
+type Health int
+
+const (
+	Down     Health = iota // 停止・到達不能など
+	Degraded               // 応答はあるが品質低下（LAGGED など）
+	Healthy                // 正常
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	default:
+		return "down"
+	}
+}
+
+func (h Health) emoji() string {
+	switch h {
+	case Healthy:
+		return "🟢"
+	case Degraded:
+		return "🟡"
+	default:
+		return "🔴"
+	}
+}
+
+// MikoPBXが返すstate（端末: getPeersStatuses / プロバイダ: getRegistry）
+const (
+	StateOK          = "OK"
+	StateLagged      = "LAGGED"
+	StateUnreachable = "UNREACHABLE"
+	StateUnknown     = "UNKNOWN"
+	StateRejected    = "REJECTED"
+	StateOff         = "OFF"
+)
+
+// 表示用の文言（未知のstateは生の値をそのまま出す）
+var stateWords = map[string]string{
+	StateOK:          "オンライン",
+	StateLagged:      "遅延",
+	StateUnreachable: "到達不能",
+	StateUnknown:     "不明",
+	StateRejected:    "登録拒否",
+	StateOff:         "停止",
+}
+
+// StateMap は state -> Health の対応表（大文字で保持）
+type StateMap map[string]Health
+
+func DefaultStateMap() StateMap {
+	return StateMap{
+		StateOK:          Healthy,
+		StateLagged:      Degraded,
+		StateUnreachable: Down,
+		StateUnknown:     Down,
+		StateRejected:    Down,
+		StateOff:         Down,
+	}
+}
+
+// Classify は state を分類する。表にないstateと未検出("")は Down 扱い。
+func (m StateMap) Classify(state string) Health {
+	if h, ok := m[strings.ToUpper(state)]; ok {
+		return h
+	}
+	return Down
+}
+
+// StateMapFrom は STATE -> "healthy|degraded|down" の対応で既定の表を上書きする
+func StateMapFrom(overrides map[string]string) (StateMap, error) {
+	m := DefaultStateMap()
+	for k, v := range overrides {
+		h, err := parseHealth(v)
+		if err != nil {
+			return nil, fmt.Errorf("state %s: %w", k, err)
+		}
+		m[strings.ToUpper(strings.TrimSpace(k))] = h
+	}
+	return m, nil
+}
+
+func parseHealth(s string) (Health, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "healthy", "up", "ok":
+		return Healthy, nil
+	case "degraded", "warn":
+		return Degraded, nil
+	case "down":
+		return Down, nil
+	}
+	return Down, fmt.Errorf("unknown health %q", s)
+}
+
+// stateLabel は「オンライン(OK)」のような表示を返す
+func stateLabel(state string) string {
+	if state == "" {
+		return "未検出"
+	}
+	if w, ok := stateWords[strings.ToUpper(state)]; ok {
+		return fmt.Sprintf("%s(%s)", w, strings.ToUpper(state))
+	}
+	return state
+}
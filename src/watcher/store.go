@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot は再起動を跨いで保持する監視状態
+type Snapshot struct {
+	Peers     map[string]string `json:"peers"`      // id -> state
+	Providers map[string]string `json:"providers"`  // id -> state
+	PeerNames map[string]string `json:"peer_names"` // id -> name
+	SavedAt   time.Time         `json:"saved_at"`
+	// UnreachableSince はPBX到達不能を通知済みのまま保存した場合の発生時刻
+	UnreachableSince     *time.Time `json:"unreachable_since,omitempty"`
+	UnreachableMessageID string     `json:"unreachable_message_id,omitempty"`
+	// Incidents は開いたままのインシデント（再起動後も同じメッセージを更新する）
+	Incidents map[string]*Incident `json:"incidents,omitempty"`
+}
+
+// Store は Snapshot の永続化先（差し替え可能）
+type Store interface {
+	// Load は保存済みのSnapshotを返す。未保存なら (nil, nil)。
+	Load() (*Snapshot, error)
+	Save(s *Snapshot) error
+}
+
+// JSONFileStore はローカルのJSONファイルに状態を保存する
+type JSONFileStore struct {
+	Path string
+}
+
+func NewJSONFileStore(path string) (*JSONFileStore, error) {
+	if path == "" {
+		return nil, errors.New("state file path is required")
+	}
+	return &JSONFileStore{Path: path}, nil
+}
+
+func (s *JSONFileStore) Load() (*Snapshot, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", s.Path, err)
+	}
+	return &snap, nil
+}
+
+func (s *JSONFileStore) Save(snap *Snapshot) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 書き込み途中で落ちても壊れないよう一時ファイル経由で置き換える
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -2,14 +2,20 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"tacnet-odenwakun/src/maintenance"
+	"tacnet-odenwakun/src/metrics"
 	"tacnet-odenwakun/src/mikopbx"
+	"tacnet-odenwakun/src/routing"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -18,10 +24,12 @@ import (
 type ChangeDirection int
 
 const (
-	DirNone  ChangeDirection = iota
-	DirUp                    // オンライン化のみ
-	DirDown                  // オフライン化のみ
-	DirMixed                 // 上り下りの混在
+	DirNone     ChangeDirection = iota
+	DirUp                       // 改善のみ
+	DirDown                     // Downへの悪化のみ
+	DirMixed                    // 上り下りの混在
+	DirDegraded                 // 品質低下（Degradedへの悪化）のみ
+	DirLateral                  // 同じ区分内のstate変化のみ（例: REJECTED → UNREACHABLE）
 )
 
 type Notifier interface {
@@ -57,25 +65,115 @@ func (d *DiscordNotifier) NotifyEmbed(content string, embed *discordgo.MessageEm
 	return err
 }
 
+// インシデント（後から書き換える・スレッドで続報する）用の補助インターフェース
+type incidentNotifier interface {
+	PostEmbed(content string, embed *discordgo.MessageEmbed) (messageID string, err error)
+	EditEmbed(messageID string, embed *discordgo.MessageEmbed) error
+	// ThreadReply は messageID のメッセージに付いたスレッド（無ければ作る）へ投稿する
+	ThreadReply(messageID, threadName, text string) error
+}
+
+func (d *DiscordNotifier) PostEmbed(content string, embed *discordgo.MessageEmbed) (string, error) {
+	if d.Session == nil || d.ChannelID == "" {
+		return "", fmt.Errorf("discord notifier not configured")
+	}
+	m, err := d.Session.ChannelMessageSendComplex(d.ChannelID, &discordgo.MessageSend{
+		Content: content,
+		Embeds:  []*discordgo.MessageEmbed{embed},
+	})
+	if err != nil {
+		return "", err
+	}
+	return m.ID, nil
+}
+
+func (d *DiscordNotifier) EditEmbed(messageID string, embed *discordgo.MessageEmbed) error {
+	if d.Session == nil || d.ChannelID == "" {
+		return fmt.Errorf("discord notifier not configured")
+	}
+	embeds := []*discordgo.MessageEmbed{embed}
+	_, err := d.Session.ChannelMessageEditComplex(&discordgo.MessageEdit{ID: messageID, Channel: d.ChannelID, Embeds: &embeds})
+	return err
+}
+
+func (d *DiscordNotifier) ThreadReply(messageID, threadName, text string) error {
+	if d.Session == nil || d.ChannelID == "" {
+		return fmt.Errorf("discord notifier not configured")
+	}
+	// メッセージから作ったスレッドのIDは元メッセージのIDと同じ
+	if _, err := d.Session.ChannelMessageSend(messageID, text); err == nil {
+		return nil
+	}
+	if _, err := d.Session.MessageThreadStart(d.ChannelID, messageID, threadName, 1440); err != nil {
+		return fmt.Errorf("start thread: %w", err)
+	}
+	_, err := d.Session.ChannelMessageSend(messageID, text)
+	return err
+}
+
 type Watcher struct {
+	Site     string // 複数PBXを監視する場合のサイト名（通知とログに付ける。空なら付けない）
 	Client   *mikopbx.Client
 	Notifier Notifier
 	Interval time.Duration
+	Store    Store // nil ならメモリのみ
+	Debounce DebounceConfig
+	States   StateMap // state -> healthy/degraded/down
+	// UnreachableAfter 回続けてポーリングに失敗したら「PBX到達不能」を通知する
+	UnreachableAfter int
+	Incidents        IncidentConfig // ダウンを1通にまとめて続報を書き換え/スレッドで出す
+	Escalation       Escalator      // 新しいインシデントで電話する（nil なら電話しない）
+	// OnCall は今のオンコール当番のDiscordユーザーIDを返す（ダウンの通知でメンションする。nil/空ならしない）
+	OnCall func() string
+	// Maintenance はメンテナンス中の端末・プロバイダ・PBXの通知を止め、終わったらまとめて出す（nil なら止めない）
+	Maintenance *maintenance.Manager
+	// Routing は端末・プロバイダの変化ごとに送り先・メンション・破棄を決める（nil なら全て Notifier へ）
+	Routing *routing.Engine
+	// Channel はルールで指定したチャンネルへ送る Notifier を返す（nil ならチャンネル指定のルールは既定のチャンネルへ）
+	Channel func(channelID string) Notifier
 	// in-memory state
 	lastPeer      map[string]string // id -> state
 	lastProv      map[string]string // id -> state
 	peerNameCache map[string]string // id -> name
+	peerTrack     map[string]*tracker
+	provTrack     map[string]*tracker
+	down          outage
+	incidents     map[string]*Incident // "peer" / "provider" -> 開いているインシデント
+	maint         map[string]*maintLog // メンテナンスの Key -> 期間中に止めた変化
+	statusMu      sync.Mutex
+	status        PollStatus
+	// 保存済み状態から復元した場合の前回保存時刻（初回ポーリングで停止中の変更をまとめて通知）
+	resumedFrom *time.Time
 }
 
-func New(client *mikopbx.Client, notifier Notifier, interval time.Duration) *Watcher {
-	return &Watcher{
-		Client:        client,
-		Notifier:      notifier,
-		Interval:      interval,
-		lastPeer:      map[string]string{},
-		lastProv:      map[string]string{},
-		peerNameCache: map[string]string{},
+// New は Watcher を生成する。store が指定されていれば前回の状態を読み込む。
+func New(client *mikopbx.Client, notifier Notifier, interval time.Duration, store Store) *Watcher {
+	w := &Watcher{
+		Client:           client,
+		Notifier:         notifier,
+		Interval:         interval,
+		Store:            store,
+		Debounce:         DefaultDebounceConfig(),
+		States:           DefaultStateMap(),
+		UnreachableAfter: 2,
+		Incidents:        DefaultIncidentConfig(),
+		incidents:        map[string]*Incident{},
+		maint:            map[string]*maintLog{},
+		lastPeer:         map[string]string{},
+		lastProv:         map[string]string{},
+		peerNameCache:    map[string]string{},
+		peerTrack:        map[string]*tracker{},
+		provTrack:        map[string]*tracker{},
 	}
+	if store != nil {
+		snap, err := store.Load()
+		if err != nil {
+			log.Printf("state load error (starting fresh): %v", err)
+		} else if snap != nil {
+			w.restore(snap)
+		}
+	}
+	return w
 }
 
 func (w *Watcher) Run(ctx context.Context) {
@@ -83,196 +181,439 @@ func (w *Watcher) Run(ctx context.Context) {
 	defer ticker.Stop()
 
 	// initial fetch
-	w.checkOnce()
+	w.checkOnce(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			w.checkOnce()
+			w.checkOnce(ctx)
 		}
 	}
 }
 
-func (w *Watcher) checkOnce() {
-	peers, err := w.Client.GetPeersStatuses()
-	if err != nil {
-		log.Printf("peers fetch error: %v", err)
-	} else {
-		w.diffAndNotifyPeers(peers)
+func (w *Watcher) checkOnce(ctx context.Context) {
+	if w.resumedFrom != nil {
+		w.checkResumed(ctx)
+		return
 	}
 
-	regs, err := w.Client.GetRegistry()
-	if err != nil {
-		log.Printf("registry fetch error: %v", err)
+	start := time.Now()
+	peers, perr := w.Client.GetPeersStatuses(ctx)
+	w.observePoll("getPeersStatuses", start, perr)
+	if perr != nil {
+		w.logFetchError("peers", perr)
+	} else {
+		w.exportStates(metrics.PeerHealth, metrics.PeerState, peerStates(peers))
+	}
+	start = time.Now()
+	regs, rerr := w.Client.GetRegistry(ctx)
+	w.observePoll("getRegistry", start, rerr)
+	if rerr != nil {
+		w.logFetchError("registry", rerr)
 	} else {
+		w.exportStates(metrics.ProviderHealth, metrics.ProviderState, providerStates(regs))
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	w.markPoll(errors.Join(perr, rerr))
+	// 復旧通知は停止中の変更より先に出す
+	if err := errors.Join(perr, rerr); err != nil {
+		w.pollFailed(err)
+	} else {
+		w.pollSucceeded()
+	}
+
+	if perr == nil {
+		w.diffAndNotifyPeers(ctx, peers)
+	}
+	if rerr == nil {
 		w.diffAndNotifyProviders(regs)
 	}
+	w.remindIncidents(time.Now())
+	w.trackMaintenance(time.Now())
+	w.saveState()
 }
 
-func (w *Watcher) diffAndNotifyPeers(peers mikopbx.PeersStatusesResponse) {
-	// Build current map
-	cur := map[string]string{}
-	for _, p := range peers.Data {
-		cur[p.ID] = p.State
-	}
-	// First snapshot: just store and return (no spam)
-	if len(w.lastPeer) == 0 {
-		w.lastPeer = cur
+// PollStatus は直近のポーリングの結果（ヘルスチェック用）
+type PollStatus struct {
+	LastAttempt time.Time // 最後にポーリングを終えた時刻（成否問わず）
+	LastSuccess time.Time // 最後に両方の取得に成功した時刻
+	LastError   string
+}
+
+// PollStatus は直近のポーリングの結果を返す（他のgoroutineから呼んでよい）
+func (w *Watcher) PollStatus() PollStatus {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	return w.status
+}
+
+func (w *Watcher) markPoll(err error) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	now := time.Now()
+	w.status.LastAttempt = now
+	if err != nil {
+		w.status.LastError = err.Error()
 		return
 	}
-	// Compare online/offline transitions only
-	var changes []string
-	hasUp := false
-	hasDown := false
-	for id, state := range cur {
-		prev, ok := w.lastPeer[id]
-		if !ok {
-			// Newly seen: notify only if it is ONLINE and previously unseen treated as OFFLINE
-			if isPeerOnline(state) {
-				label := w.resolvePeerLabel(id)
-				changes = append(changes, fmt.Sprintf("端末 %s: オフライン → オンライン", label))
-				hasUp = true
-			}
-			continue
-		}
-		if isPeerOnline(prev) != isPeerOnline(state) {
-			from := "オフライン"
-			to := "オンライン"
-			if isPeerOnline(prev) && !isPeerOnline(state) {
-				from, to = "オンライン", "オフライン"
-				hasDown = true
-			} else {
-				hasUp = true
-			}
-			label := w.resolvePeerLabel(id)
-			changes = append(changes, fmt.Sprintf("端末 %s: %s → %s", label, from, to))
+	w.status.LastSuccess = now
+	w.status.LastError = ""
+}
+
+// logFetchError は取得失敗を種別ごとに記録する（停止中のキャンセルは黙る）
+func (w *Watcher) logFetchError(what string, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+	case errors.Is(err, mikopbx.ErrAuthRejected):
+		w.logf("[ERROR] %s fetch: MikoPBX rejected credentials: %v", what, err)
+	case errors.Is(err, mikopbx.ErrUnreachable):
+		w.logf("[WARN] %s fetch: MikoPBX unreachable: %v", what, err)
+	default:
+		w.logf("%s fetch error: %v", what, err)
+	}
+}
+
+// logf はサイト名を付けてログを出す
+func (w *Watcher) logf(format string, args ...any) {
+	if w.Site != "" {
+		format = "[" + w.Site + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// title はサイト名を付けた通知タイトルを返す
+func (w *Watcher) title(t string) string { return siteTitle(w.Site, t) }
+
+func siteTitle(site, t string) string {
+	if site == "" {
+		return t
+	}
+	return fmt.Sprintf("[%s] %s", site, t)
+}
+
+// 再起動後の初回: 停止中に起きた変更を1通にまとめて通知する
+func (w *Watcher) checkResumed(ctx context.Context) {
+	start := time.Now()
+	peers, err := w.Client.GetPeersStatuses(ctx)
+	w.observePoll("getPeersStatuses", start, err)
+	if err != nil {
+		w.logFetchError("peers", err)
+		if ctx.Err() == nil {
+			w.markPoll(err)
+			w.pollFailed(err)
 		}
+		return
 	}
-	// disappeared peers: treat as going OFFLINE
-	for id, prev := range w.lastPeer {
-		if _, ok := cur[id]; !ok {
-			if isPeerOnline(prev) {
-				label := w.resolvePeerLabel(id)
-				changes = append(changes, fmt.Sprintf("端末 %s: オンライン → オフライン", label))
-				hasDown = true
-			}
+	start = time.Now()
+	regs, err := w.Client.GetRegistry(ctx)
+	w.observePoll("getRegistry", start, err)
+	if err != nil {
+		w.logFetchError("registry", err)
+		if ctx.Err() == nil {
+			w.markPoll(err)
+			w.pollFailed(err)
 		}
+		return
 	}
-	if len(changes) > 0 && w.Notifier != nil {
-		sort.Strings(changes)
-		content := w.pickContent(hasDown, hasUp)
-		desc := "- " + strings.Join(changes, "\n- ")
-		dir := func() ChangeDirection {
-			switch {
-			case hasDown && hasUp:
-				return DirMixed
-			case hasDown:
-				return DirDown
-			case hasUp:
-				return DirUp
-			default:
-				return DirNone
-			}
-		}()
-		color := chooseColor(dir)
-		if en, ok := w.Notifier.(embedNotifier); ok {
-			embed := &discordgo.MessageEmbed{
-				Title:       "📞 端末のState変更",
-				Description: desc,
-				Color:       color,
-				Timestamp:   time.Now().Format(time.RFC3339),
+	w.markPoll(nil)
+	w.pollSucceeded()
+	curPeer := peerStates(peers)
+	curProv := providerStates(regs)
+	w.exportStates(metrics.PeerHealth, metrics.PeerState, curPeer)
+	w.exportStates(metrics.ProviderHealth, metrics.ProviderState, curProv)
+	now := time.Now()
+	pc := w.routeIncidents("peer", "端末", w.filterChanges("peer", "peer", "⏸ 停止中に発生した変更", w.diffPeers(ctx, curPeer), now), now)
+	rc := w.routeIncidents("provider", "プロバイダ", w.filterChanges("provider", "provider", "⏸ 停止中に発生した変更", w.diffProviders(curProv), now), now)
+	all := changeSet{
+		lines:       append(pc.lines, rc.lines...),
+		changes:     append(pc.changes, rc.changes...),
+		hasUp:       pc.hasUp || rc.hasUp,
+		hasDown:     pc.hasDown || rc.hasDown,
+		hasDegraded: pc.hasDegraded || rc.hasDegraded,
+		hasLateral:  pc.hasLateral || rc.hasLateral,
+	}
+	if len(all.lines) > 0 {
+		since := w.resumedFrom.Local().Format("2006-01-02 15:04:05")
+		w.notifyChanges("⏸ 停止中に発生した変更", "寝てる間になにかあったみたい…", all,
+			fmt.Sprintf("前回保存: %s 〜 起動まで", since))
+	}
+	w.lastPeer = curPeer
+	w.lastProv = curProv
+	w.resumedFrom = nil
+	w.trackMaintenance(now)
+	w.saveState()
+}
+
+func (w *Watcher) restore(snap *Snapshot) {
+	if snap.Peers != nil {
+		w.lastPeer = snap.Peers
+	}
+	if snap.Providers != nil {
+		w.lastProv = snap.Providers
+	}
+	if snap.PeerNames != nil {
+		w.peerNameCache = snap.PeerNames
+	}
+	if snap.UnreachableSince != nil {
+		// 到達不能のまま再起動した: 復旧したら停止時間を通算して通知する
+		w.down = outage{since: *snap.UnreachableSince, reported: true, messageID: snap.UnreachableMessageID, lastNotice: snap.SavedAt}
+	}
+	if snap.Incidents != nil {
+		w.incidents = snap.Incidents
+	}
+	if len(w.lastPeer) > 0 || len(w.lastProv) > 0 {
+		t := snap.SavedAt
+		w.resumedFrom = &t
+	}
+}
+
+func (w *Watcher) saveState() {
+	if w.Store == nil {
+		return
+	}
+	snap := &Snapshot{
+		Peers:     w.lastPeer,
+		Providers: w.lastProv,
+		PeerNames: w.peerNameCache,
+		Incidents: w.incidents,
+		SavedAt:   time.Now(),
+	}
+	if w.down.reported {
+		since := w.down.since
+		snap.UnreachableSince = &since
+		snap.UnreachableMessageID = w.down.messageID
+	}
+	if err := w.Store.Save(snap); err != nil {
+		w.logf("state save error: %v", err)
+	}
+}
+
+// 差分の集計結果
+type changeSet struct {
+	lines       []string
+	changes     []stateChange // diffStates で検出した個々の変化（lines と同順）
+	hasUp       bool          // 改善
+	hasDown     bool          // Downへの悪化
+	hasDegraded bool          // Degradedへの悪化
+	hasLateral  bool          // 同じ区分内の変化
+}
+
+// stateChange は1つのIDのstate変化
+type stateChange struct {
+	id, label  string
+	from, to   string
+	fromH, toH Health
+	text       string   // 空でなければ line() の代わりに出す（フラッピングの開始/終了など）
+	mentions   []string // ルーティングのルールでメンションするロール
+}
+
+func (ch stateChange) line() string {
+	if ch.text != "" {
+		return ch.text
+	}
+	return fmt.Sprintf("%s %s: %s → %s", ch.toH.emoji(), ch.label, stateLabel(ch.from), stateLabel(ch.to))
+}
+
+// add は変化を1件加える
+func (c *changeSet) add(ch stateChange) {
+	c.mark(ch.fromH, ch.toH)
+	c.changes = append(c.changes, ch)
+	c.lines = append(c.lines, ch.line())
+}
+
+// mark は from -> to の区分変化を記録する
+func (c *changeSet) mark(from, to Health) {
+	switch {
+	case to > from:
+		c.hasUp = true
+	case to < from && to == Down:
+		c.hasDown = true
+	case to < from:
+		c.hasDegraded = true
+	default:
+		c.hasLateral = true
+	}
+}
+
+// mentions は変化に付いたロールのメンションを重複なく返す
+func (c changeSet) mentions() []string {
+	var out []string
+	for _, ch := range c.changes {
+		for _, id := range ch.mentions {
+			if !slices.Contains(out, id) {
+				out = append(out, id)
 			}
-			_ = en.NotifyEmbed(content, embed)
-		} else {
-			_ = w.Notifier.Notify(content + "\n" + desc)
 		}
 	}
-	w.lastPeer = cur
+	return out
 }
 
-func (w *Watcher) diffAndNotifyProviders(regs mikopbx.RegistryResponse) {
+func (c changeSet) worsened() bool { return c.hasDown || c.hasDegraded }
+
+func (c changeSet) direction() ChangeDirection {
+	switch {
+	case c.worsened() && c.hasUp:
+		return DirMixed
+	case c.hasDown:
+		return DirDown
+	case c.hasDegraded:
+		return DirDegraded
+	case c.hasUp:
+		return DirUp
+	case c.hasLateral:
+		return DirLateral
+	default:
+		return DirNone
+	}
+}
+
+func peerStates(peers mikopbx.PeersStatusesResponse) map[string]string {
+	cur := map[string]string{}
+	for _, p := range peers.Data {
+		cur[p.ID] = p.State
+	}
+	return cur
+}
+
+func providerStates(regs mikopbx.RegistryResponse) map[string]string {
 	cur := map[string]string{}
 	for _, r := range regs.Data {
 		cur[r.ID] = r.State
 	}
+	return cur
+}
+
+func (w *Watcher) diffAndNotifyPeers(ctx context.Context, peers mikopbx.PeersStatusesResponse) {
+	raw := peerStates(peers)
+	// First snapshot: just store and return (no spam)
+	if len(w.lastPeer) == 0 {
+		w.lastPeer = raw
+		return
+	}
+	cur, flaps := w.settle(w.peerTrack, w.lastPeer, raw, w.States.Classify, func(id string) string {
+		return "端末 " + w.resolvePeerLabel(ctx, id)
+	})
+	now := time.Now()
+	if flaps = w.filterChanges("peer", "", "〰️ 端末のフラッピング", flaps, now); len(flaps.lines) > 0 {
+		w.notifyChanges("〰️ 端末のフラッピング", w.pickContent(flaps.worsened(), flaps.hasUp), flaps, "")
+	}
+	cs := w.routeIncidents("peer", "端末", w.filterChanges("peer", "peer", "📞 端末のState変更", w.diffPeers(ctx, cur), now), now)
+	if len(cs.lines) > 0 {
+		w.notifyChanges("📞 端末のState変更", w.pickContent(cs.worsened(), cs.hasUp), cs, "")
+	}
+	w.lastPeer = cur
+}
+
+func (w *Watcher) diffPeers(ctx context.Context, cur map[string]string) changeSet {
+	return w.diffStates(w.lastPeer, cur, func(id string) string {
+		return "端末 " + w.resolvePeerLabel(ctx, id)
+	})
+}
+
+func (w *Watcher) diffAndNotifyProviders(regs mikopbx.RegistryResponse) {
+	raw := providerStates(regs)
 	if len(w.lastProv) == 0 {
-		w.lastProv = cur
+		w.lastProv = raw
 		return
 	}
-	var changes []string
-	hasUp := false
-	hasDown := false
-	for id, state := range cur {
-		prev, ok := w.lastProv[id]
-		if !ok {
-			if isProviderOnline(state) {
-				changes = append(changes, fmt.Sprintf("プロバイダ %s: オフライン → オンライン", id))
-				hasUp = true
-			}
+	cur, flaps := w.settle(w.provTrack, w.lastProv, raw, w.States.Classify, func(id string) string {
+		return "プロバイダ " + id
+	})
+	now := time.Now()
+	if flaps = w.filterChanges("provider", "", "〰️ プロバイダのフラッピング", flaps, now); len(flaps.lines) > 0 {
+		w.notifyChanges("〰️ プロバイダのフラッピング", "あれれ〜なんかあったみたいだよ〜", flaps, "")
+	}
+	cs := w.routeIncidents("provider", "プロバイダ", w.filterChanges("provider", "provider", "🌐 プロバイダのステート変更を検知", w.diffProviders(cur), now), now)
+	if len(cs.lines) > 0 {
+		w.notifyChanges("🌐 プロバイダのステート変更を検知", "あれれ〜なんかあったみたいだよ〜", cs, "")
+	}
+	w.lastProv = cur
+}
+
+func (w *Watcher) diffProviders(cur map[string]string) changeSet {
+	return w.diffStates(w.lastProv, cur, func(id string) string {
+		return "プロバイダ " + id
+	})
+}
+
+// diffStates は前回と今回のstateを比べ、変化した行を返す。
+// 未検出("")は Down と同じ扱いで、Down同士の出入り（未検出 → OFF 等）は通知しない。
+func (w *Watcher) diffStates(last, cur map[string]string, label func(string) string) changeSet {
+	var cs changeSet
+	ids := map[string]struct{}{}
+	for id := range cur {
+		ids[id] = struct{}{}
+	}
+	for id := range last {
+		ids[id] = struct{}{}
+	}
+	for id := range ids {
+		prev, state := last[id], cur[id]
+		if strings.EqualFold(prev, state) {
 			continue
 		}
-		if isProviderOnline(prev) != isProviderOnline(state) {
-			from := "オフライン"
-			to := "オンライン"
-			if isProviderOnline(prev) && !isProviderOnline(state) {
-				from, to = "オンライン", "オフライン"
-				hasDown = true
-			} else {
-				hasUp = true
-			}
-			changes = append(changes, fmt.Sprintf("プロバイダ %s: %s → %s", id, from, to))
-		}
-	}
-	for id, prev := range w.lastProv {
-		if _, ok := cur[id]; !ok {
-			if isProviderOnline(prev) {
-				changes = append(changes, fmt.Sprintf("プロバイダ %s: オンライン → オフライン", id))
-				hasDown = true
-			}
+		from, to := w.States.Classify(prev), w.States.Classify(state)
+		if (prev == "" || state == "") && from == Down && to == Down {
+			continue
 		}
+		cs.add(stateChange{id: id, label: label(id), from: prev, to: state, fromH: from, toH: to})
 	}
-	if len(changes) > 0 && w.Notifier != nil {
-		sort.Strings(changes)
-		content := "あれれ〜なんかあったみたいだよ〜"
-		desc := "- " + strings.Join(changes, "\n- ")
-		dir := func() ChangeDirection {
-			switch {
-			case hasDown && hasUp:
-				return DirMixed
-			case hasDown:
-				return DirDown
-			case hasUp:
-				return DirUp
-			default:
-				return DirNone
-			}
-		}()
-		color := chooseColor(dir)
-		if en, ok := w.Notifier.(embedNotifier); ok {
-			embed := &discordgo.MessageEmbed{
-				Title:       "🌐 プロバイダのステート変更を検知",
-				Description: desc,
-				Color:       color,
-				Timestamp:   time.Now().Format(time.RFC3339),
-			}
-			_ = en.NotifyEmbed(content, embed)
-		} else {
-			_ = w.Notifier.Notify(content + "\n" + desc)
-		}
+	return cs
+}
+
+// filterChanges はメンテナンス中の変化を止め、残りをルーティングのルールで振り分ける。
+// incidentKey はメンテナンス前から開いているインシデント（フラッピングなら空）。
+func (w *Watcher) filterChanges(kind, incidentKey, title string, cs changeSet, now time.Time) changeSet {
+	return w.applyRouting(kind, title, w.holdMaintenance(incidentKey, title, cs, now), now)
+}
+
+// 変更一覧をEmbed（非対応ならテキスト）で通知する。footer は空なら省略。
+func (w *Watcher) notifyChanges(title, content string, cs changeSet, footer string) {
+	w.notifyChangesTo(w.Notifier, title, content, cs, footer)
+}
+
+// notifyChangesTo は変更一覧を n へ送る。悪化なら当番、ルールで指定されたロールをメンションする。
+func (w *Watcher) notifyChangesTo(n Notifier, title, content string, cs changeSet, footer string) {
+	if cs.worsened() {
+		content = w.alertContent(content)
 	}
-	w.lastProv = cur
+	content = roleMentions(cs.mentions(), content)
+	w.postChangesTo(n, title, content, cs, footer, chooseColor(cs.direction()))
 }
 
-func isPeerOnline(state string) bool {
-	// Docs show peer state e.g., "OK", "UNKNOWN". Treat OK as online; others offline.
-	return strings.EqualFold(state, "OK")
+// postChanges は変更一覧をメンションを付けずに color で送る
+func (w *Watcher) postChanges(title, content string, cs changeSet, footer string, color int) {
+	w.postChangesTo(w.Notifier, title, content, cs, footer, color)
 }
 
-func isProviderOnline(state string) bool {
-	// Provider registry state shown as "OK" or "OFF".
-	return strings.EqualFold(state, "OK")
+func (w *Watcher) postChangesTo(n Notifier, title, content string, cs changeSet, footer string, color int) {
+	if n == nil {
+		return
+	}
+	sort.Strings(cs.lines)
+	desc := "- " + strings.Join(cs.lines, "\n- ")
+	if en, ok := n.(embedNotifier); ok {
+		embed := &discordgo.MessageEmbed{
+			Title:       w.title(title),
+			Description: desc,
+			Color:       color,
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}
+		if footer != "" {
+			embed.Footer = &discordgo.MessageEmbedFooter{Text: footer}
+		}
+		_ = en.NotifyEmbed(content, embed)
+	} else {
+		text := content + "\n" + w.title(title) + "\n" + desc
+		if footer != "" {
+			text += "\n" + footer
+		}
+		_ = n.Notify(text)
+	}
 }
 
 func chooseColor(dir ChangeDirection) int {
@@ -283,13 +624,17 @@ func chooseColor(dir ChangeDirection) int {
 		return 0x2ECC71 // green
 	case DirMixed:
 		return 0xF1C40F // yellow
+	case DirDegraded:
+		return 0xE67E22 // orange
+	case DirLateral:
+		return 0x3498DB // blue
 	default:
 		return 0x95A5A6 // gray (念のため)
 	}
 }
 
 // ラベル解決（名前が取れれば「名前(ID)」形式、なければIDのみ）
-func (w *Watcher) resolvePeerLabel(id string) string {
+func (w *Watcher) resolvePeerLabel(ctx context.Context, id string) string {
 	if id == "" {
 		return id
 	}
@@ -299,9 +644,11 @@ func (w *Watcher) resolvePeerLabel(id string) string {
 		}
 		return id
 	}
-	name, err := w.Client.GetPeerName(id)
+	name, err := w.Client.GetPeerName(ctx, id)
 	if err != nil {
-		log.Printf("resolvePeerLabel error for %s: %v", id, err)
+		// 一時的な失敗はキャッシュせず次回また引く
+		w.logf("resolvePeerLabel error for %s: %v", id, err)
+		return id
 	}
 	w.peerNameCache[id] = name
 	if name != "" {
@@ -310,6 +657,17 @@ func (w *Watcher) resolvePeerLabel(id string) string {
 	return id
 }
 
+// alertContent は悪化を知らせる本文の先頭にオンコール当番へのメンションを付ける
+func (w *Watcher) alertContent(content string) string {
+	if w.OnCall == nil {
+		return content
+	}
+	if id := w.OnCall(); id != "" {
+		return fmt.Sprintf("<@%s> %s", id, content)
+	}
+	return content
+}
+
 // おまけ本文のバリエーション選択（方向で差し替え）
 func (w *Watcher) pickContent(hasDown, hasUp bool) string {
 	// DOWNを含む: ネガティブ系